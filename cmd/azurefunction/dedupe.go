@@ -0,0 +1,65 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	retryNumHeader    = "X-Slack-Retry-Num"
+	retryReasonHeader = "X-Slack-Retry-Reason"
+	noRetryHeader     = "X-Slack-No-Retry"
+
+	// Slack retries 3 times, over roughly an hour and a half. Keep ids a bit longer than that.
+	defaultEventTTL = 2 * time.Hour
+)
+
+// eventCache remembers which Slack event ids have already been processed so retries
+// (eg after a slow cold start) don't run a command twice.
+// Only in memory, so each instance of the function has its own.
+type eventCache struct {
+	lock sync.Mutex
+	ttl  time.Duration
+	seen map[string]time.Time
+
+	// now is swappable so expiry can be checked against a fixed time.
+	now func() time.Time
+}
+
+func newEventCache(ttl time.Duration) *eventCache {
+	c := eventCache{}
+	c.ttl = ttl
+	if c.ttl <= 0 {
+		c.ttl = defaultEventTTL
+	}
+	c.seen = make(map[string]time.Time)
+	c.now = time.Now
+	return &c
+}
+
+// seenBefore records the event id and returns true if it had already been recorded
+// within the TTL. Empty ids are never considered seen.
+func (c *eventCache) seenBefore(eventID string) bool {
+	if eventID == "" {
+		return false
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+
+	// small number of events, just prune on every call.
+	for id, t := range c.seen {
+		if now.Sub(t) > c.ttl {
+			delete(c.seen, id)
+		}
+	}
+
+	if _, ok := c.seen[eventID]; ok {
+		return true
+	}
+
+	c.seen[eventID] = now
+	return false
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEventCache(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	c := newEventCache(time.Hour)
+	c.now = func() time.Time { return now }
+
+	if c.seenBefore("Ev1") {
+		t.Error("first delivery shouldn't have been seen")
+	}
+	if !c.seenBefore("Ev1") {
+		t.Error("retry should have been seen")
+	}
+	if c.seenBefore("Ev2") {
+		t.Error("a different event shouldn't have been seen")
+	}
+
+	// empty ids can't be told apart, so they're always processed.
+	if c.seenBefore("") || c.seenBefore("") {
+		t.Error("empty ids should never be seen")
+	}
+}
+
+func TestEventCacheExpiry(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	c := newEventCache(time.Hour)
+	c.now = func() time.Time { return now }
+
+	c.seenBefore("Ev1")
+	now = now.Add(59 * time.Minute)
+	if !c.seenBefore("Ev1") {
+		t.Error("should still be seen within the TTL")
+	}
+
+	// the TTL runs from the first delivery, not the retry.
+	now = now.Add(2 * time.Minute)
+	if c.seenBefore("Ev1") {
+		t.Error("should have expired after the TTL")
+	}
+	if len(c.seen) != 1 {
+		t.Errorf("expected expired ids to be pruned, %d left", len(c.seen))
+	}
+}
+
+func TestEventCacheDefaultTTL(t *testing.T) {
+	if c := newEventCache(0); c.ttl != defaultEventTTL {
+		t.Errorf("expected %s, got %s", defaultEventTTL, c.ttl)
+	}
+}
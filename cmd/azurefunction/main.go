@@ -2,43 +2,83 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/kpfaulkner/wheatley/logging"
 	"github.com/kpfaulkner/wheatley/messagehandlers"
 	"github.com/slack-go/slack"
 	"github.com/slack-go/slack/slackevents"
-	"log"
+	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
+	"strings"
+	"time"
 )
 
 type SlackServer struct {
-	slackApi          *slack.Client
-	token             string
-	verificationToken string
-	handlers          []messagehandlers.MessageHandler
+	slackApi  *slack.Client
+	token     string
+	botUserID string
+	verifier  *requestVerifier
+	events    *eventCache
+	pipeline  *messagehandlers.Pipeline
+	responder messagehandlers.Responder
 }
 
-func NewSlackServer(token string, verificationToken string) SlackServer {
+func NewSlackServer(cfg *config.Config, policy *messagehandlers.Policy, approvals *messagehandlers.ApprovalManager, audit messagehandlers.AuditSink) (*SlackServer, error) {
 	ss := SlackServer{}
-	ss.token = token
-	ss.verificationToken = verificationToken
-	ss.slackApi = slack.New(token)
-	ss.handlers = azureFunctionGetMessageHandlers()
+	ss.token = cfg.Slack.BotToken
+	ss.verifier = newRequestVerifier(cfg.Slack.SigningSecret, time.Duration(cfg.Slack.ReplayWindow))
+	ss.events = newEventCache(defaultEventTTL)
+	ss.slackApi = slack.New(ss.token)
+	ss.responder = messagehandlers.NewWebAPIResponder(ss.slackApi)
 
-	return ss
+	// need to know who we are so we don't answer ourselves.
+	auth, err := ss.slackApi.AuthTest()
+	if err != nil {
+		return nil, err
+	}
+	ss.botUserID = auth.UserID
+
+	handlers, err := messagehandlers.NewMessageHandlers(cfg)
+	if err != nil {
+		return nil, err
+	}
+	router := messagehandlers.NewRouter(handlers...)
+	router.SetPolicy(policy)
+	router.SetApprovals(approvals)
+	router.Register(messagehandlers.NewAuditHandler(audit))
+	if err := router.CheckPolicy(); err != nil {
+		return nil, err
+	}
+	filter := messagehandlers.NewEventFilter(cfg.Filter, auth.UserID, auth.BotID)
+	activation := messagehandlers.NewActivationPolicy(cfg.Activation, auth.UserID)
+	ss.pipeline = messagehandlers.NewPipeline(ss.slackApi, router, filter, activation)
+	ss.pipeline.SetAudit(audit)
+	return &ss, nil
 }
 
 func (s *SlackServer) slackHttp(w http.ResponseWriter, r *http.Request) {
 	buf := new(bytes.Buffer)
 	buf.ReadFrom(r.Body)
 	body := buf.String()
-	eventsAPIEvent, e := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionVerifyToken(&slackevents.TokenComparator{VerificationToken: s.verificationToken}))
-	if e != nil {
+
+	err := s.verifier.verify(r.Header, buf.Bytes())
+	if err != nil {
+		logging.Errorf("rejecting request : %s", err.Error())
 		w.WriteHeader(http.StatusUnauthorized)
 		return
 	}
 
+	// signature has already been checked, the verification token is deprecated.
+	eventsAPIEvent, e := slackevents.ParseEvent(json.RawMessage(body), slackevents.OptionNoVerifyToken())
+	if e != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
 	// used for event API verification that we're a legit bot for
 	// this slack cct.
 	if eventsAPIEvent.Type == slackevents.URLVerification {
@@ -46,6 +86,7 @@ func (s *SlackServer) slackHttp(w http.ResponseWriter, r *http.Request) {
 		err := json.Unmarshal([]byte(body), &r)
 		if err != nil {
 			w.WriteHeader(http.StatusInternalServerError)
+			return
 		}
 		w.Header().Set("Content-Type", "text")
 		w.Write([]byte(r.Challenge))
@@ -54,38 +95,123 @@ func (s *SlackServer) slackHttp(w http.ResponseWriter, r *http.Request) {
 	// dealing with actual messages. At the moment we're just after
 	// basic messaging.... no fancy events subscribed to already.
 	if eventsAPIEvent.Type == slackevents.CallbackEvent {
+
+		// Slack resends events it thinks we didn't get. If we've already seen it, just ack
+		// and make sure each event only ever runs a command once.
+		if cbEvent, ok := eventsAPIEvent.Data.(*slackevents.EventsAPICallbackEvent); ok {
+			if retryNum := r.Header.Get(retryNumHeader); retryNum != "" {
+				logging.Warnf("event %s is retry %s due to %s", cbEvent.EventID, retryNum, r.Header.Get(retryReasonHeader))
+			}
+
+			if s.events.seenBefore(cbEvent.EventID) {
+				logging.Warnf("event %s already processed, ignoring", cbEvent.EventID)
+				w.Header().Set(noRetryHeader, "1")
+				w.WriteHeader(http.StatusOK)
+				return
+			}
+		}
+
 		innerEvent := eventsAPIEvent.InnerEvent
 
+		var msg messagehandlers.IncomingMessage
 		switch ev := innerEvent.Data.(type) {
 		case *slackevents.MessageEvent:
 
-			// return 200 immediately... according to https://api.slack.com/events-api#prepare
-			// otherwise if we dont return in 3seconds the delivery is considered to have failed and we'll get another
-			// message. So can return 200 immediately but then the code that processes the messages can
-			// return their results later on
-			w.WriteHeader(http.StatusOK)
-
-			// loop through all handlers and reply.
-			for _, handler := range s.handlers {
-				//handler, _ := handlerInterface.(messagehandlers.MessageHandler)
-
-				// not a GO channel... but just using slack-go terminology
-				go func(text string, user string, channelID string, h messagehandlers.MessageHandler) {
-					u, err := s.slackApi.GetUserInfo(user)
-					if err != nil {
-						// error.....  die a mysterious death..
-						return
-					}
-					msg, _ := h.ParseMessage(text, u.Name)
-					s.slackApi.PostMessage(channelID, slack.MsgOptionText(msg, false))
-				}(ev.Text, ev.User, ev.Channel, handler)
+			// mentions also arrive as app_mention events, so only handle them once.
+			if strings.Contains(ev.Text, "<@"+s.botUserID+">") {
+				w.WriteHeader(http.StatusOK)
+				return
 			}
+			msg = messagehandlers.NewIncomingMessageFromEvent(ev)
+
+		case *slackevents.AppMentionEvent:
+			msg = messagehandlers.NewIncomingMessageFromMention(ev)
+
+		default:
+			return
+		}
+
+		// return 200 immediately... according to https://api.slack.com/events-api#prepare
+		// otherwise if we dont return in 3seconds the delivery is considered to have failed and we'll get another
+		// message. So can return 200 immediately but then the code that processes the messages can
+		// return their results later on
+		w.WriteHeader(http.StatusOK)
+
+		// not using the request context, that's gone as soon as we return.
+		if cbEvent, ok := eventsAPIEvent.Data.(*slackevents.EventsAPICallbackEvent); ok {
+			msg.EventID = cbEvent.EventID
 		}
+		go s.pipeline.HandleMessage(context.Background(), msg, s.responder)
+	}
+}
+
+// interactivityHttp gets button clicks and modal submissions. Slack sends them form encoded,
+// with the JSON in the payload field.
+func (s *SlackServer) interactivityHttp(w http.ResponseWriter, r *http.Request) {
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+
+	err := s.verifier.verify(r.Header, buf.Bytes())
+	if err != nil {
+		logging.Errorf("rejecting interaction : %s", err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	form, err := url.ParseQuery(buf.String())
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	var callback slack.InteractionCallback
+	err = json.Unmarshal([]byte(form.Get("payload")), &callback)
+	if err != nil {
+		logging.Errorf("unable to parse interaction : %s", err.Error())
+		w.WriteHeader(http.StatusBadRequest)
+		return
 	}
+
+	// same 3 second rule as events. An empty 200 also closes a submitted modal.
+	w.WriteHeader(http.StatusOK)
+
+	interaction, ok := messagehandlers.NewInteractionFromCallback(&callback)
+	if !ok {
+		return
+	}
+	go s.pipeline.HandleInteraction(context.Background(), interaction, s.responder)
+}
+
+// slashCommandHttp gets slash commands, eg /wheatley check prod. The reply goes via the command's
+// response_url, so we can answer Slack straight away and take as long as the command needs.
+func (s *SlackServer) slashCommandHttp(w http.ResponseWriter, r *http.Request) {
+	buf := new(bytes.Buffer)
+	buf.ReadFrom(r.Body)
+
+	err := s.verifier.verify(r.Header, buf.Bytes())
+	if err != nil {
+		logging.Errorf("rejecting slash command : %s", err.Error())
+		w.WriteHeader(http.StatusUnauthorized)
+		return
+	}
+
+	// already read the body to check the signature.
+	r.Body = ioutil.NopCloser(bytes.NewReader(buf.Bytes()))
+	cmd, err := slack.SlashCommandParse(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	// an empty 200 within 3 seconds, the reply comes later.
+	w.WriteHeader(http.StatusOK)
+	go s.pipeline.HandleSlashCommand(context.Background(), messagehandlers.NewSlashCommandFromSlack(cmd))
 }
 
 func (s *SlackServer) routes() {
 	http.HandleFunc("/events-endpoint", s.slackHttp)
+	http.HandleFunc("/interactivity", s.interactivityHttp)
+	http.HandleFunc("/slash-command", s.slashCommandHttp)
 }
 
 func (s *SlackServer) run() {
@@ -93,28 +219,50 @@ func (s *SlackServer) run() {
 	if !exists {
 		port = "3000"
 	}
-	fmt.Printf("port used is %s\n", port)
-	log.Fatal(http.ListenAndServe(":"+port, nil))
-}
-
-func azureFunctionGetMessageHandlers() []messagehandlers.MessageHandler {
-	misc := messagehandlers.NewMiscMessageHandler()
-	sh := messagehandlers.NewServerStatusMessageHandler()
-	ah := messagehandlers.NewAzureStatusMessageHandler()
-	dbh := messagehandlers.NewDatabaseBackupMessageHandler()
-
-	handlers := []messagehandlers.MessageHandler{misc, sh, ah, dbh}
-	return handlers
+	logging.Infof("port used is %s", port)
+	logging.Fatalf("%s", http.ListenAndServe(":"+port, nil))
 }
 
 // Due to running as an Azure Function (no websockets for us)
 // this version will be using the events and web APIs.
 func main() {
+	// LOG_LEVEL and LOG_FORMAT, see logging.NewFromEnv
+	logger, err := logging.NewFromEnv(os.Stdout)
+	if err != nil {
+		logging.Fatalf("Cannot set up logging : %s", err.Error())
+	}
+	logging.SetDefault(logger)
+
+	logging.Infof("hello world :)")
+
+	// WHEATLEY_CONFIG, default wheatley.json
+	cfg, err := config.Load(config.FileName())
+	if err != nil {
+		logging.Fatalf("Cannot load config : %s", err.Error())
+	}
+
+	// requests can't be trusted without it.
+	if cfg.Slack.SigningSecret == "" {
+		logging.Fatalf("Slack.SigningSecret (or SLACK_SIGNING_SECRET) must be set")
+	}
+
+	policy := messagehandlers.NewPolicy(cfg.Policy)
+
+	audit, err := messagehandlers.NewJSONLAuditSink(cfg.Files.AuditLog)
+	if err != nil {
+		logging.Fatalf("Cannot open audit log : %s", err.Error())
+	}
+	defer audit.Close()
+	approvals := messagehandlers.NewApprovalManager(policy, messagehandlers.DefaultApprovalTimeout, audit)
+
+	s, err := NewSlackServer(cfg, policy, approvals, audit)
+	if err != nil {
+		logging.Fatalf("Unable to start : %s", err.Error())
+	}
+
+	// picks up rotated secrets, after the handlers so it knows which ones they read themselves.
+	go cfg.WatchSecrets(context.Background())
 
-	fmt.Printf("hello world :)\n")
-	slackKey := os.Getenv("SLACK_KEY")
-	verificationToken := os.Getenv("SLACK_VERIFICATION_TOKEN")
-	s := NewSlackServer(slackKey, verificationToken)
 	s.routes()
 	s.run()
 }
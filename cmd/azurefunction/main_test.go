@@ -0,0 +1,204 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/kpfaulkner/wheatley/messagehandlers"
+	"github.com/slack-go/slack"
+)
+
+// fakeSlack is just enough of the Web API for a message to get answered, every chat.postMessage
+// ends up in posted.
+type fakeSlack struct {
+	server *httptest.Server
+	posted chan url.Values
+}
+
+func newFakeSlack(t *testing.T) *fakeSlack {
+	f := fakeSlack{posted: make(chan url.Values, 10)}
+	f.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users.info":
+			w.Write([]byte(`{"ok":true,"user":{"id":"U012AB3CD","name":"someone","profile":{}}}`))
+		case "/usergroups.list":
+			w.Write([]byte(`{"ok":true,"usergroups":[]}`))
+		case "/chat.postMessage":
+			f.posted <- r.Form
+			w.Write([]byte(`{"ok":true,"channel":"C012AB3CD","ts":"1600000001.000100"}`))
+		default:
+			t.Errorf("unexpected call to %s", r.URL.Path)
+			w.Write([]byte(`{"ok":false,"error":"unknown_method"}`))
+		}
+	}))
+	return &f
+}
+
+const testBotUserID = "U0BOT0000"
+
+// newTestServer is the events endpoint with the misc handler, talking to slackAPI. It answers
+// every message, mentioned or not.
+func newTestServer(slackAPI *fakeSlack) *httptest.Server {
+	api := slack.New("xoxb-test", slack.OptionAPIURL(slackAPI.server.URL+"/"))
+	router := messagehandlers.NewRouter(messagehandlers.NewMiscMessageHandler())
+	activation := messagehandlers.NewActivationPolicy(config.ActivationConfig{Mode: config.ActivationAll}, testBotUserID)
+
+	ss := SlackServer{}
+	ss.slackApi = api
+	ss.botUserID = testBotUserID
+	ss.verifier = newRequestVerifier(testSigningSecret, defaultReplayWindow)
+	ss.events = newEventCache(defaultEventTTL)
+	ss.responder = messagehandlers.NewWebAPIResponder(api)
+	ss.pipeline = messagehandlers.NewPipeline(api, router, nil, activation)
+	return httptest.NewServer(http.HandlerFunc(ss.slackHttp))
+}
+
+func post(t *testing.T, serverURL string, header http.Header, body string) (*http.Response, string) {
+	req, err := http.NewRequest(http.MethodPost, serverURL+"/events-endpoint", bytes.NewReader([]byte(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header = header
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := ioutil.ReadAll(resp.Body)
+	return resp, string(b)
+}
+
+const (
+	urlVerificationBody = `{"token":"Jhj5dZrVaK7ZwHHjRyZWjbDl","challenge":"3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P","type":"url_verification"}`
+	messageEventBody    = `{"token":"Jhj5dZrVaK7ZwHHjRyZWjbDl","team_id":"T012AB3CD","api_app_id":"A012AB3CD","type":"event_callback","event_id":"Ev012AB3CD","event_time":1600000000,` +
+		`"event":{"type":"message","channel":"C012AB3CD","user":"U012AB3CD","text":"hello","ts":"1600000000.000100","channel_type":"channel"}}`
+)
+
+func TestURLVerification(t *testing.T) {
+	slackAPI := newFakeSlack(t)
+	defer slackAPI.server.Close()
+	server := newTestServer(slackAPI)
+	defer server.Close()
+
+	resp, body := post(t, server.URL, signedHeader(testSigningSecret, time.Now(), []byte(urlVerificationBody)), urlVerificationBody)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+	if body != "3eZbrw1aBm2rZgRNFdxV2595E9CY3gmdALWMmHkvFXO7tYXAYM8P" {
+		t.Errorf("expected the challenge back, got %q", body)
+	}
+}
+
+func TestValidCallback(t *testing.T) {
+	slackAPI := newFakeSlack(t)
+	defer slackAPI.server.Close()
+	server := newTestServer(slackAPI)
+	defer server.Close()
+
+	resp, _ := post(t, server.URL, signedHeader(testSigningSecret, time.Now(), []byte(messageEventBody)), messageEventBody)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected 200, got %d", resp.StatusCode)
+	}
+
+	select {
+	case form := <-slackAPI.posted:
+		if form.Get("channel") != "C012AB3CD" || form.Get("text") != "alive and well" {
+			t.Errorf("unexpected reply %v", form)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply posted")
+	}
+
+	// a retry of the same event is acked, but not run again.
+	header := signedHeader(testSigningSecret, time.Now(), []byte(messageEventBody))
+	header.Set(retryNumHeader, "1")
+	header.Set(retryReasonHeader, "http_timeout")
+	resp, _ = post(t, server.URL, header, messageEventBody)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get(noRetryHeader) != "1" {
+		t.Errorf("expected retry to be acked with %s, got %d %v", noRetryHeader, resp.StatusCode, resp.Header)
+	}
+	select {
+	case form := <-slackAPI.posted:
+		t.Errorf("retry was answered again : %v", form)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestAppMention(t *testing.T) {
+	slackAPI := newFakeSlack(t)
+	defer slackAPI.server.Close()
+	server := newTestServer(slackAPI)
+	defer server.Close()
+
+	// the same mention arrives as both a message and an app_mention, it should only be answered once.
+	for _, body := range []string{
+		`{"type":"event_callback","event_id":"Ev0MESSAGE","event_time":1600000000,` +
+			`"event":{"type":"message","channel":"C012AB3CD","user":"U012AB3CD","text":"<@U0BOT0000> hello","ts":"1600000000.000100","channel_type":"channel"}}`,
+		`{"type":"event_callback","event_id":"Ev0MENTION","event_time":1600000000,` +
+			`"event":{"type":"app_mention","channel":"C012AB3CD","user":"U012AB3CD","text":"<@U0BOT0000> hello","ts":"1600000000.000100"}}`,
+	} {
+		resp, _ := post(t, server.URL, signedHeader(testSigningSecret, time.Now(), []byte(body)), body)
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected 200, got %d", resp.StatusCode)
+		}
+	}
+
+	select {
+	case form := <-slackAPI.posted:
+		if form.Get("text") != "alive and well" {
+			t.Errorf("unexpected reply %v", form)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply to the mention")
+	}
+	select {
+	case form := <-slackAPI.posted:
+		t.Errorf("mention was answered twice : %v", form)
+	case <-time.After(200 * time.Millisecond):
+	}
+}
+
+func TestRejectedRequests(t *testing.T) {
+	tests := []struct {
+		name   string
+		header http.Header
+	}{
+		{"stale timestamp", signedHeader(testSigningSecret, time.Now().Add(-10*time.Minute), []byte(messageEventBody))},
+		{"bad signature", signedHeader("not the secret", time.Now(), []byte(messageEventBody))},
+		{"unsigned", http.Header{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			slackAPI := newFakeSlack(t)
+			defer slackAPI.server.Close()
+			server := newTestServer(slackAPI)
+			defer server.Close()
+
+			resp, body := post(t, server.URL, tc.header, messageEventBody)
+			if resp.StatusCode != http.StatusUnauthorized {
+				t.Errorf("expected 401, got %d", resp.StatusCode)
+			}
+			if strings.Contains(body, "challenge") {
+				t.Errorf("unexpected body %q", body)
+			}
+
+			select {
+			case form := <-slackAPI.posted:
+				t.Errorf("rejected request was answered : %v", form)
+			case <-time.After(200 * time.Millisecond):
+			}
+		})
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	signatureHeader  = "X-Slack-Signature"
+	timestampHeader  = "X-Slack-Request-Timestamp"
+	signatureVersion = "v0"
+
+	// defaultReplayWindow is how old (or far in the future) a request timestamp can be.
+	// Same as Slack recommends.
+	defaultReplayWindow = 5 * time.Minute
+)
+
+var (
+	ErrMissingSignature = errors.New("missing slack signature headers")
+	ErrStaleTimestamp   = errors.New("slack request timestamp outside replay window")
+	ErrBadSignature     = errors.New("slack signature mismatch")
+)
+
+// requestVerifier checks Slack's signed request headers.
+// See https://api.slack.com/authentication/verifying-requests-from-slack
+type requestVerifier struct {
+	signingSecret string
+	replayWindow  time.Duration
+
+	// now is swappable so the replay window can be checked against a fixed time.
+	now func() time.Time
+}
+
+func newRequestVerifier(signingSecret string, replayWindow time.Duration) *requestVerifier {
+	v := requestVerifier{}
+	v.signingSecret = signingSecret
+	v.replayWindow = replayWindow
+	if v.replayWindow <= 0 {
+		v.replayWindow = defaultReplayWindow
+	}
+	v.now = time.Now
+	return &v
+}
+
+// verify checks the signature over the raw body and that the timestamp is recent enough.
+func (v *requestVerifier) verify(header http.Header, body []byte) error {
+	signature := header.Get(signatureHeader)
+	timestamp := header.Get(timestampHeader)
+	if signature == "" || timestamp == "" {
+		return ErrMissingSignature
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return ErrStaleTimestamp
+	}
+
+	diff := v.now().Sub(time.Unix(ts, 0))
+	if diff > v.replayWindow || diff < -v.replayWindow {
+		return ErrStaleTimestamp
+	}
+
+	expected := computeSignature(v.signingSecret, timestamp, body)
+	if !hmac.Equal([]byte(expected), []byte(signature)) {
+		return ErrBadSignature
+	}
+
+	return nil
+}
+
+// computeSignature generates the v0=... value Slack would have sent for this body.
+func computeSignature(signingSecret string, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(signingSecret))
+	mac.Write([]byte(fmt.Sprintf("%s:%s:", signatureVersion, timestamp)))
+	mac.Write(body)
+	return signatureVersion + "=" + hex.EncodeToString(mac.Sum(nil))
+}
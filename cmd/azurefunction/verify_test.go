@@ -0,0 +1,120 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"testing"
+	"time"
+)
+
+const testSigningSecret = "8f742231b10e8888abcd99yyyzzz85a5"
+
+// signedHeader is the headers Slack would send with body at ts.
+func signedHeader(secret string, ts time.Time, body []byte) http.Header {
+	timestamp := strconv.FormatInt(ts.Unix(), 10)
+	header := http.Header{}
+	header.Set(timestampHeader, timestamp)
+	header.Set(signatureHeader, computeSignature(secret, timestamp, body))
+	return header
+}
+
+func TestComputeSignature(t *testing.T) {
+	// the example from https://api.slack.com/authentication/verifying-requests-from-slack
+	body := "token=xyzz0WbapA4vBCDEFasx0q6G&team_id=T1DC2JH3J&team_domain=testteamnow&channel_id=G8PSS9T3V&channel_name=foobar&user_id=U2CERLKJA&user_name=roadrunner&command=%2Fwebhook-collect&text=&response_url=https%3A%2F%2Fhooks.slack.com%2Fcommands%2FT1DC2JH3J%2F397700885554%2F96rGlfmibIGlgcZRskXaIFfN&trigger_id=398738663015.47445629121.803a0bc887a14d10d2c447fce8b6703c"
+	expected := "v0=a2114d57b48eac39b9ad189dd8316235a7b4a8d21a10bd27519666489c69b503"
+
+	if got := computeSignature(testSigningSecret, "1531420618", []byte(body)); got != expected {
+		t.Errorf("expected %s, got %s", expected, got)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	now := time.Unix(1600000000, 0)
+	body := []byte(`{"type":"event_callback"}`)
+
+	tests := []struct {
+		name   string
+		header func() http.Header
+		body   []byte
+		err    error
+	}{
+		{
+			name:   "valid",
+			header: func() http.Header { return signedHeader(testSigningSecret, now, body) },
+			body:   body,
+		},
+		{
+			name:   "valid, slightly old",
+			header: func() http.Header { return signedHeader(testSigningSecret, now.Add(-4*time.Minute), body) },
+			body:   body,
+		},
+		{
+			name:   "missing headers",
+			header: func() http.Header { return http.Header{} },
+			body:   body,
+			err:    ErrMissingSignature,
+		},
+		{
+			name: "missing signature",
+			header: func() http.Header {
+				h := signedHeader(testSigningSecret, now, body)
+				h.Del(signatureHeader)
+				return h
+			},
+			body: body,
+			err:  ErrMissingSignature,
+		},
+		{
+			name:   "stale timestamp",
+			header: func() http.Header { return signedHeader(testSigningSecret, now.Add(-6*time.Minute), body) },
+			body:   body,
+			err:    ErrStaleTimestamp,
+		},
+		{
+			name:   "timestamp in the future",
+			header: func() http.Header { return signedHeader(testSigningSecret, now.Add(6*time.Minute), body) },
+			body:   body,
+			err:    ErrStaleTimestamp,
+		},
+		{
+			name: "timestamp not a number",
+			header: func() http.Header {
+				h := signedHeader(testSigningSecret, now, body)
+				h.Set(timestampHeader, "yesterday")
+				return h
+			},
+			body: body,
+			err:  ErrStaleTimestamp,
+		},
+		{
+			name:   "wrong secret",
+			header: func() http.Header { return signedHeader("not the secret", now, body) },
+			body:   body,
+			err:    ErrBadSignature,
+		},
+		{
+			name:   "body changed",
+			header: func() http.Header { return signedHeader(testSigningSecret, now, body) },
+			body:   []byte(`{"type":"url_verification"}`),
+			err:    ErrBadSignature,
+		},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			v := newRequestVerifier(testSigningSecret, 5*time.Minute)
+			v.now = func() time.Time { return now }
+
+			if err := v.verify(tc.header(), tc.body); err != tc.err {
+				t.Errorf("expected %v, got %v", tc.err, err)
+			}
+		})
+	}
+}
+
+func TestDefaultReplayWindow(t *testing.T) {
+	v := newRequestVerifier(testSigningSecret, 0)
+	if v.replayWindow != defaultReplayWindow {
+		t.Errorf("expected %s, got %s", defaultReplayWindow, v.replayWindow)
+	}
+}
@@ -1,55 +1,77 @@
 package main
 
 import (
+	"context"
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/kpfaulkner/wheatley/logging"
 	"github.com/kpfaulkner/wheatley/messagehandlers"
 	"github.com/slack-go/slack"
-	"log"
 	"os"
 )
 
-func getMessageHandlers() []messagehandlers.MessageHandler {
-	misc := messagehandlers.NewMiscMessageHandler()
-	sh := messagehandlers.NewServerStatusMessageHandler()
-	ah := messagehandlers.NewAzureStatusMessageHandler()
-	dbh := messagehandlers.NewDatabaseBackupMessageHandler()
-	ach := messagehandlers.NewAzureCostMessageHandler()
-
-	handlers := []messagehandlers.MessageHandler{misc, sh, ah, dbh, ach}
-	return handlers
-}
+const socketModeMode = "socketmode"
 
+// Slack.Mode in the config (or SLACK_MODE) picks the transport, either "rtm" (default) or
+// "socketmode". Socket Mode also needs an app level token (xapp-...) in Slack.AppToken.
 func main() {
+	// LOG_LEVEL and LOG_FORMAT, see logging.NewFromEnv
+	logger, err := logging.NewFromEnv(os.Stdout)
+	if err != nil {
+		logging.Fatalf("Cannot set up logging : %s", err.Error())
+	}
+	logging.SetDefault(logger)
 
-	handlers := getMessageHandlers()
+	// WHEATLEY_CONFIG, default wheatley.json
+	cfg, err := config.Load(config.FileName())
+	if err != nil {
+		logging.Fatalf("Cannot load config : %s", err.Error())
+	}
 
-	slackKey := os.Getenv("SLACK_KEY")
-	api := slack.New(slackKey)
-	logger := log.New(os.Stdout, "slack-bot: ", log.Lshortfile|log.LstdFlags)
-	slack.OptionLog(logger)
-	slack.OptionDebug(true)
-	rtm := api.NewRTM()
-	go rtm.ManageConnection()
+	handlers, err := messagehandlers.NewMessageHandlers(cfg)
+	if err != nil {
+		logging.Fatalf("Cannot create handlers : %s", err.Error())
+	}
 
-	for msg := range rtm.IncomingEvents {
-		switch ev := msg.Data.(type) {
+	// picks up rotated secrets, after the handlers so it knows which ones they read themselves.
+	go cfg.WatchSecrets(context.Background())
 
-		case *slack.MessageEvent:
+	router := messagehandlers.NewRouter(handlers...)
+	policy := messagehandlers.NewPolicy(cfg.Policy)
+	router.SetPolicy(policy)
 
-			for _, handler := range handlers {
-				go func(text string, user string, grRtm *slack.RTM, h messagehandlers.MessageHandler) {
-					u, err := api.GetUserInfo(user)
-					if err != nil {
-						// error.....  die a mysterious death..
-						return
-					}
-					msg, _ := h.ParseMessage(text, u.Name)
-					messagehandlers.ProcessMessageResponse(msg, ev.Channel, api, grRtm)
-					//grRtm.SendMessage(grRtm.NewOutgoingMessage(msg, ev.Channel))
-				}(ev.Text, ev.User, rtm, handler)
+	audit, err := messagehandlers.NewJSONLAuditSink(cfg.Files.AuditLog)
+	if err != nil {
+		logging.Fatalf("Cannot open audit log : %s", err.Error())
+	}
+	defer audit.Close()
+	router.SetApprovals(messagehandlers.NewApprovalManager(policy, messagehandlers.DefaultApprovalTimeout, audit))
+	router.Register(messagehandlers.NewAuditHandler(audit))
+	if err := router.CheckPolicy(); err != nil {
+		logging.Fatalf("Bad policy : %s", err.Error())
+	}
+
+	api := slack.New(cfg.Slack.BotToken, slack.OptionLog(logger.With("component", "slack")), slack.OptionAppLevelToken(cfg.Slack.AppToken))
 
-			}
+	// need to know who we are so we don't answer ourselves.
+	auth, err := api.AuthTest()
+	if err != nil {
+		logging.Fatalf("Unable to auth with Slack : %s", err.Error())
+	}
 
-		default:
+	filter := messagehandlers.NewEventFilter(cfg.Filter, auth.UserID, auth.BotID)
+	activation := messagehandlers.NewActivationPolicy(cfg.Activation, auth.UserID)
+	pipeline := messagehandlers.NewPipeline(api, router, filter, activation)
+	pipeline.SetAudit(audit)
+
+	switch cfg.Slack.Mode {
+	case socketModeMode:
+		err := runSocketMode(api, pipeline, auth.UserID)
+		if err != nil {
+			logging.Fatalf("socket mode stopped : %s", err.Error())
 		}
+
+	// config has already checked it's one or the other.
+	default:
+		runRTM(api, pipeline)
 	}
 }
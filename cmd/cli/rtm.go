@@ -0,0 +1,24 @@
+package main
+
+import (
+	"context"
+	"github.com/kpfaulkner/wheatley/messagehandlers"
+	"github.com/slack-go/slack"
+)
+
+// runRTM uses the legacy RTM API. Slack no longer allows this for new apps, see socketmode.go
+func runRTM(api *slack.Client, pipeline *messagehandlers.Pipeline) {
+	rtm := api.NewRTM()
+	go rtm.ManageConnection()
+
+	responder := messagehandlers.NewRTMResponder(api, rtm)
+	for msg := range rtm.IncomingEvents {
+		switch ev := msg.Data.(type) {
+
+		case *slack.MessageEvent:
+			go pipeline.HandleMessage(context.Background(), messagehandlers.NewIncomingMessageFromRTM(ev), responder)
+
+		default:
+		}
+	}
+}
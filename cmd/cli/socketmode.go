@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"github.com/kpfaulkner/wheatley/logging"
+	"github.com/kpfaulkner/wheatley/messagehandlers"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"github.com/slack-go/slack/socketmode"
+	"strings"
+)
+
+// socketModeRunner receives events_api, interactive and slash command envelopes over a Socket Mode websocket, acks them
+// and feeds the messages into the pipeline. Replies go out via the Web API.
+type socketModeRunner struct {
+	client    *socketmode.Client
+	pipeline  *messagehandlers.Pipeline
+	responder messagehandlers.Responder
+	botUserID string
+}
+
+// botUserID is needed to spot mentions.
+func newSocketModeRunner(api *slack.Client, pipeline *messagehandlers.Pipeline, botUserID string, options ...socketmode.Option) *socketModeRunner {
+	r := socketModeRunner{}
+	r.botUserID = botUserID
+	r.client = socketmode.New(api, options...)
+	r.pipeline = pipeline
+	r.responder = messagehandlers.NewWebAPIResponder(api)
+	return &r
+}
+
+func runSocketMode(api *slack.Client, pipeline *messagehandlers.Pipeline, botUserID string) error {
+	r := newSocketModeRunner(api, pipeline, botUserID)
+	go r.processEvents()
+	return r.client.Run()
+}
+
+// processEvents loops until the client closes the events channel.
+func (r *socketModeRunner) processEvents() {
+	for evt := range r.client.Events {
+		switch evt.Type {
+		case socketmode.EventTypeConnecting:
+			logging.Infof("connecting to Slack with Socket Mode...")
+
+		case socketmode.EventTypeConnectionError:
+			logging.Warnf("socket mode connection failed, will retry")
+
+		case socketmode.EventTypeEventsAPI:
+			eventsAPIEvent, ok := evt.Data.(slackevents.EventsAPIEvent)
+			if !ok {
+				continue
+			}
+
+			// ack straight away, otherwise Slack will resend the event.
+			r.client.Ack(*evt.Request)
+			r.handleEventsAPIEvent(eventsAPIEvent)
+
+		case socketmode.EventTypeInteractive:
+			callback, ok := evt.Data.(slack.InteractionCallback)
+			if !ok {
+				continue
+			}
+
+			// an empty ack also closes a submitted modal.
+			r.client.Ack(*evt.Request)
+			r.handleInteraction(callback)
+
+		case socketmode.EventTypeSlashCommand:
+			cmd, ok := evt.Data.(slack.SlashCommand)
+			if !ok {
+				continue
+			}
+
+			// the reply goes via the response_url.
+			r.client.Ack(*evt.Request)
+			go r.pipeline.HandleSlashCommand(context.Background(), messagehandlers.NewSlashCommandFromSlack(cmd))
+		}
+	}
+}
+
+func (r *socketModeRunner) handleEventsAPIEvent(event slackevents.EventsAPIEvent) {
+	if event.Type != slackevents.CallbackEvent {
+		return
+	}
+
+	eventID := ""
+	if cbEvent, ok := event.Data.(*slackevents.EventsAPICallbackEvent); ok {
+		eventID = cbEvent.EventID
+	}
+
+	switch ev := event.InnerEvent.Data.(type) {
+	case *slackevents.MessageEvent:
+
+		// mentions also arrive as app_mention events, so only handle them once.
+		if strings.Contains(ev.Text, "<@"+r.botUserID+">") {
+			return
+		}
+		go r.handleMessage(messagehandlers.NewIncomingMessageFromEvent(ev), eventID)
+
+	case *slackevents.AppMentionEvent:
+		go r.handleMessage(messagehandlers.NewIncomingMessageFromMention(ev), eventID)
+	}
+}
+
+// handleMessage runs the message through the pipeline. Events arrive in the same format as the
+// Events API, so need to mark them as coming from Socket Mode.
+func (r *socketModeRunner) handleMessage(msg messagehandlers.IncomingMessage, eventID string) {
+	msg.Transport = messagehandlers.TransportSocketMode
+	msg.EventID = eventID
+	r.pipeline.HandleMessage(context.Background(), msg, r.responder)
+}
+
+// handleInteraction runs button clicks and modal submissions through the pipeline.
+func (r *socketModeRunner) handleInteraction(callback slack.InteractionCallback) {
+	interaction, ok := messagehandlers.NewInteractionFromCallback(&callback)
+	if !ok {
+		return
+	}
+	interaction.Transport = messagehandlers.TransportSocketMode
+	go r.pipeline.HandleInteraction(context.Background(), interaction, r.responder)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/kpfaulkner/wheatley/messagehandlers"
+	"github.com/slack-go/slack"
+)
+
+const testBotUserID = "U0BOT0000"
+
+// fakeSlack is the Web API and a Socket Mode websocket. Envelopes are sent as soon as the
+// client connects, acks end up in acked and chat.postMessage calls in posted.
+type fakeSlack struct {
+	server    *httptest.Server
+	envelopes []string
+	acked     chan string
+	posted    chan url.Values
+}
+
+func newFakeSlack(t *testing.T, envelopes ...string) *fakeSlack {
+	f := fakeSlack{envelopes: envelopes, acked: make(chan string, 10), posted: make(chan url.Values, 10)}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/apps.connections.open", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer xapp-test" {
+			t.Errorf("apps.connections.open should use the app token, got %q", r.Header.Get("Authorization"))
+		}
+		wsURL := "ws" + strings.TrimPrefix(f.server.URL, "http") + "/link"
+		fmt.Fprintf(w, `{"ok":true,"url":%q}`, wsURL)
+	})
+	mux.HandleFunc("/link", func(w http.ResponseWriter, r *http.Request) {
+		// Slack's client sends its own origin.
+		upgrader := websocket.Upgrader{CheckOrigin: func(r *http.Request) bool { return true }}
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("unable to upgrade : %s", err.Error())
+			return
+		}
+		defer conn.Close()
+
+		conn.WriteMessage(websocket.TextMessage, []byte(`{"type":"hello","num_connections":1}`))
+		for _, envelope := range f.envelopes {
+			conn.WriteMessage(websocket.TextMessage, []byte(envelope))
+		}
+
+		for {
+			ack := struct {
+				EnvelopeID string `json:"envelope_id"`
+			}{}
+			if err := conn.ReadJSON(&ack); err != nil {
+				return
+			}
+			f.acked <- ack.EnvelopeID
+		}
+	})
+	mux.HandleFunc("/users.info", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true,"user":{"id":"U012AB3CD","name":"someone","profile":{}}}`))
+	})
+	mux.HandleFunc("/usergroups.list", func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"ok":true,"usergroups":[]}`))
+	})
+	mux.HandleFunc("/chat.postMessage", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		f.posted <- r.Form
+		w.Write([]byte(`{"ok":true,"channel":"C012AB3CD","ts":"1600000001.000100"}`))
+	})
+
+	f.server = httptest.NewServer(mux)
+	return &f
+}
+
+// envelope wraps an Events API event the way Socket Mode sends it.
+func envelope(id string, event string) string {
+	payload := fmt.Sprintf(`{"token":"x","team_id":"T012AB3CD","api_app_id":"A012AB3CD","type":"event_callback","event_id":"Ev%s","event_time":1600000000,"event":%s}`, id, event)
+	b, _ := json.Marshal(map[string]interface{}{
+		"envelope_id":              id,
+		"type":                     "events_api",
+		"accepts_response_payload": false,
+		"payload":                  json.RawMessage(payload),
+	})
+	return string(b)
+}
+
+func messageEvent(channel string, text string) string {
+	return fmt.Sprintf(`{"type":"message","channel":%q,"user":"U012AB3CD","text":%q,"ts":"1600000000.000100","channel_type":"channel"}`, channel, text)
+}
+
+func mentionEvent(channel string, text string) string {
+	return fmt.Sprintf(`{"type":"app_mention","channel":%q,"user":"U012AB3CD","text":%q,"ts":"1600000000.000200"}`, channel, text)
+}
+
+func TestSocketMode(t *testing.T) {
+	mention := "<@" + testBotUserID + "> hello"
+	slackAPI := newFakeSlack(t,
+		envelope("1", messageEvent("C0PLAIN00", "hello")),
+
+		// the same mention arrives as both a message and an app_mention, it should only be answered once.
+		envelope("2", messageEvent("C0MENTION", mention)),
+		envelope("3", mentionEvent("C0MENTION", mention)),
+	)
+	defer slackAPI.server.Close()
+
+	api := slack.New("xoxb-test", slack.OptionAppLevelToken("xapp-test"), slack.OptionAPIURL(slackAPI.server.URL+"/"))
+	router := messagehandlers.NewRouter(messagehandlers.NewMiscMessageHandler())
+	pipeline := messagehandlers.NewPipeline(api, router, nil, nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	r := newSocketModeRunner(api, pipeline, testBotUserID)
+	go r.processEvents()
+	go r.client.RunContext(ctx)
+
+	acked := map[string]bool{}
+	replies := map[string]int{}
+	timeout := time.After(5 * time.Second)
+	for len(acked) < 3 || len(replies) < 2 {
+		select {
+		case id := <-slackAPI.acked:
+			acked[id] = true
+		case form := <-slackAPI.posted:
+			if form.Get("text") != "alive and well" {
+				t.Errorf("unexpected reply %v", form)
+			}
+			replies[form.Get("channel")]++
+		case <-timeout:
+			t.Fatalf("timed out, acked %v, replies %v", acked, replies)
+		}
+	}
+
+	// give a duplicate answer to the mention a chance to turn up.
+	select {
+	case form := <-slackAPI.posted:
+		replies[form.Get("channel")]++
+	case <-time.After(300 * time.Millisecond):
+	}
+
+	for _, id := range []string{"1", "2", "3"} {
+		if !acked[id] {
+			t.Errorf("envelope %s wasn't acked", id)
+		}
+	}
+	if replies["C0PLAIN00"] != 1 {
+		t.Errorf("expected one reply to the message, got %d", replies["C0PLAIN00"])
+	}
+	if replies["C0MENTION"] != 1 {
+		t.Errorf("expected one reply to the mention, got %d", replies["C0MENTION"])
+	}
+}
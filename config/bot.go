@@ -0,0 +1,179 @@
+package config
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	ActivationAll       = "all"       // any message in any channel Wheatley is in.
+	ActivationAddressed = "addressed" // only when @-mentioned, prefixed or (optionally) in a DM.
+)
+
+// FilterConfig decides which messages are dropped before they get anywhere near the handlers.
+type FilterConfig struct {
+	IgnoreSelf             bool `json:"IgnoreSelf"`             // our own messages.
+	IgnoreBots             bool `json:"IgnoreBots"`             // any other bot or integration.
+	IgnoreEdits            bool `json:"IgnoreEdits"`            // message_changed, otherwise edits re-run commands.
+	IgnoreThreadBroadcasts bool `json:"IgnoreThreadBroadcasts"` // thread replies also sent to the channel.
+
+	// IgnoredSubTypes are any other message subtypes to drop, eg channel_join
+	IgnoredSubTypes []string `json:"IgnoredSubTypes"`
+}
+
+// DefaultFilterConfig drops everything that isn't a plain message from a person.
+func DefaultFilterConfig() FilterConfig {
+	return FilterConfig{
+		IgnoreSelf:             true,
+		IgnoreBots:             true,
+		IgnoreEdits:            true,
+		IgnoreThreadBroadcasts: true,
+		IgnoredSubTypes: []string{
+			slack.MsgSubTypeMessageDeleted,
+			slack.MsgSubTypeMessageReplied,
+			slack.MsgSubTypeChannelJoin,
+			slack.MsgSubTypeChannelLeave,
+			slack.MsgSubTypeChannelTopic,
+			slack.MsgSubTypeChannelPurpose,
+			slack.MsgSubTypeChannelName,
+			slack.MsgSubTypeGroupJoin,
+			slack.MsgSubTypeGroupLeave,
+			slack.MsgSubTypePinnedItem,
+			slack.MsgSubTypeUnpinnedItem,
+		},
+	}
+}
+
+// ActivationConfig decides when Wheatley should treat a message as being for it.
+type ActivationConfig struct {
+	Mode     string   `json:"Mode"`
+	AllowDMs bool     `json:"AllowDMs"`
+	Prefixes []string `json:"Prefixes"` // eg "wheatley", so "wheatley check prod" works without a mention.
+
+	// ChannelOverrides maps channel ID to mode, eg a dedicated ops channel could be "all"
+	ChannelOverrides map[string]string `json:"ChannelOverrides"`
+}
+
+func DefaultActivationConfig() ActivationConfig {
+	return ActivationConfig{
+		Mode:             ActivationAddressed,
+		AllowDMs:         true,
+		Prefixes:         []string{"wheatley"},
+		ChannelOverrides: map[string]string{},
+	}
+}
+
+// PolicyConfig says who can run what.
+type PolicyConfig struct {
+
+	// Roles maps a role name to its members. Members are Slack user IDs, user group IDs
+	// or email addresses. Never user names, anyone can change those.
+	Roles map[string][]string `json:"Roles"`
+
+	// Commands maps a command to the roles that can run it, any one of them will do.
+	// Keys are either a handler name, eg "SendgridMessageHandler", for all its commands, or
+	// handler name and command usage, eg "ServerStatusMessageHandler:env <env> is <state>".
+	// An empty list means anyone.
+	Commands map[string][]string `json:"Commands"`
+
+	// MutatingRoles are the roles needed for mutating (or sensitive) commands that aren't listed in Commands.
+	MutatingRoles []string `json:"MutatingRoles"`
+}
+
+// DefaultPolicyConfig only lets admins run mutating commands, and there aren't any admins.
+func DefaultPolicyConfig() PolicyConfig {
+	return PolicyConfig{
+		Roles:         map[string][]string{"admin": {}},
+		Commands:      map[string][]string{},
+		MutatingRoles: []string{"admin"},
+	}
+}
+
+func validMode(mode string) bool {
+	switch strings.ToLower(mode) {
+	case ActivationAll, ActivationAddressed:
+		return true
+	}
+	return false
+}
+
+// public, private and DM channel IDs.
+var channelIDPattern = regexp.MustCompile(`^[CGD][A-Z0-9]+$`)
+
+func (f FilterConfig) validate(p *problems) {
+	for i, subType := range f.IgnoredSubTypes {
+		if strings.TrimSpace(subType) == "" {
+			p.add("Filter.IgnoredSubTypes", "entry %d is empty", i)
+		}
+	}
+}
+
+func (a ActivationConfig) validate(p *problems) {
+	if !validMode(a.Mode) {
+		p.add("Activation.Mode", "%q should be %s or %s", a.Mode, ActivationAddressed, ActivationAll)
+	}
+	for i, prefix := range a.Prefixes {
+		if strings.TrimSpace(prefix) == "" {
+			p.add("Activation.Prefixes", "entry %d is empty", i)
+		}
+	}
+	for _, channel := range sortedKeys(a.ChannelOverrides) {
+		if !channelIDPattern.MatchString(channel) {
+			p.add("Activation.ChannelOverrides", "%q should be a channel ID, eg C012AB3CD", channel)
+		}
+		if mode := a.ChannelOverrides[channel]; !validMode(mode) {
+			p.add("Activation.ChannelOverrides["+channel+"]", "%q should be %s or %s", mode, ActivationAddressed, ActivationAll)
+		}
+	}
+}
+
+// validate checks every role used is defined, otherwise a typo means nobody can run the command.
+func (pc PolicyConfig) validate(p *problems) {
+	for _, role := range sortedKeys(pc.Roles) {
+		if strings.TrimSpace(role) == "" {
+			p.add("Policy.Roles", "role names can't be empty")
+		}
+		for i, member := range pc.Roles[role] {
+			if strings.TrimSpace(member) == "" {
+				p.add("Policy.Roles["+role+"]", "member %d is empty", i)
+			}
+		}
+	}
+
+	for _, command := range sortedKeys(pc.Commands) {
+		if strings.TrimSpace(command) == "" {
+			p.add("Policy.Commands", "command names can't be empty")
+		}
+		for _, role := range pc.Commands[command] {
+			if _, ok := pc.Roles[role]; !ok {
+				p.add("Policy.Commands["+command+"]", "unknown role %q", role)
+			}
+		}
+	}
+
+	for _, role := range pc.MutatingRoles {
+		if _, ok := pc.Roles[role]; !ok {
+			p.add("Policy.MutatingRoles", "unknown role %q", role)
+		}
+	}
+}
+
+// sortedKeys so problems come out in the same order every time.
+func sortedKeys(m interface{}) []string {
+	keys := []string{}
+	switch t := m.(type) {
+	case map[string]string:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	case map[string][]string:
+		for k := range t {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -1,40 +1,150 @@
 package config
 
 import (
-	"encoding/json"
-	"fmt"
-	"os"
+	"github.com/kpfaulkner/wheatley/helper"
+	"github.com/kpfaulkner/wheatley/secrets"
+	"time"
 )
 
+// DefaultFileName is where the config is loaded from if WHEATLEY_CONFIG isn't set.
+const DefaultFileName = "wheatley.json"
+
+// Config is everything Wheatley needs, from one JSON or YAML file with env vars over the top
+// (see ApplyEnv). Handler sections are pointers, a handler is only enabled if its section is there.
+// Any string can be a secret://name reference instead, see SecretsConfig.
 type Config struct {
+	Slack   SlackConfig   `json:"Slack"`
+	Files   FilesConfig   `json:"Files"`
+	Secrets SecretsConfig `json:"Secrets"`
+	Cloud   helper.Cloud  `json:"Cloud"` // which Azure cloud, and any endpoints to point elsewhere.
+
+	Filter     FilterConfig     `json:"Filter"`     // which messages are dropped before the handlers see them.
+	Activation ActivationConfig `json:"Activation"` // when a message is for Wheatley.
+	Policy     PolicyConfig     `json:"Policy"`     // who can run what.
+
+	Database        *DatabaseConfig               `json:"Database"`        // DatabaseBackupMessageHandler
+	AzureCosts      *AzureCostsConfig             `json:"AzureCosts"`      // AzureCostsMessageHandler
+	AzureShutdown   *AzureShutdownConfig          `json:"AzureShutdown"`   // AzureShutdownMessageHandler
+	AzureMonitoring *helper.AzureMonitoringConfig `json:"AzureMonitoring"` // AzureStatusMessageHandler
+	AzureStorage    *AzureStorageConfig           `json:"AzureStorage"`    // AzureStorageMessageHandler
+	SendGrid        *SendGridConfig               `json:"SendGrid"`        // SendgridMessageHandler
+	ServiceBus      *ServiceBusConfig             `json:"ServiceBus"`      // ServiceBusMessageHandler
+
+	// set by Load.
+	credentials *helper.CredentialManager
+	secretStore *secrets.Store
+	secretRefs  secrets.References
+	rotating    map[string]bool // secret fields read each time they're used, see secretFor.
+}
+
+type SlackConfig struct {
+	BotToken      string   `json:"BotToken"`      // xoxb-...
+	AppToken      string   `json:"AppToken"`      // xapp-..., only for Socket Mode.
+	SigningSecret string   `json:"SigningSecret"` // only for the Azure Function.
+	Mode          string   `json:"Mode"`          // rtm (default) or socketmode, cli only.
+	ReplayWindow  Duration `json:"ReplayWindow"`  // eg "5m", how old a signed request can be.
+}
 
-	// database to work against.
-	Database struct {
-		Host     string `json:"host"`
-		Password string `json:"password"`
-	} `json:"database"`
+// FilesConfig is where everything that isn't in this file lives.
+type FilesConfig struct {
+	State    string `json:"State"`    // env states for ServerStatusMessageHandler.
+	AuditLog string `json:"AuditLog"` // commands run and approvals.
+}
+
+// SecretsConfig is where secret://name references are looked up, in order: env vars, then files
+// in Dir, then Key Vault. Only env vars are looked at unless the others are set.
+type SecretsConfig struct {
+	EnvPrefix string          `json:"EnvPrefix"` // db-password is read from <EnvPrefix>DB_PASSWORD.
+	Dir       string          `json:"Dir"`       // eg /run/secrets, each secret in a file named after it.
+	KeyVault  *KeyVaultConfig `json:"KeyVault"`
+	TTL       Duration        `json:"TTL"` // how often secrets are fetched again, to pick up rotated ones.
+}
 
-	// Azure storage connection string
-	AzureStorage string `json:"azurestorage"`
+// KeyVaultConfig is the vault and the service principal used to read it. These can't be secret
+// references themselves, use env vars (eg WHEATLEY_SECRETS_KEYVAULT_CLIENTSECRET) instead.
+type KeyVaultConfig struct {
+	URL string `json:"URL"` // eg https://myvault.vault.azure.net
+	helper.AzureCredentialConfig
+}
 
-	AzureQueries map[string]string `json:"azurequeries"`
+type DatabaseConfig struct {
+	ExportSubscriptionID string `json:"ExportSubscriptionID"`
+	ImportSubscriptionID string `json:"ImportSubscriptionID"`
+	helper.AzureCredentialConfig
+	ExportResourceGroup    string `json:"ExportResourceGroup"`
+	ImportResourceGroup    string `json:"ImportResourceGroup"`
+	StorageKey             string `json:"StorageKey"`
+	StorageURL             string `json:"StorageURL"`
+	SqlExportAdminLogin    string `json:"SqlExportAdminLogin"`
+	SqlExportAdminPassword string `json:"SqlExportAdminPassword"`
+	SqlImportAdminLogin    string `json:"SqlImportAdminLogin"`
+	SqlImportAdminPassword string `json:"SqlImportAdminPassword"`
+	BackupPrefix           string `json:"BackupPrefix"`
+	ExportServerName       string `json:"ExportServerName"`
+	ImportServerName       string `json:"ImportServerName"`
+	DatabaseName           string `json:"DatabaseName"`
+	ImportStorageKey       string `json:"ImportStorageKey"`
+}
+
+type AzureCostsConfig struct {
+	helper.AzureCredentialConfig
+	Subscriptions []string `json:"Subscriptions"`
+}
 
-	/*
-	   // limit ourselves to 3 queries until I come up with a generic (map?) version of things
-	   AzureQuery1 string `json:"azurestoragequery1"`
-	   AzureQuery2 string `json:"azurestoragequery2"`
-	   AzureQuery3 string `json:"azurestoragequery3"`
-	*/
+type AzureShutdownConfig struct {
+	SubscriptionID string `json:"SubscriptionID"`
+	helper.AzureCredentialConfig
 }
 
-func LoadConfiguration(file string) Config {
-	var config Config
-	configFile, err := os.Open(file)
-	defer configFile.Close()
+type AzureStorageConfig struct {
+	ConnectionString string `json:"ConnectionString"`
+
+	// regex to match against messages -> storage query to run.
+	Queries map[string]string `json:"Queries"`
+}
+
+type SendGridConfig struct {
+	APIKey string `json:"APIKey"`
+	Host   string `json:"Host"` // default https://api.sendgrid.com
+}
+
+type ServiceBusConfig struct {
+	ConnectionString string `json:"ConnectionString"`
+}
+
+// Default is used for anything the file doesn't set.
+func Default() Config {
+	return Config{
+		Slack: SlackConfig{
+			Mode:         "rtm",
+			ReplayWindow: Duration(5 * time.Minute),
+		},
+		Secrets: SecretsConfig{
+			EnvPrefix: "WHEATLEY_SECRET_",
+			TTL:       Duration(secrets.DefaultTTL),
+		},
+		Filter:     DefaultFilterConfig(),
+		Activation: DefaultActivationConfig(),
+		Policy:     DefaultPolicyConfig(),
+		Files: FilesConfig{
+			State:    "state.json",
+			AuditLog: "audit.log",
+		},
+	}
+}
+
+// Duration is a time.Duration written as a string, eg "5m" or "1h30m".
+type Duration time.Duration
+
+func (d *Duration) UnmarshalText(text []byte) error {
+	parsed, err := time.ParseDuration(string(text))
 	if err != nil {
-		fmt.Println(err.Error())
+		return err
 	}
-	jsonParser := json.NewDecoder(configFile)
-	jsonParser.Decode(&config)
-	return config
+	*d = Duration(parsed)
+	return nil
+}
+
+func (d Duration) MarshalText() ([]byte, error) {
+	return []byte(time.Duration(d).String()), nil
 }
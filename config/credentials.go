@@ -0,0 +1,32 @@
+package config
+
+import (
+	"fmt"
+
+	"github.com/kpfaulkner/wheatley/helper"
+)
+
+// Credentials is shared by everything that talks to Azure, so tokens are too.
+func (c *Config) Credentials() *helper.CredentialManager {
+	return c.credentials
+}
+
+// TokenSource is the (cached) tokens for a section's credentials, eg "Database" or
+// "AzureMonitoring.AzureMonitor[0]". A ClientSecret that was a secret reference is read each
+// time a token is needed, so rotating it doesn't need a restart.
+func (c *Config) TokenSource(section string, ac helper.AzureCredentialConfig) (*helper.TokenSource, error) {
+	cred, err := ac.NewCredential(c.Cloud, c.secretFor(section+".ClientSecret"))
+	if err != nil {
+		return nil, fmt.Errorf("%s : %s", section, err.Error())
+	}
+	return c.credentials.Source(cred), nil
+}
+
+// ARMClient is an ARM client for the cloud, using the section's credentials.
+func (c *Config) ARMClient(section string, ac helper.AzureCredentialConfig) (*helper.ARMClient, error) {
+	tokens, err := c.TokenSource(section, ac)
+	if err != nil {
+		return nil, err
+	}
+	return helper.NewARMClient(tokens, c.Cloud), nil
+}
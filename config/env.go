@@ -0,0 +1,159 @@
+package config
+
+import (
+	"encoding"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts every env var override, eg WHEATLEY_DATABASE_CLIENTSECRET sets Database.ClientSecret.
+const EnvPrefix = "WHEATLEY"
+
+// env vars from before there was a config file. The WHEATLEY_ version wins if both are set.
+var legacyEnv = map[string]string{
+	"WHEATLEY_SLACK_BOTTOKEN":              "SLACK_KEY",
+	"WHEATLEY_SLACK_APPTOKEN":              "SLACK_APP_TOKEN",
+	"WHEATLEY_SLACK_SIGNINGSECRET":         "SLACK_SIGNING_SECRET",
+	"WHEATLEY_SLACK_MODE":                  "SLACK_MODE",
+	"WHEATLEY_SLACK_REPLAYWINDOW":          "SLACK_REPLAY_WINDOW",
+	"WHEATLEY_SENDGRID_APIKEY":             "SENDGRID_KEY",
+	"WHEATLEY_SERVICEBUS_CONNECTIONSTRING": "SERVICEBUS_CONNECTIONSTRING",
+}
+
+// LookupFunc is os.LookupEnv, or something pretending to be.
+type LookupFunc func(name string) (string, bool)
+
+// ApplyEnv overrides any field with a WHEATLEY_<SECTION>_<FIELD> env var set (names as in the file,
+// upper cased), so secrets don't have to be in the file. Setting anything in a section that isn't
+// in the file adds it, and so enables its handler. Lists are comma separated, maps can't be set.
+func ApplyEnv(config *Config, lookup LookupFunc) error {
+	withLegacy := func(name string) (string, bool) {
+		if value, ok := lookup(name); ok {
+			return value, ok
+		}
+		if old, ok := legacyEnv[name]; ok {
+			return lookup(old)
+		}
+		return "", false
+	}
+
+	_, err := applyEnv(reflect.ValueOf(config).Elem(), EnvPrefix, "", withLegacy)
+	return err
+}
+
+// applyEnv walks the struct, returning true if anything in it was set.
+func applyEnv(v reflect.Value, envName string, path string, lookup LookupFunc) (bool, error) {
+	set := false
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if f.PkgPath != "" {
+			continue
+		}
+		name := jsonName(f)
+		fieldEnvName := envName + "_" + strings.ToUpper(name)
+		fieldPath := name
+		if path != "" {
+			fieldPath = path + "." + name
+		}
+		fv := v.Field(i)
+
+		// embedded structs are flattened, like they are in JSON.
+		if f.Anonymous && fv.Kind() == reflect.Struct {
+			structSet, err := applyEnv(fv, envName, path, lookup)
+			if err != nil {
+				return false, err
+			}
+			set = set || structSet
+			continue
+		}
+
+		if _, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); !ok {
+			switch {
+			case fv.Kind() == reflect.Ptr && fv.Type().Elem().Kind() == reflect.Struct:
+				section := fv
+				if section.IsNil() {
+					section = reflect.New(fv.Type().Elem())
+				}
+				sectionSet, err := applyEnv(section.Elem(), fieldEnvName, fieldPath, lookup)
+				if err != nil {
+					return false, err
+				}
+				if sectionSet {
+					fv.Set(section)
+					set = true
+				}
+				continue
+
+			case fv.Kind() == reflect.Struct:
+				structSet, err := applyEnv(fv, fieldEnvName, fieldPath, lookup)
+				if err != nil {
+					return false, err
+				}
+				set = set || structSet
+				continue
+			}
+		}
+
+		value, ok := lookup(fieldEnvName)
+		if !ok {
+			continue
+		}
+		if err := setValue(fv, value); err != nil {
+			return false, fmt.Errorf("%s (%s) : %s", fieldEnvName, fieldPath, err.Error())
+		}
+		set = true
+	}
+	return set, nil
+}
+
+func setValue(fv reflect.Value, value string) error {
+	if u, ok := fv.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(value))
+	}
+
+	switch fv.Kind() {
+	case reflect.String:
+		fv.SetString(value)
+
+	case reflect.Bool:
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q should be true or false", value)
+		}
+		fv.SetBool(b)
+
+	case reflect.Int, reflect.Int64:
+		n, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("%q should be a whole number", value)
+		}
+		fv.SetInt(n)
+
+	case reflect.Slice:
+		if fv.Type().Elem().Kind() != reflect.String {
+			return fmt.Errorf("can't be set from an env var")
+		}
+		items := []string{}
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		fv.Set(reflect.ValueOf(items))
+
+	default:
+		return fmt.Errorf("can't be set from an env var")
+	}
+	return nil
+}
+
+// jsonName is the name the field has in the file.
+func jsonName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+		return tag
+	}
+	return f.Name
+}
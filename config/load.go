@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/kpfaulkner/wheatley/helper"
+	"gopkg.in/yaml.v2"
+)
+
+// Load reads the file (YAML if it ends in .yaml or .yml, otherwise JSON) over the defaults,
+// applies env var overrides, resolves secret references and validates the result. Every
+// problem found is in the error.
+func Load(fileName string) (*Config, error) {
+	b, err := ioutil.ReadFile(fileName)
+	if err != nil {
+		return nil, err
+	}
+
+	config := Default()
+	if err := decode(fileName, b, &config); err != nil {
+		return nil, err
+	}
+
+	if err := ApplyEnv(&config, os.LookupEnv); err != nil {
+		return nil, err
+	}
+
+	// Key Vault needs to know where it is before anything else can be resolved.
+	if config.Cloud, err = config.Cloud.Resolve(); err != nil {
+		return nil, fmt.Errorf("Cloud : %s", err.Error())
+	}
+
+	config.credentials = helper.NewCredentialManager()
+	config.rotating = make(map[string]bool)
+	store, err := NewSecretStore(config.Secrets, config.Cloud, config.credentials)
+	if err != nil {
+		return nil, err
+	}
+	if err := config.resolveSecrets(store); err != nil {
+		return nil, err
+	}
+
+	config.Slack.Mode = strings.ToLower(config.Slack.Mode)
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// FileName is WHEATLEY_CONFIG, or DefaultFileName.
+func FileName() string {
+	if fileName := os.Getenv("WHEATLEY_CONFIG"); fileName != "" {
+		return fileName
+	}
+	return DefaultFileName
+}
+
+func decode(fileName string, b []byte, config *Config) error {
+	isYAML := false
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".yaml", ".yml":
+		isYAML = true
+
+		// YAML goes through JSON so there's only one set of field names (and rules) to keep right.
+		var y interface{}
+		if err := yaml.Unmarshal(b, &y); err != nil {
+			return fmt.Errorf("%s : %s", fileName, err.Error())
+		}
+		j, err := yamlToJSON(y)
+		if err != nil {
+			return fmt.Errorf("%s : %s", fileName, err.Error())
+		}
+		if b, err = json.Marshal(j); err != nil {
+			return fmt.Errorf("%s : %s", fileName, err.Error())
+		}
+	}
+
+	// typos in field names would otherwise silently leave things unset.
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(config)
+	if err == nil {
+		return nil
+	}
+
+	switch e := err.(type) {
+	case *json.SyntaxError:
+		if !isYAML {
+			line, col := position(b, e.Offset)
+			return fmt.Errorf("%s:%d:%d : %s", fileName, line, col, e.Error())
+		}
+	case *json.UnmarshalTypeError:
+		return fmt.Errorf("%s : %s should be %s, not %s", fileName, e.Field, e.Type.String(), e.Value)
+	}
+	return fmt.Errorf("%s : %s", fileName, err.Error())
+}
+
+// position converts an offset into a line and column, both starting at 1.
+func position(b []byte, offset int64) (int, int) {
+	if offset > int64(len(b)) {
+		offset = int64(len(b))
+	}
+	before := b[:offset]
+	line := bytes.Count(before, []byte("\n")) + 1
+	col := len(before) - bytes.LastIndexByte(before, '\n')
+	return line, col
+}
+
+// yamlToJSON converts the map[interface{}]interface{} yaml.v2 gives maps as into something
+// encoding/json can marshal.
+func yamlToJSON(v interface{}) (interface{}, error) {
+	switch t := v.(type) {
+	case map[interface{}]interface{}:
+		m := map[string]interface{}{}
+		for k, val := range t {
+			key, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("key %v should be a string", k)
+			}
+			converted, err := yamlToJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			m[key] = converted
+		}
+		return m, nil
+
+	case []interface{}:
+		l := make([]interface{}, len(t))
+		for i, val := range t {
+			converted, err := yamlToJSON(val)
+			if err != nil {
+				return nil, err
+			}
+			l[i] = converted
+		}
+		return l, nil
+	}
+	return v, nil
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/helper"
+	"github.com/kpfaulkner/wheatley/logging"
+	"github.com/kpfaulkner/wheatley/secrets"
+)
+
+// how long resolving every secret at startup gets.
+const resolveTimeout = 1 * time.Minute
+
+// NewSecretStore builds the store secret:// references are looked up in, Key Vault tokens come
+// from credentials.
+func NewSecretStore(sc SecretsConfig, cloud helper.Cloud, credentials *helper.CredentialManager) (*secrets.Store, error) {
+	chain := secrets.Chain{secrets.NewEnvProvider(sc.EnvPrefix)}
+	if sc.Dir != "" {
+		chain = append(chain, secrets.NewFileProvider(sc.Dir))
+	}
+
+	if kv := sc.KeyVault; kv != nil {
+		for _, v := range []string{kv.URL, kv.TenantID, kv.ClientID, kv.ClientSecret, kv.ClientCertificate} {
+			if strings.HasPrefix(v, secrets.ReferencePrefix) {
+				return nil, fmt.Errorf("Secrets.KeyVault can't use secret references, it's what they're looked up in")
+			}
+		}
+		if kv.URL == "" {
+			return nil, fmt.Errorf("Secrets.KeyVault.URL : required")
+		}
+
+		cred, err := kv.NewCredential(cloud, nil)
+		if err != nil {
+			return nil, fmt.Errorf("Secrets.KeyVault : %s", err.Error())
+		}
+		tokens := credentials.Source(cred)
+		token := func(ctx context.Context) (string, error) {
+			return tokens.Token(ctx, cloud.KeyVaultResource)
+		}
+		chain = append(chain, secrets.NewKeyVaultProvider(kv.URL, token))
+	}
+
+	if sc.TTL <= 0 {
+		return nil, fmt.Errorf("Secrets.TTL : should be more than 0, eg 1h")
+	}
+	return secrets.NewStore(chain, time.Duration(sc.TTL)), nil
+}
+
+// resolveSecrets replaces every secret:// reference in the config with its value.
+func (c *Config) resolveSecrets(store *secrets.Store) error {
+	ctx, cancel := context.WithTimeout(context.Background(), resolveTimeout)
+	defer cancel()
+
+	refs, err := secrets.Resolve(ctx, c, store)
+	if err != nil {
+		return err
+	}
+	c.secretStore = store
+	c.secretRefs = refs
+	return nil
+}
+
+// SecretStore is where the config's secrets came from, nil if it didn't reference any.
+func (c *Config) SecretStore() *secrets.Store {
+	return c.secretStore
+}
+
+// secretFor returns a SecretFunc reading the secret each time if the field was a secret
+// reference, or nil if it wasn't.
+func (c *Config) secretFor(path string) helper.SecretFunc {
+	for name, paths := range c.secretRefs {
+		for _, p := range paths {
+			if p == path {
+				c.rotating[path] = true
+				return func(ctx context.Context) (string, error) {
+					return c.secretStore.Get(ctx, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// WatchSecrets refetches referenced secrets every Secrets.TTL until ctx is done, so rotated
+// ones are noticed. Client secrets are read when a token is needed so pick up the new value,
+// anything else was resolved at startup and is logged as needing a restart. Should be called
+// after the handlers have been created.
+func (c *Config) WatchSecrets(ctx context.Context) {
+	if c.secretStore == nil || len(c.secretRefs) == 0 {
+		return
+	}
+
+	c.secretStore.OnChange(func(name string) {
+		stale := []string{}
+		for _, path := range c.secretRefs[name] {
+			if !c.rotating[path] {
+				stale = append(stale, path)
+			}
+		}
+		if len(stale) > 0 {
+			logging.FromContext(ctx).Warnf("secret %s was rotated, restart to use it for %s", name, strings.Join(stale, ", "))
+		}
+	})
+	c.secretStore.Run(ctx)
+}
@@ -0,0 +1,231 @@
+package config
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/helper"
+)
+
+// ValidationError lists everything wrong with the config, so it can all be fixed in one go.
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("invalid config, %d problem(s):\n  %s", len(e.Problems), strings.Join(e.Problems, "\n  "))
+}
+
+type problems []string
+
+func (p *problems) add(path string, format string, args ...interface{}) {
+	*p = append(*p, path+" : "+fmt.Sprintf(format, args...))
+}
+
+// required adds a problem for each empty field, fields are pairs of name and value.
+func (p *problems) required(section string, fields ...string) {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if strings.TrimSpace(fields[i+1]) == "" {
+			p.add(section+"."+fields[i], "required")
+		}
+	}
+}
+
+var guidPattern = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// guids checks the IDs Azure hands out (tenant, client and subscription) look like IDs. Empty
+// ones are left to required.
+func (p *problems) guids(section string, fields ...string) {
+	for i := 0; i+1 < len(fields); i += 2 {
+		if fields[i+1] != "" && !guidPattern.MatchString(fields[i+1]) {
+			p.add(section+"."+fields[i], "%q isn't a GUID", fields[i+1])
+		}
+	}
+}
+
+// credential checks a section has one way of getting Azure tokens: a managed identity, or a
+// service principal with a secret or certificate.
+func (p *problems) credential(section string, ac helper.AzureCredentialConfig) {
+	if ac.ManagedIdentity {
+		p.guids(section, "ClientID", ac.ClientID)
+		if ac.ClientSecret != "" || ac.ClientCertificate != "" {
+			p.add(section, "ManagedIdentity doesn't need ClientSecret or ClientCertificate")
+		}
+		return
+	}
+
+	p.required(section, "TenantID", ac.TenantID, "ClientID", ac.ClientID)
+	p.guids(section, "TenantID", ac.TenantID, "ClientID", ac.ClientID)
+	switch {
+	case ac.ClientSecret == "" && ac.ClientCertificate == "":
+		p.add(section, "needs ClientSecret, ClientCertificate or ManagedIdentity")
+	case ac.ClientSecret != "" && ac.ClientCertificate != "":
+		p.add(section, "set ClientSecret or ClientCertificate, not both")
+	}
+}
+
+// Validate checks the Slack settings, filter, activation and policy, and every section that's there. Sections that aren't there
+// aren't checked, their handlers just aren't enabled.
+func (c *Config) Validate() error {
+	p := problems{}
+
+	c.Slack.validate(&p)
+	if c.Secrets.KeyVault != nil {
+		p.required("Secrets.KeyVault", "URL", c.Secrets.KeyVault.URL)
+		p.credential("Secrets.KeyVault", c.Secrets.KeyVault.AzureCredentialConfig)
+	}
+	c.Filter.validate(&p)
+	c.Activation.validate(&p)
+	c.Policy.validate(&p)
+	p.required("Files", "State", c.Files.State, "AuditLog", c.Files.AuditLog)
+
+	if c.Database != nil {
+		c.Database.validate(&p)
+	}
+	if c.AzureCosts != nil {
+		c.AzureCosts.validate(&p)
+	}
+	if c.AzureShutdown != nil {
+		s := c.AzureShutdown
+		p.required("AzureShutdown", "SubscriptionID", s.SubscriptionID)
+		p.guids("AzureShutdown", "SubscriptionID", s.SubscriptionID)
+		p.credential("AzureShutdown", s.AzureCredentialConfig)
+	}
+	if c.AzureMonitoring != nil {
+		c.validateAzureMonitoring(&p)
+	}
+	if c.AzureStorage != nil {
+		p.required("AzureStorage", "ConnectionString", c.AzureStorage.ConnectionString)
+		for pattern := range c.AzureStorage.Queries {
+			if _, err := regexp.Compile(pattern); err != nil {
+				p.add("AzureStorage.Queries", "%q isn't a valid regex : %s", pattern, err.Error())
+			}
+		}
+	}
+	if c.SendGrid != nil {
+		p.required("SendGrid", "APIKey", c.SendGrid.APIKey)
+		if h := c.SendGrid.Host; h != "" {
+			if u, err := url.Parse(h); err != nil || u.Host == "" {
+				p.add("SendGrid.Host", "%q should be a URL, eg https://api.sendgrid.com", h)
+			}
+		}
+	}
+	if c.ServiceBus != nil {
+		cs := c.ServiceBus.ConnectionString
+		p.required("ServiceBus", "ConnectionString", cs)
+		if cs != "" && !strings.Contains(cs, "Endpoint=") {
+			p.add("ServiceBus.ConnectionString", "should look like Endpoint=sb://...;SharedAccessKeyName=...;SharedAccessKey=...")
+		}
+	}
+
+	if len(p) > 0 {
+		return &ValidationError{Problems: p}
+	}
+	return nil
+}
+
+func (s SlackConfig) validate(p *problems) {
+	p.required("Slack", "BotToken", s.BotToken)
+	if s.BotToken != "" && !strings.HasPrefix(s.BotToken, "xoxb-") {
+		p.add("Slack.BotToken", "should be a bot token, starting xoxb-")
+	}
+
+	switch s.Mode {
+	case "rtm", "":
+	case "socketmode":
+		p.required("Slack", "AppToken", s.AppToken)
+		if s.AppToken != "" && !strings.HasPrefix(s.AppToken, "xapp-") {
+			p.add("Slack.AppToken", "should be an app level token, starting xapp-")
+		}
+	default:
+		p.add("Slack.Mode", "%q should be rtm or socketmode", s.Mode)
+	}
+
+	if time.Duration(s.ReplayWindow) <= 0 {
+		p.add("Slack.ReplayWindow", "should be more than 0, eg 5m")
+	}
+}
+
+func (d *DatabaseConfig) validate(p *problems) {
+	p.required("Database",
+		"ExportSubscriptionID", d.ExportSubscriptionID,
+		"ImportSubscriptionID", d.ImportSubscriptionID,
+		"ExportResourceGroup", d.ExportResourceGroup,
+		"ImportResourceGroup", d.ImportResourceGroup,
+		"StorageKey", d.StorageKey,
+		"StorageURL", d.StorageURL,
+		"SqlExportAdminLogin", d.SqlExportAdminLogin,
+		"SqlExportAdminPassword", d.SqlExportAdminPassword,
+		"SqlImportAdminLogin", d.SqlImportAdminLogin,
+		"SqlImportAdminPassword", d.SqlImportAdminPassword,
+		"ExportServerName", d.ExportServerName,
+		"ImportServerName", d.ImportServerName,
+		"DatabaseName", d.DatabaseName,
+		"ImportStorageKey", d.ImportStorageKey)
+	p.guids("Database",
+		"ExportSubscriptionID", d.ExportSubscriptionID,
+		"ImportSubscriptionID", d.ImportSubscriptionID)
+	p.credential("Database", d.AzureCredentialConfig)
+
+	if d.StorageURL != "" {
+		u, err := url.Parse(d.StorageURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			p.add("Database.StorageURL", "%q should be an https URL, eg https://<account>.blob.core.windows.net/<container>", d.StorageURL)
+		}
+	}
+}
+
+func (a *AzureCostsConfig) validate(p *problems) {
+	p.credential("AzureCosts", a.AzureCredentialConfig)
+	if len(a.Subscriptions) == 0 {
+		p.add("AzureCosts.Subscriptions", "needs at least one subscription ID")
+	}
+	for i, sub := range a.Subscriptions {
+		if !guidPattern.MatchString(sub) {
+			p.add(fmt.Sprintf("AzureCosts.Subscriptions[%d]", i), "%q isn't a GUID", sub)
+		}
+	}
+}
+
+func (c *Config) validateAzureMonitoring(p *problems) {
+	names := map[string]bool{}
+	for i, am := range c.AzureMonitoring.AzureMonitor {
+		section := fmt.Sprintf("AzureMonitoring.AzureMonitor[%d]", i)
+		p.required(section, "Name", am.Name, "SubscriptionID", am.SubscriptionID)
+		p.guids(section, "SubscriptionID", am.SubscriptionID)
+		p.credential(section, am.AzureCredentialConfig)
+		if names[am.Name] {
+			p.add(section+".Name", "%q is used more than once", am.Name)
+		}
+		names[am.Name] = true
+
+		for j, r := range am.ResourceToMonitor {
+			resource := fmt.Sprintf("%s.ResourceToMonitor[%d]", section, j)
+			p.required(resource, "Name", r.Name, "ResourceGroup", r.ResourceGroup, "ResourceName", r.ResourceName, "MetricDefinition", r.MetricDefinition)
+			if len(r.Metrics) == 0 {
+				p.add(resource+".Metrics", "needs at least one metric")
+			}
+		}
+	}
+
+	envs := map[string]bool{}
+	for i, ai := range c.AzureMonitoring.AppInsights.Configs {
+		section := fmt.Sprintf("AzureMonitoring.AppInsights.Configs[%d]", i)
+		p.required(section, "env", ai.Env)
+		if envs[ai.Env] {
+			p.add(section+".env", "%q is used more than once", ai.Env)
+		}
+		envs[ai.Env] = true
+
+		for j, r := range ai.Resources {
+			p.required(fmt.Sprintf("%s.resources[%d]", section, j), "Name", r.Name, "AppID", r.AppID, "APIKey", r.APIKey)
+		}
+	}
+
+	if len(c.AzureMonitoring.AzureMonitor) == 0 && len(c.AzureMonitoring.AppInsights.Configs) == 0 {
+		p.add("AzureMonitoring", "needs at least one AzureMonitor or AppInsights config")
+	}
+}
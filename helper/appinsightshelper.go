@@ -1,6 +1,7 @@
 package helper
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -12,23 +13,26 @@ import (
 
 type AppInsightsHelper struct {
 	//configMap map[string]AppInsightConfig
-	config AzureMonitoringConfigMap
+	config   AzureMonitoringConfigMap
+	endpoint string
 }
 
-func NewAppInsightsHelper(config AzureMonitoringConfigMap) *AppInsightsHelper {
+// NewAppInsightsHelper uses the App Insights API at endpoint, eg Cloud.ApplicationInsights.
+func NewAppInsightsHelper(config AzureMonitoringConfigMap, endpoint string) *AppInsightsHelper {
 	ah := AppInsightsHelper{}
 	ah.config = config
+	ah.endpoint = endpoint
 	return &ah
 }
 
 // Checks app insights, which it tied to specific types of infra anyway.
-func getPerfCounter(appID string, apiKey string, perfCounter Metrics.AzureMetricName, timeSpanInMinutes int) (string, error) {
+func getPerfCounter(ctx context.Context, endpoint string, appID string, apiKey string, perfCounter Metrics.AzureMetricName, timeSpanInMinutes int) (string, error) {
 
 	ts := fmt.Sprintf("PT%dM", timeSpanInMinutes)
 	client := http.Client{}
-	template := "https://api.applicationinsights.io/v1/apps/%s/metrics/%s?timespan=%s&interval=%s&segment=cloud/roleName"
-	url := fmt.Sprintf(template, appID, perfCounter, ts, ts)
-	request, err := http.NewRequest("GET", url, nil)
+	template := "%s/v1/apps/%s/metrics/%s?timespan=%s&interval=%s&segment=cloud/roleName"
+	url := fmt.Sprintf(template, endpoint, appID, perfCounter, ts, ts)
+	request, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		return "", err
 	}
@@ -47,6 +51,17 @@ func getPerfCounter(appID string, apiKey string, perfCounter Metrics.AzureMetric
 	return string(body), nil
 }
 
+// sendResult sends the result on the channel, unless the context is done first in which
+// case nobody is listening any more. Returns false if the result wasn't sent.
+func sendResult(ctx context.Context, ch chan string, result string) bool {
+	select {
+	case ch <- result:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
 func (aih AppInsightsHelper) getAppInsightsCreds(env string, appInsightName string) (string, string, error) {
 	config, ok := aih.config.AppInsightsMap[env]
 	if ok {
@@ -65,50 +80,60 @@ func (aih AppInsightsHelper) getAppInsightsCreds(env string, appInsightName stri
 // GetCPUAverage gets the average CPU usage over a given time period.
 // Will try and make this more generic it expands.
 // Return the data via a channel
-func (aih AppInsightsHelper) GetCPUAverage(env string, appInsightsName string, spanInMinutes int, ch chan string) {
+func (aih AppInsightsHelper) GetCPUAverage(ctx context.Context, env string, appInsightsName string, spanInMinutes int, ch chan string) {
 
 	appID, apiKey, err := aih.getAppInsightsCreds(env, appInsightsName)
 	if err != nil {
 		return // nothing we can do. Maybe log it?
 	}
 
-	res, err := getPerfCounter(appID, apiKey, Metrics.ProcessorCpuPercentageMetric, spanInMinutes)
+	res, err := getPerfCounter(ctx, aih.endpoint, appID, apiKey, Metrics.ProcessorCpuPercentageMetric, spanInMinutes)
 	if err != nil {
-		ch <- ""
+		return
 	}
 
 	var perfStats Metrics.CPUProcessorAveragePercentage
 	json.Unmarshal([]byte(res), &perfStats)
+	if len(perfStats.Value.TimeSegments) == 0 {
+		return
+	}
 
 	// There are 2 lots of segments (go figure). Time Segments (time range split over multiple segments).
 	// second segment is via role!
 	// Only care about first segment for now...
 	for _, seg := range perfStats.Value.TimeSegments[0].Segments {
-		ch <- fmt.Sprintf("Average CPU percent for role %s over %d minutes is %.2f%%", seg.CloudRoleName, spanInMinutes, seg.CPUPercentage.Avg)
+		if !sendResult(ctx, ch, fmt.Sprintf("Average CPU percent for role %s over %d minutes is %.2f%%", seg.CloudRoleName, spanInMinutes, seg.CPUPercentage.Avg)) {
+			return
+		}
 	}
 }
 
 // GetMemoryAverage gets the average memory available over given span.
-func (aih AppInsightsHelper) GetMemoryAverage(env string, appInsightsName string, spanInMinutes int, ch chan string) {
+func (aih AppInsightsHelper) GetMemoryAverage(ctx context.Context, env string, appInsightsName string, spanInMinutes int, ch chan string) {
 
 	appID, apiKey, err := aih.getAppInsightsCreds(env, appInsightsName)
 	if err != nil {
 		return // nothing we can do. Maybe log it?
 	}
 
-	res, err := getPerfCounter(appID, apiKey, Metrics.MemoryAvailableBytesMetric, spanInMinutes)
+	res, err := getPerfCounter(ctx, aih.endpoint, appID, apiKey, Metrics.MemoryAvailableBytesMetric, spanInMinutes)
 	if err != nil {
-		ch <- ""
+		return
 	}
 
 	var perfStats Metrics.MemoryAvailableBytes
 	json.Unmarshal([]byte(res), &perfStats)
+	if len(perfStats.Value.TimeSegments) == 0 {
+		return
+	}
 
 	// There are 2 lots of segments (go figure). Time Segments (time range split over multiple segments).
 	// second segment is via role!
 	// Only care about first segment for now...
 	for _, seg := range perfStats.Value.TimeSegments[0].Segments {
 		mem := int(seg.MemoryAvailable.Avg / (1024 * 1024))
-		ch <- fmt.Sprintf("Average Memory available for role %s over %d minutes is %dMB", seg.CloudRoleName, spanInMinutes, mem)
+		if !sendResult(ctx, ch, fmt.Sprintf("Average Memory available for role %s over %d minutes is %dMB", seg.CloudRoleName, spanInMinutes, mem)) {
+			return
+		}
 	}
 }
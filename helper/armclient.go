@@ -0,0 +1,442 @@
+package helper
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/logging"
+)
+
+// DefaultManagementEndpoint is Azure Resource Manager in the public cloud.
+const DefaultManagementEndpoint = "https://management.azure.com"
+
+// status of a long running operation.
+const (
+	OperationInProgress = "InProgress"
+	OperationSucceeded  = "Succeeded"
+	OperationFailed     = "Failed"
+	OperationCanceled   = "Canceled"
+)
+
+// ARMClient talks to Azure Resource Manager. Requests that were throttled (429) or hit a server
+// error are retried with backoff, honoring Retry-After, and errors come back as *ARMError.
+type ARMClient struct {
+	tokens   *TokenSource
+	endpoint string
+	resource string // what tokens are issued for.
+	client   *http.Client
+
+	MaxRetries   int
+	MinBackoff   time.Duration
+	MaxBackoff   time.Duration
+	PollInterval time.Duration // how often operations are polled if ARM doesn't say.
+}
+
+// NewARMClient talks to the cloud's ResourceManager, see Cloud.Resolve.
+func NewARMClient(tokens *TokenSource, cloud Cloud) *ARMClient {
+	c := ARMClient{}
+	c.tokens = tokens
+	c.endpoint = cloud.ResourceManager
+	c.resource = cloud.ManagementResource
+
+	// billing pages can take minutes.
+	c.client = &http.Client{Timeout: 5 * time.Minute}
+	c.MaxRetries = 4
+	c.MinBackoff = 1 * time.Second
+	c.MaxBackoff = 1 * time.Minute
+	c.PollInterval = 10 * time.Second
+	return &c
+}
+
+// ResourcePath fills in format (eg "/subscriptions/%s/resourceGroups/%s") with args escaped,
+// apart from / so things like Microsoft.Cache/Redis still work.
+func ResourcePath(format string, args ...string) string {
+	escaped := make([]interface{}, len(args))
+	for i, arg := range args {
+		parts := strings.Split(arg, "/")
+		for j := range parts {
+			parts[j] = url.PathEscape(parts[j])
+		}
+		escaped[i] = strings.Join(parts, "/")
+	}
+	return fmt.Sprintf(format, escaped...)
+}
+
+// URL is the full URL for a path from ResourcePath, query can be nil.
+func (c *ARMClient) URL(path string, apiVersion string, query url.Values) string {
+	q := url.Values{}
+	for k, v := range query {
+		q[k] = v
+	}
+	q.Set("api-version", apiVersion)
+	return c.endpoint + path + "?" + q.Encode()
+}
+
+// ARMError is ARM's error envelope, {"error": {"code": ..., "message": ...}}.
+type ARMError struct {
+	StatusCode int
+	RequestID  string // x-ms-request-id, for support tickets.
+
+	Code    string           `json:"code"`
+	Message string           `json:"message"`
+	Target  string           `json:"target"`
+	Details []ARMErrorDetail `json:"details"`
+}
+
+type ARMErrorDetail struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Target  string `json:"target"`
+}
+
+func (e *ARMError) Error() string {
+	msg := e.Code
+	if e.Message != "" {
+		msg += " : " + e.Message
+	}
+	for _, d := range e.Details {
+		msg += fmt.Sprintf(" (%s : %s)", d.Code, d.Message)
+	}
+	if e.StatusCode != 0 {
+		msg = fmt.Sprintf("%d %s", e.StatusCode, msg)
+	}
+	return msg
+}
+
+// IsNotFound is true if err is ARM saying the resource doesn't exist.
+func IsNotFound(err error) bool {
+	var armErr *ARMError
+	return errors.As(err, &armErr) && armErr.StatusCode == http.StatusNotFound
+}
+
+func newARMError(resp *http.Response, body []byte) *ARMError {
+	envelope := struct {
+		Error *ARMError `json:"error"`
+	}{}
+	armErr := &ARMError{}
+	if json.Unmarshal(body, &envelope) == nil && envelope.Error != nil {
+		armErr = envelope.Error
+	} else {
+		armErr.Code = http.StatusText(resp.StatusCode)
+		armErr.Message = strings.TrimSpace(string(body))
+	}
+	armErr.StatusCode = resp.StatusCode
+	armErr.RequestID = resp.Header.Get("x-ms-request-id")
+	return armErr
+}
+
+// ARMResponse is a successful response, the body has already been read.
+type ARMResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// Do sends body (marshalled to JSON, unless nil) and unmarshals the response into out (unless
+// nil). Anything other than a 2xx is an *ARMError.
+func (c *ARMClient) Do(ctx context.Context, method string, url string, body interface{}, out interface{}) (*ARMResponse, error) {
+	var payload []byte
+	if body != nil {
+		var err error
+		payload, err = json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	resp, err := c.send(ctx, method, url, payload)
+	if err != nil {
+		return nil, err
+	}
+	if out != nil && len(resp.Body) > 0 {
+		if err := json.Unmarshal(resp.Body, out); err != nil {
+			return nil, fmt.Errorf("unable to read response from %s : %w", method, err)
+		}
+	}
+	return resp, nil
+}
+
+// List GETs url and every page after it (nextLink), calling page with each body.
+func (c *ARMClient) List(ctx context.Context, url string, page func(body []byte) error) error {
+	for url != "" {
+		resp, err := c.Do(ctx, http.MethodGet, url, nil, nil)
+		if err != nil {
+			return err
+		}
+		if err := page(resp.Body); err != nil {
+			return err
+		}
+
+		next := struct {
+			NextLink string `json:"nextLink"`
+		}{}
+		if err := json.Unmarshal(resp.Body, &next); err != nil {
+			return err
+		}
+		url = next.NextLink
+	}
+	return nil
+}
+
+// send is one request, retried if it was throttled or the server had a problem.
+func (c *ARMClient) send(ctx context.Context, method string, url string, payload []byte) (*ARMResponse, error) {
+	log := logging.FromContext(ctx)
+	for attempt := 0; ; attempt++ {
+		// cached, so this is usually free.
+		token, err := c.tokens.Token(ctx, c.resource)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, body, err := c.sendOnce(ctx, method, url, token, payload)
+		if err == nil && resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return &ARMResponse{StatusCode: resp.StatusCode, Header: resp.Header, Body: body}, nil
+		}
+
+		if err == nil {
+			err = newARMError(resp, body)
+		}
+		if ctx.Err() != nil || attempt >= c.MaxRetries || !retryable(method, resp) {
+			return nil, err
+		}
+
+		wait := c.backoff(attempt, resp)
+		log.Warnf("%s %s failed, retrying in %s : %s", method, redactURL(url), wait, err.Error())
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func (c *ARMClient) sendOnce(ctx context.Context, method string, url string, token string, payload []byte) (*http.Response, []byte, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewReader(payload))
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	if payload != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+	logging.FromContext(ctx).Debugf("%s %s : %d", method, redactURL(url), resp.StatusCode)
+	return resp, body, nil
+}
+
+// retryable is true if trying again could work. Throttled and unavailable requests weren't
+// processed so are always safe to send again, other server errors and network problems only
+// are if sending it twice doesn't matter.
+func retryable(method string, resp *http.Response) bool {
+	idempotent := method != http.MethodPost && method != http.MethodPatch
+	if resp == nil {
+		return idempotent
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+		return true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusGatewayTimeout:
+		return idempotent
+	}
+	return false
+}
+
+// backoff is Retry-After if ARM sent it, otherwise exponential with jitter.
+func (c *ARMClient) backoff(attempt int, resp *http.Response) time.Duration {
+	if resp != nil {
+		if wait, ok := retryAfter(resp.Header); ok {
+			if wait > c.MaxBackoff {
+				return c.MaxBackoff
+			}
+			return wait
+		}
+	}
+
+	wait := c.MinBackoff << uint(attempt)
+	if wait <= 0 || wait > c.MaxBackoff {
+		wait = c.MaxBackoff
+	}
+	return wait/2 + time.Duration(rand.Int63n(int64(wait/2)+1))
+}
+
+// retryAfter reads Retry-After, either seconds or an HTTP date.
+func retryAfter(h http.Header) (time.Duration, bool) {
+	v := h.Get("Retry-After")
+	if v == "" {
+		return 0, false
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second, true
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		wait := time.Until(t)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
+
+// redactURL drops the query, operation URLs can have signatures in them.
+func redactURL(u string) string {
+	if i := strings.Index(u, "?"); i >= 0 {
+		return u[:i]
+	}
+	return u
+}
+
+// Operation is a long running operation, eg a database export. ARM says where to poll with
+// Azure-AsyncOperation (a status document) or Location (202 until it's done).
+type Operation struct {
+	client *ARMClient
+
+	URL    string // what's polled, can be kept to resume polling later.
+	Async  bool   // URL came from Azure-AsyncOperation.
+	Status string
+	Err    *ARMError // set if the operation failed.
+	Result []byte    // body of the latest response, the final one once it's done.
+
+	wait time.Duration // Retry-After from the last response.
+}
+
+// Start sends the request and returns the operation it started. Requests that finished straight
+// away return an operation that's already done.
+func (c *ARMClient) Start(ctx context.Context, method string, url string, body interface{}) (*Operation, error) {
+	resp, err := c.Do(ctx, method, url, body, nil)
+	if err != nil {
+		return nil, err
+	}
+	return c.OperationFrom(resp), nil
+}
+
+// OperationFrom is the operation a response started, for when the request needed Do rather
+// than Start.
+func (c *ARMClient) OperationFrom(resp *ARMResponse) *Operation {
+	op := Operation{client: c, Status: OperationInProgress, Result: resp.Body}
+	op.wait, _ = retryAfter(resp.Header)
+	switch {
+	case resp.Header.Get("Azure-AsyncOperation") != "":
+		op.URL = resp.Header.Get("Azure-AsyncOperation")
+		op.Async = true
+	case resp.Header.Get("Location") != "" && resp.StatusCode == http.StatusAccepted:
+		op.URL = resp.Header.Get("Location")
+	default:
+		op.Status = OperationSucceeded
+	}
+	return &op
+}
+
+// ResumeOperation polls an operation started earlier, from its URL and Async.
+func (c *ARMClient) ResumeOperation(url string, async bool) *Operation {
+	return &Operation{client: c, URL: url, Async: async, Status: OperationInProgress}
+}
+
+// Done is true once the operation has succeeded, failed or been cancelled.
+func (op *Operation) Done() bool {
+	return op.Status != OperationInProgress
+}
+
+// Poll checks the operation once. An error means it couldn't be checked, not that the operation
+// failed, see Status and Err for that.
+func (op *Operation) Poll(ctx context.Context) error {
+	if op.Done() {
+		return nil
+	}
+
+	resp, err := op.client.Do(ctx, http.MethodGet, op.URL, nil, nil)
+	if err != nil {
+		// with Location, the operation failing is an error response.
+		var armErr *ARMError
+		if !op.Async && errors.As(err, &armErr) && armErr.StatusCode < 500 && armErr.StatusCode != http.StatusTooManyRequests {
+			op.Status = OperationFailed
+			op.Err = armErr
+			return nil
+		}
+		return err
+	}
+	op.wait, _ = retryAfter(resp.Header)
+	op.Result = resp.Body
+
+	if !op.Async {
+		if resp.StatusCode != http.StatusAccepted {
+			op.Status = OperationSucceeded
+		}
+		return nil
+	}
+
+	status := struct {
+		Status string    `json:"status"`
+		Error  *ARMError `json:"error"`
+	}{}
+	if err := json.Unmarshal(resp.Body, &status); err != nil {
+		return fmt.Errorf("unable to read operation status : %w", err)
+	}
+
+	switch strings.ToLower(status.Status) {
+	case "succeeded":
+		op.Status = OperationSucceeded
+	case "failed", "canceled", "cancelled":
+		op.Status = OperationFailed
+		if strings.HasPrefix(strings.ToLower(status.Status), "cancel") {
+			op.Status = OperationCanceled
+		}
+		op.Err = status.Error
+		if op.Err == nil {
+			op.Err = &ARMError{Code: status.Status, Message: "operation " + strings.ToLower(status.Status)}
+		}
+	}
+	return nil
+}
+
+// Wait polls until the operation is done or ctx is, and returns Err if it failed.
+func (op *Operation) Wait(ctx context.Context) error {
+	for !op.Done() {
+		if err := op.Poll(ctx); err != nil {
+			return err
+		}
+		if op.Done() {
+			break
+		}
+
+		select {
+		case <-time.After(op.NextPoll()):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+
+	if op.Err != nil {
+		return op.Err
+	}
+	return nil
+}
+
+// NextPoll is how long to wait before polling again.
+func (op *Operation) NextPoll() time.Duration {
+	if op.wait > 0 {
+		return op.wait
+	}
+	return op.client.PollInterval
+}
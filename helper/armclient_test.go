@@ -0,0 +1,238 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type fakeCredential struct{}
+
+func (fakeCredential) ID() string {
+	return "fake"
+}
+
+func (fakeCredential) NewToken(ctx context.Context, resource string) (*AzureAuthToken, error) {
+	return &AzureAuthToken{AccessToken: "token", ExpiresOnTime: time.Now().Add(time.Hour)}, nil
+}
+
+// testARMClient talks to the server, with backoff short enough for tests.
+func testARMClient(server *httptest.Server) *ARMClient {
+	cloud := AzurePublicCloud
+	cloud.ResourceManager = server.URL
+	c := NewARMClient(NewCredentialManager().Source(fakeCredential{}), cloud)
+	c.MinBackoff = time.Millisecond
+	c.MaxBackoff = 10 * time.Millisecond
+	c.PollInterval = time.Millisecond
+	return c
+}
+
+func TestResourcePath(t *testing.T) {
+	path := ResourcePath("/subscriptions/%s/resourceGroups/%s/providers/%s", "sub", "my rg", "Microsoft.Cache/Redis")
+	if path != "/subscriptions/sub/resourceGroups/my%20rg/providers/Microsoft.Cache/Redis" {
+		t.Errorf("got %s", path)
+	}
+}
+
+func TestARMClientRetry(t *testing.T) {
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer token" {
+			t.Errorf("no token, got %q", r.Header.Get("Authorization"))
+		}
+		switch atomic.AddInt32(&calls, 1) {
+		case 1:
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusTooManyRequests)
+		case 2:
+			w.WriteHeader(http.StatusBadGateway)
+		default:
+			fmt.Fprint(w, `{"name":"db"}`)
+		}
+	}))
+	defer server.Close()
+	c := testARMClient(server)
+
+	out := struct {
+		Name string `json:"name"`
+	}{}
+	if _, err := c.Do(context.Background(), http.MethodGet, c.URL("/thing", "2020-01-01", nil), nil, &out); err != nil {
+		t.Fatal(err)
+	}
+	if calls != 3 || out.Name != "db" {
+		t.Errorf("expected 3 calls and db, got %d and %q", calls, out.Name)
+	}
+}
+
+func TestARMClientNoRetry(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		method string
+		status int
+		calls  int32
+	}{
+		{"not found", http.MethodGet, http.StatusNotFound, 1},
+		{"post server error", http.MethodPost, http.StatusInternalServerError, 1},
+		{"post throttled", http.MethodPost, http.StatusTooManyRequests, 3},
+		{"gives up", http.MethodGet, http.StatusServiceUnavailable, 3},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var calls int32
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&calls, 1)
+				w.Header().Set("x-ms-request-id", "req-1")
+				w.WriteHeader(tc.status)
+				fmt.Fprint(w, `{"error":{"code":"Nope","message":"not today"}}`)
+			}))
+			defer server.Close()
+			c := testARMClient(server)
+			c.MaxRetries = 2
+
+			_, err := c.Do(context.Background(), tc.method, c.URL("/thing", "2020-01-01", nil), nil, nil)
+			var armErr *ARMError
+			if !errors.As(err, &armErr) {
+				t.Fatalf("expected an ARMError, got %v", err)
+			}
+			if armErr.StatusCode != tc.status || armErr.Code != "Nope" || armErr.RequestID != "req-1" {
+				t.Errorf("got %+v", armErr)
+			}
+			if calls != tc.calls {
+				t.Errorf("expected %d calls, got %d", tc.calls, calls)
+			}
+		})
+	}
+}
+
+func TestARMClientList(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Query().Get("page") {
+		case "":
+			fmt.Fprintf(w, `{"value":[1,2],"nextLink":"%s/things?page=2"}`, server.URL)
+		case "2":
+			fmt.Fprintf(w, `{"value":[3],"nextLink":"%s/things?page=3"}`, server.URL)
+		default:
+			fmt.Fprint(w, `{"value":[4]}`)
+		}
+	}))
+	defer server.Close()
+	c := testARMClient(server)
+
+	pages := 0
+	err := c.List(context.Background(), c.URL("/things", "2020-01-01", nil), func(body []byte) error {
+		pages++
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if pages != 3 {
+		t.Errorf("expected 3 pages, got %d", pages)
+	}
+}
+
+func TestOperationAsync(t *testing.T) {
+	var polls int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/export" {
+			w.Header().Set("Azure-AsyncOperation", server.URL+"/status")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if atomic.AddInt32(&polls, 1) < 3 {
+			fmt.Fprint(w, `{"status":"InProgress"}`)
+			return
+		}
+		fmt.Fprint(w, `{"status":"Succeeded"}`)
+	}))
+	defer server.Close()
+	c := testARMClient(server)
+
+	op, err := c.Start(context.Background(), http.MethodPost, c.URL("/export", "2020-01-01", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Done() || !op.Async || op.URL != server.URL+"/status" {
+		t.Fatalf("expected an async operation to poll, got %+v", op)
+	}
+	if err := op.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if op.Status != OperationSucceeded || polls != 3 {
+		t.Errorf("expected success after 3 polls, got %s after %d", op.Status, polls)
+	}
+}
+
+func TestOperationAsyncFailed(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"Failed","error":{"code":"ExportFailed","message":"no space"}}`)
+	}))
+	defer server.Close()
+	c := testARMClient(server)
+
+	op := c.ResumeOperation(server.URL+"/status", true)
+	err := op.Wait(context.Background())
+	var armErr *ARMError
+	if !errors.As(err, &armErr) || armErr.Code != "ExportFailed" {
+		t.Fatalf("expected ExportFailed, got %v", err)
+	}
+	if op.Status != OperationFailed {
+		t.Errorf("expected failed, got %s", op.Status)
+	}
+}
+
+func TestOperationLocation(t *testing.T) {
+	var polls int32
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/import" {
+			w.Header().Set("Location", server.URL+"/result")
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		if atomic.AddInt32(&polls, 1) < 2 {
+			w.WriteHeader(http.StatusAccepted)
+			return
+		}
+		fmt.Fprint(w, `{"name":"db"}`)
+	}))
+	defer server.Close()
+	c := testARMClient(server)
+
+	op, err := c.Start(context.Background(), http.MethodPut, c.URL("/import", "2020-01-01", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op.Async || op.URL != server.URL+"/result" {
+		t.Fatalf("expected to poll Location, got %+v", op)
+	}
+	if err := op.Wait(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if op.Status != OperationSucceeded || string(op.Result) != `{"name":"db"}` {
+		t.Errorf("got %s %s", op.Status, op.Result)
+	}
+}
+
+func TestOperationFinishedStraightAway(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"name":"db"}`)
+	}))
+	defer server.Close()
+	c := testARMClient(server)
+
+	op, err := c.Start(context.Background(), http.MethodPut, c.URL("/db", "2020-01-01", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !op.Done() || op.Status != OperationSucceeded {
+		t.Errorf("expected it to be done, got %+v", op)
+	}
+}
@@ -1,102 +1,396 @@
 package helper
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"encoding/pem"
+	"errors"
 	"fmt"
+	"github.com/kpfaulkner/wheatley/logging"
+	"io"
 	"io/ioutil"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"strings"
 	"time"
 )
 
+// ManagementResource is what tokens for Azure Resource Manager are issued for.
+const ManagementResource = "https://management.core.windows.net/"
+
+// DefaultAuthorityHost is Azure AD in the public cloud.
+const DefaultAuthorityHost = "https://login.microsoftonline.com"
+
+// imdsEndpoint is where VMs (and anything else without App Service's endpoint) get managed identity tokens.
+const imdsEndpoint = "http://169.254.169.254/metadata/identity/oauth2/token"
+
 type AzureAuthToken struct {
-	TokenType    string `json:"token_type"`
-	ExpiresIn    string `json:"expires_in"`
-	ExtExpiresIn string `json:"ext_expires_in"`
-	ExpiresOn    string `json:"expires_on"`
-	NotBefore    string `json:"not_before"`
-	Resource     string `json:"resource"`
-	AccessToken  string `json:"access_token"`
+	TokenType   string
+	Resource    string
+	AccessToken string
+
+	ExpiresOnTime time.Time
+}
+
+// Credential gets new tokens from Azure AD. Helpers should get tokens from a CredentialManager
+// rather than straight from a Credential, so they're cached and refreshed early.
+type Credential interface {
+	// ID is the same for credentials that would get the same tokens, so they can share them.
+	ID() string
+
+	NewToken(ctx context.Context, resource string) (*AzureAuthToken, error)
+}
+
+// AzureCredentialConfig is how a config section authenticates with Azure. ClientSecret or
+// ClientCertificate is needed, unless ManagedIdentity is set.
+type AzureCredentialConfig struct {
+	TenantID          string `json:"TenantID"`
+	ClientID          string `json:"ClientID"` // with ManagedIdentity, picks a user assigned identity.
+	ClientSecret      string `json:"ClientSecret"`
+	ClientCertificate string `json:"ClientCertificate"` // PEM file with the certificate and its private key.
+	ManagedIdentity   bool   `json:"ManagedIdentity"`
+}
+
+// NewCredential creates the credential the config describes, getting tokens from the cloud's
+// Active Directory. If secret isn't nil it's used rather than ClientSecret, eg to pick up a
+// rotated secret.
+func (c AzureCredentialConfig) NewCredential(cloud Cloud, secret SecretFunc) (Credential, error) {
+	switch {
+	case c.ManagedIdentity:
+		return NewManagedIdentityCredential(c.ClientID), nil
+	case c.ClientCertificate != "":
+		return NewClientCertificateCredential(cloud.ActiveDirectory, c.TenantID, c.ClientID, c.ClientCertificate)
+	}
 
-	ExpiresOnTime time.Time // converted from string above. Don't want to make custom unmarshaller.
+	if secret == nil {
+		secret = StaticSecret(c.ClientSecret)
+	}
+	return NewClientSecretCredential(cloud.ActiveDirectory, c.TenantID, c.ClientID, secret), nil
 }
 
-type AzureAuth struct {
-	tenantID     string
-	clientID     string
-	clientSecret string
+// SecretFunc returns the current client secret, so a rotated secret is used the next time a
+// token is needed.
+type SecretFunc func(ctx context.Context) (string, error)
+
+// StaticSecret is a SecretFunc for a secret that never changes.
+func StaticSecret(secret string) SecretFunc {
+	return func(ctx context.Context) (string, error) {
+		return secret, nil
+	}
+}
 
-	// current token. Check expiry time before trying to use it!
-	currentToken AzureAuthToken
+// ClientSecretCredential is a service principal using a client secret.
+type ClientSecretCredential struct {
+	tenantID  string
+	clientID  string
+	secret    SecretFunc
+	authority string
+	client    *http.Client
 }
 
-func NewAzureAuth(tenantID string, clientID string, clientSecret string) *AzureAuth {
-	aa := AzureAuth{}
-	aa.tenantID = tenantID
-	aa.clientSecret = clientSecret
-	aa.clientID = clientID
+// NewClientSecretCredential gets tokens from authority, eg DefaultAuthorityHost.
+func NewClientSecretCredential(authority string, tenantID string, clientID string, secret SecretFunc) *ClientSecretCredential {
+	c := ClientSecretCredential{}
+	c.tenantID = tenantID
+	c.clientID = clientID
+	c.secret = secret
+	c.authority = authority
+	c.client = &http.Client{Timeout: 30 * time.Second}
+	return &c
+}
+
+func (c *ClientSecretCredential) ID() string {
+	return "secret/" + c.tenantID + "/" + c.clientID
+}
+
+func (c *ClientSecretCredential) NewToken(ctx context.Context, resource string) (*AzureAuthToken, error) {
+	secret, err := c.secret(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("unable to get client secret : %w", err)
+	}
 
-	return &aa
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", c.clientID)
+	form.Set("client_secret", secret)
+	form.Set("scope", scopeFor(resource))
+	return requestToken(ctx, c.client, tokenURL(c.authority, c.tenantID), form, resource)
 }
 
-func (aa *AzureAuth) CurrentToken() AzureAuthToken {
-	return aa.currentToken
+// ClientCertificateCredential is a service principal using a certificate, which signs a client
+// assertion rather than a secret being sent.
+type ClientCertificateCredential struct {
+	tenantID   string
+	clientID   string
+	key        *rsa.PrivateKey
+	thumbprint string // base64url SHA-1 of the certificate, the x5t header.
+	authority  string
+	client     *http.Client
 }
 
-// refreshToken checks the token, if it's going to expire in the next 30 seconds then it will refresh it.
-func (aa *AzureAuth) RefreshToken() error {
-	n := time.Now().UTC().Add(5 * time.Minute)
-	fmt.Printf("time check is %s\n", n)
-	if aa.currentToken.AccessToken != "" {
-		fmt.Printf("existing token time %s\n", aa.currentToken.ExpiresOnTime.UTC())
+// NewClientCertificateCredential reads a PEM file containing the certificate and its RSA private key,
+// tokens come from authority.
+func NewClientCertificateCredential(authority string, tenantID string, clientID string, pemFile string) (*ClientCertificateCredential, error) {
+	b, err := ioutil.ReadFile(pemFile)
+	if err != nil {
+		return nil, err
 	}
 
-	if aa.currentToken.AccessToken == "" || aa.currentToken.ExpiresOnTime.UTC().Before(time.Now().UTC()) {
-		token, err := generateAuthHeader(aa.tenantID, aa.clientID, aa.clientSecret)
-		if err != nil {
-			fmt.Printf("error while generating auth token! %s\n", err.Error())
-			return err
+	c := ClientCertificateCredential{}
+	c.tenantID = tenantID
+	c.clientID = clientID
+	c.authority = authority
+	c.client = &http.Client{Timeout: 30 * time.Second}
+
+	for block, rest := pem.Decode(b); block != nil; block, rest = pem.Decode(rest) {
+		switch block.Type {
+		case "CERTIFICATE":
+			if c.thumbprint == "" {
+				sum := sha1.Sum(block.Bytes)
+				c.thumbprint = base64.RawURLEncoding.EncodeToString(sum[:])
+			}
+		case "RSA PRIVATE KEY":
+			if c.key, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+				return nil, fmt.Errorf("%s : %w", pemFile, err)
+			}
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, fmt.Errorf("%s : %w", pemFile, err)
+			}
+			rsaKey, ok := key.(*rsa.PrivateKey)
+			if !ok {
+				return nil, fmt.Errorf("%s : only RSA keys are supported", pemFile)
+			}
+			c.key = rsaKey
 		}
-		fmt.Printf("generated token with expiry %s\n", token.ExpiresOnTime)
-		aa.currentToken = *token
 	}
-	return nil
+
+	if c.thumbprint == "" {
+		return nil, fmt.Errorf("%s : no certificate found", pemFile)
+	}
+	if c.key == nil {
+		return nil, fmt.Errorf("%s : no private key found", pemFile)
+	}
+	return &c, nil
+}
+
+func (c *ClientCertificateCredential) ID() string {
+	return "certificate/" + c.tenantID + "/" + c.clientID + "/" + c.thumbprint
 }
 
-// see http://devchat.live/en/2017/02/27/access-metrics-using-azure-monitor-rest-api/
-// URL is https://login.microsoftonline.com/<tenantID>/oauth2/token
-func generateAuthHeader(tenantID string, clientID string, clientSecret string) (*AzureAuthToken, error) {
-	urlTemplate := "https://login.microsoftonline.com/%s/oauth2/token"
-	bodyTemplate := "grant_type=client_credentials&resource=https://management.core.windows.net/&client_id=%s&client_secret=%s"
-	url := fmt.Sprintf(urlTemplate, tenantID)
-	body := fmt.Sprintf(bodyTemplate, clientID, clientSecret)
-	request, err := http.NewRequest("POST", url, strings.NewReader(body))
+func (c *ClientCertificateCredential) NewToken(ctx context.Context, resource string) (*AzureAuthToken, error) {
+	endpoint := tokenURL(c.authority, c.tenantID)
+	assertion, err := c.assertion(endpoint)
 	if err != nil {
 		return nil, err
 	}
 
-	client := http.Client{}
-	resp, err := client.Do(request)
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", c.clientID)
+	form.Set("client_assertion_type", "urn:ietf:params:oauth:client-assertion-type:jwt-bearer")
+	form.Set("client_assertion", assertion)
+	form.Set("scope", scopeFor(resource))
+	return requestToken(ctx, c.client, endpoint, form, resource)
+}
+
+// assertion is a short lived JWT signed by the certificate's key.
+func (c *ClientCertificateCredential) assertion(audience string) (string, error) {
+	jti := make([]byte, 16)
+	if _, err := rand.Read(jti); err != nil {
+		return "", err
+	}
+
+	now := time.Now().Unix()
+	header := map[string]string{"alg": "RS256", "typ": "JWT", "x5t": c.thumbprint}
+	claims := map[string]interface{}{
+		"aud": audience,
+		"iss": c.clientID,
+		"sub": c.clientID,
+		"jti": hex.EncodeToString(jti),
+		"nbf": now,
+		"iat": now,
+		"exp": now + 600,
+	}
+
+	h, _ := json.Marshal(header)
+	cl, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(h) + "." + base64.RawURLEncoding.EncodeToString(cl)
+
+	hash := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, c.key, crypto.SHA256, hash[:])
+	if err != nil {
+		return "", err
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// ManagedIdentityCredential uses the identity Azure gives whatever Wheatley is running on. App
+// Service (and Azure Functions) provide an endpoint in env vars, everything else uses IMDS.
+type ManagedIdentityCredential struct {
+	clientID string // picks a user assigned identity, empty for the system assigned one.
+	client   *http.Client
+}
+
+func NewManagedIdentityCredential(clientID string) *ManagedIdentityCredential {
+	c := ManagedIdentityCredential{}
+	c.clientID = clientID
+	c.client = &http.Client{Timeout: 30 * time.Second}
+	return &c
+}
+
+func (c *ManagedIdentityCredential) ID() string {
+	return "managedidentity/" + c.clientID
+}
+
+func (c *ManagedIdentityCredential) NewToken(ctx context.Context, resource string) (*AzureAuthToken, error) {
+	q := url.Values{}
+	q.Set("resource", resource)
+
+	var endpoint string
+	header := http.Header{}
+	switch {
+	case os.Getenv("IDENTITY_ENDPOINT") != "" && os.Getenv("IDENTITY_HEADER") != "":
+		endpoint = os.Getenv("IDENTITY_ENDPOINT")
+		header.Set("X-IDENTITY-HEADER", os.Getenv("IDENTITY_HEADER"))
+		q.Set("api-version", "2019-08-01")
+		if c.clientID != "" {
+			q.Set("client_id", c.clientID)
+		}
+
+	// older App Service.
+	case os.Getenv("MSI_ENDPOINT") != "" && os.Getenv("MSI_SECRET") != "":
+		endpoint = os.Getenv("MSI_ENDPOINT")
+		header.Set("secret", os.Getenv("MSI_SECRET"))
+		q.Set("api-version", "2017-09-01")
+		if c.clientID != "" {
+			q.Set("clientid", c.clientID)
+		}
+
+	default:
+		endpoint = imdsEndpoint
+		header.Set("Metadata", "true")
+		q.Set("api-version", "2018-02-01")
+		if c.clientID != "" {
+			q.Set("client_id", c.clientID)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"?"+q.Encode(), nil)
 	if err != nil {
 		return nil, err
 	}
+	req.Header = header
+	return doTokenRequest(ctx, c.client, req, resource)
+}
+
+// tokenURL is the v2 endpoint, which takes scopes rather than resources.
+func tokenURL(authority string, tenantID string) string {
+	return fmt.Sprintf("%s/%s/oauth2/v2.0/token", strings.TrimRight(authority, "/"), url.PathEscape(tenantID))
+}
 
-	respBody, err := ioutil.ReadAll(resp.Body)
+// scopeFor turns a resource (eg https://management.core.windows.net/) into the v2 scope for it.
+// Anything already a scope is left alone.
+func scopeFor(resource string) string {
+	if strings.HasSuffix(resource, "/.default") {
+		return resource
+	}
+	return strings.TrimRight(resource, "/") + "/.default"
+}
+
+func requestToken(ctx context.Context, client *http.Client, endpoint string, form url.Values, resource string) (*AzureAuthToken, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
 	if err != nil {
 		return nil, err
 	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return doTokenRequest(ctx, client, req, resource)
+}
 
-	s := string(respBody)
-	fmt.Printf("resp is %s\n", s)
+// AuthError is Azure AD (or the managed identity endpoint) refusing to give out a token.
+type AuthError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *AuthError) Error() string {
+	return fmt.Sprintf("token request returned %d %s : %s", e.StatusCode, e.Code, e.Description)
+}
 
-	var auth AzureAuthToken
-	err = json.Unmarshal(respBody, &auth)
+// flexibleInt is a number that's sometimes sent as a string, depending on the endpoint.
+type flexibleInt int64
+
+func (f *flexibleInt) UnmarshalJSON(b []byte) error {
+	s := strings.Trim(string(b), `"`)
+	if s == "" || s == "null" {
+		return nil
+	}
+	n, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		// App Service's 2017-09-01 API sends expires_on as a date, expires_in is used instead.
+		return nil
+	}
+	*f = flexibleInt(n)
+	return nil
+}
+
+type tokenResponse struct {
+	TokenType   string      `json:"token_type"`
+	AccessToken string      `json:"access_token"`
+	ExpiresIn   flexibleInt `json:"expires_in"`
+	ExpiresOn   flexibleInt `json:"expires_on"`
+}
+
+func doTokenRequest(ctx context.Context, client *http.Client, req *http.Request, resource string) (*AzureAuthToken, error) {
+	resp, err := client.Do(req)
 	if err != nil {
 		return nil, err
 	}
+	defer resp.Body.Close()
 
-	i64, _ := strconv.Atoi(auth.ExpiresOn)
-	auth.ExpiresOnTime = time.Unix(int64(i64), 0)
-	return &auth, nil
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return nil, err
+	}
+
+	// the body has the access token in it, so only the status gets logged.
+	logging.FromContext(ctx).Debugf("token request for %s returned %d", resource, resp.StatusCode)
+
+	if resp.StatusCode != http.StatusOK {
+		authErr := AuthError{StatusCode: resp.StatusCode}
+		json.Unmarshal(body, &authErr)
+		return nil, &authErr
+	}
+
+	var tr tokenResponse
+	if err := json.Unmarshal(body, &tr); err != nil {
+		return nil, err
+	}
+	if tr.AccessToken == "" {
+		return nil, errors.New("token response didn't include an access token")
+	}
+
+	token := AzureAuthToken{TokenType: tr.TokenType, Resource: resource, AccessToken: tr.AccessToken}
+	switch {
+	case tr.ExpiresIn > 0:
+		token.ExpiresOnTime = time.Now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	case tr.ExpiresOn > 0:
+		token.ExpiresOnTime = time.Unix(int64(tr.ExpiresOn), 0)
+	default:
+		// assume the shortest lifetime Azure AD hands out.
+		token.ExpiresOnTime = time.Now().Add(5 * time.Minute)
+	}
+	return &token, nil
 }
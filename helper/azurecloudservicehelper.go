@@ -0,0 +1,31 @@
+package helper
+
+import (
+	"context"
+	"net/http"
+)
+
+// AzureCloudServiceHelper looks after classic cloud services.
+type AzureCloudServiceHelper struct {
+	arm            *ARMClient
+	subscriptionID string
+}
+
+func NewAzureCloudServiceHelper(arm *ARMClient, subscriptionID string) *AzureCloudServiceHelper {
+	ch := AzureCloudServiceHelper{}
+	ch.arm = arm
+	ch.subscriptionID = subscriptionID
+	return &ch
+}
+
+func (ch *AzureCloudServiceHelper) deploymentURL(rgName string, cloudServiceName string, slot string) string {
+	path := ResourcePath("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.ClassicCompute/domainNames/%s/slots/%s", ch.subscriptionID, rgName, cloudServiceName, slot)
+	return ch.arm.URL(path, "2015-06-01", nil)
+}
+
+// DeleteDeployment deletes the deployment in the slot (eg production), returning once Azure
+// has accepted the request.
+func (ch *AzureCloudServiceHelper) DeleteDeployment(ctx context.Context, rgName string, cloudServiceName string, slot string) error {
+	_, err := ch.arm.Do(ctx, http.MethodDelete, ch.deploymentURL(rgName, cloudServiceName, slot), nil, nil)
+	return err
+}
@@ -1,10 +1,11 @@
 package helper
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
-	"io/ioutil"
-	"net/http"
+	"github.com/kpfaulkner/wheatley/logging"
+	"net/url"
 	"strings"
 	"sync"
 	"time"
@@ -58,75 +59,38 @@ type BillingResponse struct {
 }
 
 type AzureCost struct {
-	azureAuth      *AzureAuth
+	arm            *ARMClient
 	subscriptionID string
-	tenantID       string
-	clientID       string
-	clientSecret   string
 }
 
-func NewAzureCost(tenantID string, clientID string, clientSecret string) AzureCost {
+func NewAzureCost(arm *ARMClient) AzureCost {
 	a := AzureCost{}
-	a.tenantID = tenantID
-	a.clientID = clientID
-	a.clientSecret = clientSecret
-	a.azureAuth = NewAzureAuth(tenantID, clientID, clientSecret)
+	a.arm = arm
 
 	return a
 }
 
 // just testing out ideas....   naming rocks.
-func (ac *AzureCost) GetAllBillingForSubscriptionID(subscriptionID string, startDate time.Time, endDate time.Time) ([]DailyBillingDetails, error) {
-	err := ac.azureAuth.RefreshToken()
-	if err != nil {
-		fmt.Printf("unable to refresh token: %s\n", err.Error())
-		return nil, err
-	}
-
-	// taken from https://docs.microsoft.com/en-us/azure/cost-management-billing/costs/quick-acm-cost-analysis   unsure if works yet
+func (ac *AzureCost) GetAllBillingForSubscriptionID(ctx context.Context, subscriptionID string, startDate time.Time, endDate time.Time) ([]DailyBillingDetails, error) {
+	// taken from https://docs.microsoft.com/en-us/azure/cost-management-billing/costs/quick-acm-cost-analysis
 	// https://management.azure.com/{scope}/providers/Microsoft.Consumption/usageDetails?metric=AmortizedCost&$filter=properties/usageStart+ge+'2019-04-01'+AND+properties/usageEnd+le+'2019-04-30'&api-version=2019-04-01-preview
-	// THiS WORKSSSS template := "https://management.azure.com/subscriptions/%s/providers/Microsoft.Consumption/usageDetails?metric=ActualCost&$filter=properties/usageStart+ge+'2020-04-01'+AND+properties/usageEnd+le+'2020-04-30'&api-version=2019-04-01-preview"
-	template := "https://management.azure.com/subscriptions/%s/providers/Microsoft.Consumption/usageDetails?metric=ActualCost&$filter=properties/usageStart+ge+'%s'+AND+properties/usageEnd+le+'%s'&api-version=2019-04-01-preview"
+	query := url.Values{}
+	query.Set("metric", "ActualCost")
+	query.Set("$filter", fmt.Sprintf("properties/usageStart ge '%s' AND properties/usageEnd le '%s'", startDate.Format("2006-01-02"), endDate.Format("2006-01-02")))
+	path := ResourcePath("/subscriptions/%s/providers/Microsoft.Consumption/usageDetails", subscriptionID)
 
-	url := fmt.Sprintf(template, subscriptionID, startDate.Format("2006-01-02"), endDate.Format("2006-01-02"))
-
-	done := false
 	billingDetails := []DailyBillingDetails{}
-	for !done {
-		// replace spaces with +.... should call proper encode...
-		url = strings.Replace(url, " ", "+", -1)
-		request, err := http.NewRequest("GET", url, nil)
-		if err != nil {
-			fmt.Printf("couldn't generate HTTP request %s\n", err.Error())
-			return nil, err
-		}
-
-		// yes, 5 minute timeout....   getting costs can really take a while :/
-		client := http.Client{Timeout: 300 * time.Second}
-		request.Header.Set("Authorization", "Bearer "+ac.azureAuth.CurrentToken().AccessToken)
-		resp, err := client.Do(request)
-		if err != nil {
-			fmt.Printf("couldn't execute HTTP request %s\n", err.Error())
-			return nil, err
-		}
-
-		body, err := ioutil.ReadAll(resp.Body)
-		if err != nil {
-			return nil, err
-		}
-
+	err := ac.arm.List(ctx, ac.arm.URL(path, "2019-04-01-preview", query), func(body []byte) error {
 		br := BillingResponse{}
-		err = json.Unmarshal(body, &br)
-		if err != nil {
-			return nil, err
+		if err := json.Unmarshal(body, &br); err != nil {
+			return err
 		}
-
 		billingDetails = append(billingDetails, br.Value...)
-		if br.NextLink == "" {
-			done = true
-		} else {
-			url = br.NextLink
-		}
+		return nil
+	})
+	if err != nil {
+		logging.FromContext(ctx).Errorf("unable to get billing for %s : %s", subscriptionID, err.Error())
+		return nil, err
 	}
 
 	return billingDetails, nil
@@ -152,27 +116,53 @@ func CalculateCostsPerResourceGroup(data []DailyBillingDetails) (map[string]floa
 	return resourceGroupCosting, total, nil
 }
 
-func (ac *AzureCost) GenerateSubscriptionCostDetails(subscriptionIDs []string, startDate time.Time, endDate time.Time) ([]SubscriptionCosts, error) {
+// CostProgressFunc is called as each subscription finishes. err is set if that subscription failed.
+type CostProgressFunc func(subscriptionID string, done int, total int, err error)
+
+func (ac *AzureCost) GenerateSubscriptionCostDetails(ctx context.Context, subscriptionIDs []string, startDate time.Time, endDate time.Time) ([]SubscriptionCosts, error) {
+	return ac.GenerateSubscriptionCostDetailsWithProgress(ctx, subscriptionIDs, startDate, endDate, nil)
+}
+
+// GenerateSubscriptionCostDetailsWithProgress is the same as GenerateSubscriptionCostDetails but calls
+// progress (if not nil) as each subscription finishes.
+func (ac *AzureCost) GenerateSubscriptionCostDetailsWithProgress(ctx context.Context, subscriptionIDs []string, startDate time.Time, endDate time.Time, progress CostProgressFunc) ([]SubscriptionCosts, error) {
 
 	subscriptionCosts := []SubscriptionCosts{}
+	done := 0
 
 	// for this simple case, just lock it.
 	var lock sync.Mutex
 	var wg sync.WaitGroup
 
+	// reports the subscription as finished, must be called with the lock held.
+	finished := func(subID string, err error) {
+		done++
+		if progress != nil {
+			progress(subID, done, len(subscriptionIDs), err)
+		}
+	}
+
 	for _, subscriptionID := range subscriptionIDs {
 		sId := subscriptionID
 		start := startDate
 		end := endDate
 		wg.Add(1)
 		go func(subID string, sDate time.Time, eDate time.Time) {
-			data, err := ac.GetAllBillingForSubscriptionID(subID, sDate, eDate)
+			defer wg.Done()
+
+			data, err := ac.GetAllBillingForSubscriptionID(ctx, subID, sDate, eDate)
 			if err != nil {
+				lock.Lock()
+				finished(subID, err)
+				lock.Unlock()
 				return
 			}
 
 			rgData, total, err := CalculateCostsPerResourceGroup(data)
 			if err != nil {
+				lock.Lock()
+				finished(subID, err)
+				lock.Unlock()
 				return
 			}
 
@@ -183,8 +173,8 @@ func (ac *AzureCost) GenerateSubscriptionCostDetails(subscriptionIDs []string, s
 
 			lock.Lock()
 			subscriptionCosts = append(subscriptionCosts, sc)
+			finished(subID, nil)
 			lock.Unlock()
-			wg.Done()
 		}(sId, start, end)
 	}
 
@@ -193,12 +183,12 @@ func (ac *AzureCost) GenerateSubscriptionCostDetails(subscriptionIDs []string, s
 	return subscriptionCosts, nil
 }
 
-func (ac *AzureCost) GenerateSubscriptionCostDetailsSequential(subscriptionIDs []string, startDate time.Time, endDate time.Time) ([]SubscriptionCosts, error) {
+func (ac *AzureCost) GenerateSubscriptionCostDetailsSequential(ctx context.Context, subscriptionIDs []string, startDate time.Time, endDate time.Time) ([]SubscriptionCosts, error) {
 
 	subscriptionCosts := []SubscriptionCosts{}
 
 	for _, subscriptionID := range subscriptionIDs {
-		data, err := ac.GetAllBillingForSubscriptionID(subscriptionID, startDate, endDate)
+		data, err := ac.GetAllBillingForSubscriptionID(ctx, subscriptionID, startDate, endDate)
 		if err != nil {
 			return nil, err
 		}
@@ -1,10 +1,5 @@
 package helper
 
-import (
-	"encoding/json"
-	"os"
-)
-
 type AppInsightsConfig struct {
 	Env       string `json:"env"`
 	Resources []struct {
@@ -23,11 +18,9 @@ type AzureMonitorResource struct {
 }
 
 type AzureMonitor struct {
-	Name              string                 `json:"Name"`
-	SubscriptionID    string                 `json:"SubscriptionID"`
-	TenantID          string                 `json:"TenantID"`
-	ClientID          string                 `json:"ClientID"`
-	ClientSecret      string                 `json:"ClientSecret"`
+	Name           string `json:"Name"`
+	SubscriptionID string `json:"SubscriptionID"`
+	AzureCredentialConfig
 	ResourceToMonitor []AzureMonitorResource `json:"ResourceToMonitor"`
 }
 
@@ -46,18 +39,8 @@ type AzureMonitoringConfigMap struct {
 	AzureMonitorMap map[string]AzureMonitor
 }
 
-func LoadAzureMonitoringConfig(configFileName string) (*AzureMonitoringConfigMap, error) {
-	var config AzureMonitoringConfig
-	configFile, err := os.Open(configFileName)
-	defer configFile.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	jsonParser := json.NewDecoder(configFile)
-	jsonParser.Decode(&config)
-
-	// generate keys!!!
+// NewAzureMonitoringConfigMap builds the lookups by env name.
+func NewAzureMonitoringConfigMap(config AzureMonitoringConfig) *AzureMonitoringConfigMap {
 	amcm := AzureMonitoringConfigMap{}
 	amcm.AppInsightsMap = make(map[string]AppInsightsConfig)
 	amcm.AzureMonitorMap = make(map[string]AzureMonitor)
@@ -73,5 +56,5 @@ func LoadAzureMonitoringConfig(configFileName string) (*AzureMonitoringConfigMap
 		amcm.AppInsightsMap[r.Env] = r
 	}
 
-	return &amcm, nil
+	return &amcm
 }
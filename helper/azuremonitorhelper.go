@@ -1,10 +1,9 @@
 package helper
 
 import (
-	"encoding/json"
+	"context"
 	"fmt"
-	"github.com/google/martian/log"
-	"io/ioutil"
+	"github.com/kpfaulkner/wheatley/logging"
 	"net/http"
 	"net/url"
 	"strings"
@@ -43,86 +42,38 @@ type MetricResponse struct {
 type AzureMonitorHelper struct {
 	config AzureMonitoringConfigMap
 
-	azureAuthMap map[string]*AzureAuth
-	//azureAuth *AzureAuth
+	// env -> ARM client with that env's credentials.
+	arm map[string]*ARMClient
 }
 
 // NewAzureMonitorHelper does the Azure specifics....
-func NewAzureMonitorHelper(config AzureMonitoringConfigMap) *AzureMonitorHelper {
+func NewAzureMonitorHelper(config AzureMonitoringConfigMap, arm map[string]*ARMClient) *AzureMonitorHelper {
 	ah := AzureMonitorHelper{}
 	ah.config = config
-
-	// which env?
-	//ah.azureAuth = NewAzureAuth(config)
-	aam, err := generateAzureAuthMap(config)
-	if err != nil {
-		fmt.Printf("KABOOM with AzureMonitorHelper!! %s\n", err.Error())
-		panic(err)
-	}
-	ah.azureAuthMap = aam
+	ah.arm = arm
 	return &ah
 }
 
-func generateAzureAuthMap(config AzureMonitoringConfigMap) (map[string]*AzureAuth, error) {
-
-	aam := make(map[string]*AzureAuth)
-	for k, v := range config.AzureMonitorMap {
-		auth := NewAzureAuth(v.TenantID, v.ClientID, v.ClientSecret)
-		aam[k] = auth
-	}
-
-	return aam, nil
-}
-
-func (ah *AzureMonitorHelper) currentToken(env string) AzureAuthToken {
-	aa := ah.azureAuthMap[env]
-	return aa.currentToken
-}
-
-// wrapper around AzureAuth instance.
-func (ah *AzureMonitorHelper) refreshToken(env string) error {
-	aa := ah.azureAuthMap[env]
-	err := aa.RefreshToken()
-	return err
-}
-
-func (ah *AzureMonitorHelper) GetMetrics(env string, subscriptionID string, resourceGroup string, metricDefinition string, resourceName string, startTime time.Time, endTime time.Time, metricNamesSlice []string) (*MetricResponse, error) {
-
-	err := ah.refreshToken(env)
-	if err != nil {
-		return nil, err
+func (ah *AzureMonitorHelper) GetMetrics(ctx context.Context, env string, subscriptionID string, resourceGroup string, metricDefinition string, resourceName string, startTime time.Time, endTime time.Time, metricNamesSlice []string) (*MetricResponse, error) {
+	arm, ok := ah.arm[env]
+	if !ok {
+		return nil, fmt.Errorf("no Azure Monitor credentials for %s", env)
 	}
 
 	// should be something like: "serverLoad,usedmemorypercentage,usedmemory"
-	metricNames := url.PathEscape(strings.Join(metricNamesSlice, ","))
-	template := "https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/%s/%s/providers/microsoft.insights/metrics?metricnames=%s&timespan=%s/%s&aggregation=Average&api-version=2018-01-01"
-	url := fmt.Sprintf(template, subscriptionID, resourceGroup, metricDefinition, resourceName, metricNames, startTime.Format("2006-01-02T15:04:05Z"), endTime.Format("2006-01-02T15:04:05Z"))
-
-	request, err := http.NewRequest("GET", url, nil)
-	if err != nil {
-		return nil, err
-	}
-
-	client := http.Client{}
-	request.Header.Set("Authorization", "Bearer "+ah.currentToken(env).AccessToken)
-	resp, err := client.Do(request)
-	if err != nil {
-		return nil, err
-	}
-
-	body, err := ioutil.ReadAll(resp.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	s := string(body)
-	fmt.Printf("body is %s\n", s)
+	query := url.Values{}
+	query.Set("metricnames", strings.Join(metricNamesSlice, ","))
+	query.Set("timespan", startTime.Format("2006-01-02T15:04:05Z")+"/"+endTime.Format("2006-01-02T15:04:05Z"))
+	query.Set("aggregation", "Average")
+	path := ResourcePath("/subscriptions/%s/resourceGroups/%s/providers/%s/%s/providers/microsoft.insights/metrics", subscriptionID, resourceGroup, metricDefinition, resourceName)
 
 	mr := MetricResponse{}
-	err = json.Unmarshal(body, &mr)
+	resp, err := arm.Do(ctx, http.MethodGet, arm.URL(path, "2018-01-01", query), nil, &mr)
 	if err != nil {
 		return nil, err
 	}
+
+	logging.FromContext(ctx).Debugf("body is %s", string(resp.Body))
 	return &mr, nil
 }
 
@@ -152,23 +103,23 @@ func generateAverageForTimeSpan(resourceName string, metricName string, unit str
 	return "", nil
 }
 
-func (ah *AzureMonitorHelper) GetResourceMetrics(env string, resourceFriendlyName string, resourceGroup string, resourceName string, metricDefinition string, metrics []string, spanInMinutes int, ch chan string) {
+func (ah *AzureMonitorHelper) GetResourceMetrics(ctx context.Context, env string, resourceFriendlyName string, resourceGroup string, resourceName string, metricDefinition string, metrics []string, spanInMinutes int, ch chan string) {
 
 	start := time.Now().UTC().Add(time.Duration(spanInMinutes) * time.Minute * -1)
 	end := time.Now().UTC()
-	resp, err := ah.GetMetrics(env, ah.config.AzureMonitorMap[env].SubscriptionID, resourceGroup, metricDefinition, resourceName, start, end, metrics)
+	resp, err := ah.GetMetrics(ctx, env, ah.config.AzureMonitorMap[env].SubscriptionID, resourceGroup, metricDefinition, resourceName, start, end, metrics)
 	if err != nil {
 		// ignore for moment...
-		log.Errorf("blew up when getting redis?!? %s\n", err.Error())
+		logging.FromContext(ctx).Errorf("unable to get metrics for %s : %s", resourceFriendlyName, err.Error())
 		return
 	}
 
-	fmt.Printf("resp is %v\n", resp)
+	logging.FromContext(ctx).Debugf("got %d metrics for %s", len(resp.Value), resourceFriendlyName)
 
 	for _, metric := range resp.Value {
 		av, err := generateAverageForTimeSpan(resourceFriendlyName, metric.Name.LocalizedValue, metric.Unit, metric.Timeseries)
-		if err == nil {
-			ch <- av
+		if err == nil && !sendResult(ctx, ch, av) {
+			return
 		}
 	}
 
@@ -1,20 +1,19 @@
 package helper
 
 import (
+	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
-	"io/ioutil"
+	"github.com/kpfaulkner/wheatley/logging"
 	"net/http"
 	"strings"
 )
 
 type AzureSQLHelper struct {
-	azureAuth              *AzureAuth
+	arm                    *ARMClient
 	exportSubscriptionID   string
 	importSubscriptionID   string
-	tenantID               string
-	clientID               string
-	clientSecret           string
 	sqlExportAdminLogin    string
 	sqlExportAdminPassword string
 	sqlImportAdminLogin    string
@@ -26,14 +25,11 @@ type AzureSQLHelper struct {
 	importStorageKey       string
 }
 
-func NewAzureSQLHelper(importSubscriptionID string, exportSubscriptionID string, tenantID string, clientID string, clientSecret string, sqlExportAdminLogin string, sqlExportAdminPassword string, sqlImportAdminLogin string, sqlImportAdminPassword string, storageKey string, storageURL string, exportSqlRgName string, importSqlRgName string, importStorageKey string) *AzureSQLHelper {
+func NewAzureSQLHelper(arm *ARMClient, importSubscriptionID string, exportSubscriptionID string, sqlExportAdminLogin string, sqlExportAdminPassword string, sqlImportAdminLogin string, sqlImportAdminPassword string, storageKey string, storageURL string, exportSqlRgName string, importSqlRgName string, importStorageKey string) *AzureSQLHelper {
 	ah := AzureSQLHelper{}
-	ah.azureAuth = NewAzureAuth(tenantID, clientID, clientSecret)
+	ah.arm = arm
 	ah.exportSubscriptionID = exportSubscriptionID
 	ah.importSubscriptionID = importSubscriptionID
-	ah.tenantID = tenantID
-	ah.clientID = clientID
-	ah.clientSecret = clientSecret
 	ah.sqlExportAdminLogin = sqlExportAdminLogin
 	ah.sqlExportAdminPassword = sqlExportAdminPassword
 	ah.sqlImportAdminLogin = sqlImportAdminLogin
@@ -46,229 +42,203 @@ func NewAzureSQLHelper(importSubscriptionID string, exportSubscriptionID string,
 	return &ah
 }
 
-func (ah *AzureSQLHelper) currentToken() AzureAuthToken {
-	return ah.azureAuth.currentToken
+func (ah *AzureSQLHelper) importURL(subscriptionID string, rgName string, serverName string, databaseName string) string {
+	path := ResourcePath("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/databases/%s/extensions/import", subscriptionID, rgName, serverName, databaseName)
+	return ah.arm.URL(path, "2014-04-01", nil)
 }
 
-// wrapper around AzureAuth instance.
-func (ah *AzureSQLHelper) refreshToken() error {
-	err := ah.azureAuth.RefreshToken()
-	return err
+type importProperties struct {
+	StorageKeyType             string `json:"storageKeyType"`
+	StorageKey                 string `json:"storageKey"`
+	StorageURI                 string `json:"storageUri"`
+	AdministratorLogin         string `json:"administratorLogin"`
+	AdministratorLoginPassword string `json:"administratorLoginPassword"`
+	OperationMode              string `json:"operationMode"`
 }
 
-func generateImportURL(subscriptionID string, rgName string, serverName string, databaseName string) string {
-	template := "https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/databases/%s/extensions/import?api-version=2014-04-01"
-	url := fmt.Sprintf(template, subscriptionID, rgName, serverName, databaseName)
-	return url
+func generateImportBody(adminLogin string, adminLoginPassword string, storageKey string, storageUri string) interface{} {
+	return struct {
+		Properties importProperties `json:"properties"`
+	}{importProperties{
+		StorageKeyType:             "StorageAccessKey",
+		StorageKey:                 storageKey,
+		StorageURI:                 storageUri,
+		AdministratorLogin:         adminLogin,
+		AdministratorLoginPassword: adminLoginPassword,
+		OperationMode:              "Import",
+	}}
 }
 
-func generateImportBody(adminLogin string, adminLoginPassword string, storageKey string, storageUri string) string {
-	/*template2 := `{administratorLogin: "%s",administratorLoginPassword: "%s",storageKey: "%s",storageKeyType: "%s",storageUri: "%s",
-	databasename:"%s", edition:"%s",tier:"Standard", skuName:"S2", location:"southcentralus", serviceObjectiveName:"%s",maxSizeBytes:"%d"}` */
-	template := `{"properties": {"storageKeyType": "StorageAccessKey", "storageKey": "%s", "storageUri": "%s", "administratorLogin": "%s", "administratorLoginPassword": "%s", "operationMode": "Import"}}`
-	body := fmt.Sprintf(template, storageKey, storageUri, adminLogin, adminLoginPassword)
-	return body
+func (ah *AzureSQLHelper) exportURL(subscriptionID string, rgName string, serverName string, databaseName string) string {
+	path := ResourcePath("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/databases/%s/export", subscriptionID, rgName, serverName, databaseName)
+	return ah.arm.URL(path, "2014-04-01", nil)
 }
 
-func generateExportURL(subscriptionID string, rgName string, serverName string, databaseName string) string {
-	template := "https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/databases/%s/export?api-version=2014-04-01"
-	url := fmt.Sprintf(template, subscriptionID, rgName, serverName, databaseName)
-	return url
+type exportRequest struct {
+	AdministratorLogin         string `json:"administratorLogin"`
+	AdministratorLoginPassword string `json:"administratorLoginPassword"`
+	StorageKey                 string `json:"storageKey"`
+	StorageKeyType             string `json:"storageKeyType"`
+	StorageURI                 string `json:"storageUri"`
 }
 
-func generateExportBody(adminLogin string, adminLoginPassword string, storageKey string, storageKeyType string, storageUri string) string {
-	template := `{administratorLogin: "%s",administratorLoginPassword: "%s",storageKey: "%s",storageKeyType: "%s",storageUri: "%s"}`
-	body := fmt.Sprintf(template, adminLogin, adminLoginPassword, storageKey, storageKeyType, storageUri)
-	return body
+func generateExportBody(adminLogin string, adminLoginPassword string, storageKey string, storageKeyType string, storageUri string) interface{} {
+	return exportRequest{
+		AdministratorLogin:         adminLogin,
+		AdministratorLoginPassword: adminLoginPassword,
+		StorageKey:                 storageKey,
+		StorageKeyType:             storageKeyType,
+		StorageURI:                 storageUri,
+	}
 }
 
-// StartDBExport starts an export of an Azure DB to blob storage.
+// StartDBExport starts an export of an Azure DB to blob storage. Exports take a while, wait on
+// (or poll) the operation and use ImportExportStatus to see how it's going.
 // https://docs.microsoft.com/en-us/rest/api/sql/databases%20-%20import%20export/export
-func (ah *AzureSQLHelper) StartDBExport(serverName string, databaseName string, backupFileName string) error {
-
-	// refresh all the tokens!!!
-	err := ah.refreshToken()
-	if err != nil {
-		return err
-	}
-
+func (ah *AzureSQLHelper) StartDBExport(ctx context.Context, serverName string, databaseName string, backupFileName string) (*Operation, error) {
 	storageURI := fmt.Sprintf("%s/%s", ah.storageURL, backupFileName)
 	body := generateExportBody(ah.sqlExportAdminLogin, ah.sqlExportAdminPassword, ah.storageKey, "SharedAccessKey", storageURI)
-	url := generateExportURL(ah.exportSubscriptionID, ah.exportSqlRgName, serverName, databaseName)
-	client := &http.Client{}
-
-	req, err := http.NewRequest("POST", url, strings.NewReader(body))
-	req.Header.Add("Authorization", "Bearer "+ah.currentToken().AccessToken)
-	req.Header.Add("Content-type", "application/json")
-
-	resp, err := client.Do(req)
+	op, err := ah.startImportExport(ctx, http.MethodPost, ah.exportURL(ah.exportSubscriptionID, ah.exportSqlRgName, serverName, databaseName), body)
 	if err != nil {
-		fmt.Printf("error on post %s\n", err.Error())
-		panic(err)
+		return nil, fmt.Errorf("unable to start backup : %w", err)
 	}
 
-	fmt.Printf("status code is %d\n", resp.StatusCode)
-
-	// if status begins with 4.... assume failure.
-	if strings.HasPrefix(resp.Status, "4") {
-		return errors.New("unable to start backup")
-	}
-
-	return nil
+	logging.FromContext(ctx).Debugf("export of %s started, polling %s", databaseName, redactURL(op.URL))
+	return op, nil
 }
 
 // StartDBImport starts to import from a blob backup file to a specific DB server and dbname
-// keep to a default size for now.
+// keep to a default size for now. Same as StartDBExport, the operation says when it's done.
 // https://docs.microsoft.com/en-us/rest/api/sql/databases%20-%20import%20export/import
-func (ah *AzureSQLHelper) StartDBImport(importServerName string, databaseName string, backupBlobName string) error {
-
-	// refresh all the tokens!!!
-	err := ah.refreshToken()
-	if err != nil {
-		return err
-	}
-
+func (ah *AzureSQLHelper) StartDBImport(ctx context.Context, importServerName string, databaseName string, backupBlobName string) (*Operation, error) {
 	storageURI := fmt.Sprintf("%s/%s", ah.storageURL, backupBlobName)
 	body := generateImportBody(ah.sqlImportAdminLogin, ah.sqlImportAdminPassword, ah.importStorageKey, storageURI)
-	url := generateImportURL(ah.importSubscriptionID, ah.importSqlRgName, importServerName, databaseName)
-	client := &http.Client{}
+	op, err := ah.startImportExport(ctx, http.MethodPut, ah.importURL(ah.importSubscriptionID, ah.importSqlRgName, importServerName, databaseName), body)
+	if err != nil {
+		return nil, fmt.Errorf("unable to start import : %w", err)
+	}
 
-	req, err := http.NewRequest("PUT", url, strings.NewReader(body))
-	req.Header.Add("Authorization", "Bearer "+ah.currentToken().AccessToken)
-	req.Header.Add("Content-type", "application/json")
+	logging.FromContext(ctx).Debugf("import of %s started, polling %s", databaseName, redactURL(op.URL))
+	return op, nil
+}
 
-	resp, err := client.Do(req)
+// startImportExport sends the request and returns the operation. SQL's Location (the
+// importExportOperationResults) says how far along it is, eg "Running, Progress = 45%", so
+// that's polled rather than Azure-AsyncOperation which only says it's in progress.
+func (ah *AzureSQLHelper) startImportExport(ctx context.Context, method string, url string, body interface{}) (*Operation, error) {
+	resp, err := ah.arm.Do(ctx, method, url, body, nil)
 	if err != nil {
-		fmt.Printf("error on put %s\n", err.Error())
-		panic(err)
+		return nil, err
 	}
 
-	fmt.Printf("status code is %d\n", resp.StatusCode)
-	b, _ := ioutil.ReadAll(resp.Body)
-	fmt.Printf("body is %s\n", string(b))
+	if location := resp.Header.Get("Location"); location != "" && resp.StatusCode == http.StatusAccepted {
+		op := ah.arm.ResumeOperation(location, false)
+		op.Result = resp.Body
+		return op, nil
+	}
+	return ah.arm.OperationFrom(resp), nil
+}
+
+// importExportResult is what SQL says about an import or export while it's running and once
+// it's done.
+type importExportResult struct {
+	Status       string `json:"status"`
+	ErrorMessage string `json:"errorMessage"`
+	Properties   *struct {
+		Status       string `json:"status"`
+		ErrorMessage string `json:"errorMessage"`
+	} `json:"properties"`
+}
+
+// ImportExportStatus is what SQL last said about an import or export, eg "Running, Progress = 45%"
+// or "Completed". SQL can report an export failed even though polling it succeeded, in which
+// case err says why.
+func ImportExportStatus(op *Operation) (string, error) {
+	if op.Err != nil {
+		return op.Status, op.Err
+	}
 
-	// if status begins with 4.... assume failure.
-	if strings.HasPrefix(resp.Status, "4") {
-		return errors.New("unable to start backup")
+	result := importExportResult{}
+	if len(op.Result) == 0 || json.Unmarshal(op.Result, &result) != nil {
+		return op.Status, nil
+	}
+	status, errorMessage := result.Status, result.ErrorMessage
+	if result.Properties != nil {
+		status, errorMessage = result.Properties.Status, result.Properties.ErrorMessage
+	}
+	if status == "" {
+		status = op.Status
 	}
 
-	return nil
+	lower := strings.ToLower(status)
+	if op.Done() && (strings.HasPrefix(lower, "failed") || strings.HasPrefix(lower, "cancel")) {
+		if errorMessage == "" {
+			errorMessage = status
+		}
+		return status, errors.New(errorMessage)
+	}
+	return status, nil
 }
 
 // CreateDB Creates DB
 // https://docs.microsoft.com/en-us/rest/api/sql/databases/createorupdate#code-try-0
-func (ah *AzureSQLHelper) CreateDB(importServerName string, databaseName string) error {
-
-	// refresh all the tokens!!!
-	err := ah.refreshToken()
-	if err != nil {
-		return err
-	}
-
+func (ah *AzureSQLHelper) CreateDB(ctx context.Context, importServerName string, databaseName string) error {
 	body := generateCreateDBBody("ah.sqlImportAdminLogin, ah.sqlImportAdminPassword, ah.importStorageKey, storageURI")
-	url := generateCreateDBURL(ah.importSubscriptionID, ah.importSqlRgName, importServerName, databaseName)
-	client := &http.Client{}
-
-	req, err := http.NewRequest("PUT", url, strings.NewReader(body))
-	req.Header.Add("Authorization", "Bearer "+ah.currentToken().AccessToken)
-	req.Header.Add("Content-type", "application/json")
-
-	resp, err := client.Do(req)
+	op, err := ah.arm.Start(ctx, http.MethodPut, ah.createDBURL(ah.importSubscriptionID, ah.importSqlRgName, importServerName, databaseName), body)
 	if err != nil {
-		fmt.Printf("error on put %s\n", err.Error())
-		panic(err)
-	}
-
-	fmt.Printf("status code is %d\n", resp.StatusCode)
-	b, _ := ioutil.ReadAll(resp.Body)
-	fmt.Printf("body is %s\n", string(b))
-
-	// if status begins with 4.... assume failure.
-	if strings.HasPrefix(resp.Status, "4") {
-		return errors.New("unable to start backup")
+		return fmt.Errorf("unable to create database : %w", err)
 	}
 
+	logging.FromContext(ctx).Debugf("create of %s started, status %s", databaseName, op.Status)
 	return nil
 }
 
-func generateCreateDBURL(subscriptionID string, rgName string, serverName string, databaseName string) string {
-	template := "https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/databases/%s?api-version=2017-10-01-preview"
-	url := fmt.Sprintf(template, subscriptionID, rgName, serverName, databaseName)
-	return url
+func (ah *AzureSQLHelper) createDBURL(subscriptionID string, rgName string, serverName string, databaseName string) string {
+	path := ResourcePath("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/databases/%s", subscriptionID, rgName, serverName, databaseName)
+	return ah.arm.URL(path, "2017-10-01-preview", nil)
 }
 
-func generateCreateDBBody(dbSku string) string {
-	template := ` {"location": "southcentralus", "sku": {"name": "%s"}}`
-	body := fmt.Sprintf(template, dbSku)
+func generateCreateDBBody(dbSku string) interface{} {
+	body := map[string]interface{}{
+		"location": "southcentralus",
+		"sku":      map[string]string{"name": dbSku},
+	}
 	return body
 }
 
+func (ah *AzureSQLHelper) firewallRuleURL(subscriptionID string, resourceGroup string, serverName string, firewallRule string) string {
+	path := ResourcePath("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/firewallRules/%s", subscriptionID, resourceGroup, serverName, firewallRule)
+	return ah.arm.URL(path, "2014-04-01", nil)
+}
+
 // UpdateSQLFirewall will update a named firewall rule with a new IP address.
 // https://docs.microsoft.com/en-us/rest/api/sql/firewallrules/createorupdate
-func (ah *AzureSQLHelper) UpdateSQLFirewall(subscriptionID string, serverName string, resourceGroup string, firewallRule string, ip string) error {
-
-	// refresh all the tokens!!!
-	err := ah.refreshToken()
-	if err != nil {
-		return err
-	}
-
-	template := "https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/firewallRules/%s?api-version=2014-04-01"
-	url := fmt.Sprintf(template, subscriptionID, resourceGroup, serverName, firewallRule)
-	body := generateFirewallBody(ip)
-	client := &http.Client{}
-	req, err := http.NewRequest("PUT", url, strings.NewReader(body))
-	req.Header.Add("Authorization", "Bearer "+ah.currentToken().AccessToken)
-	req.Header.Add("Content-type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("error on put %s\n", err.Error())
-		panic(err)
+func (ah *AzureSQLHelper) UpdateSQLFirewall(ctx context.Context, subscriptionID string, serverName string, resourceGroup string, firewallRule string, ip string) error {
+	url := ah.firewallRuleURL(subscriptionID, resourceGroup, serverName, firewallRule)
+	if _, err := ah.arm.Do(ctx, http.MethodPut, url, generateFirewallBody(ip), nil); err != nil {
+		return fmt.Errorf("unable to modify SQL firewall : %w", err)
 	}
-
-	fmt.Printf("status code is %d\n", resp.StatusCode)
-	b, _ := ioutil.ReadAll(resp.Body)
-	fmt.Printf("body is %s\n", string(b))
-
-	// if status begins with 4.... assume failure.
-	if strings.HasPrefix(resp.Status, "4") {
-		return errors.New("unable to modify SQL firewall")
-	}
-
 	return nil
 }
 
-func generateFirewallBody(ip string) string {
-	template := `{"properties": {"endIpAddress": "%s", "startIpAddress": "%s"}}`
-	body := fmt.Sprintf(template, ip, ip)
+func generateFirewallBody(ip string) interface{} {
+	body := map[string]interface{}{
+		"properties": map[string]string{"endIpAddress": ip, "startIpAddress": ip},
+	}
 	return body
 }
 
 // DoesSQLFirewallRuleExist Checks if firewall rule exists.
 // https://docs.microsoft.com/en-us/rest/api/sql/firewallrules/get
-func (ah *AzureSQLHelper) DoesSQLFirewallRuleExist(subscriptionID string, serverName string, resourceGroup string, firewallRule string) bool {
-
-	// refresh all the tokens!!!
-	err := ah.refreshToken()
-	if err != nil {
+func (ah *AzureSQLHelper) DoesSQLFirewallRuleExist(ctx context.Context, subscriptionID string, serverName string, resourceGroup string, firewallRule string) bool {
+	url := ah.firewallRuleURL(subscriptionID, resourceGroup, serverName, firewallRule)
+	if _, err := ah.arm.Do(ctx, http.MethodGet, url, nil, nil); err != nil {
+		if !IsNotFound(err) {
+			logging.FromContext(ctx).Errorf("unable to get firewall rule %s : %s", firewallRule, err.Error())
+		}
 		return false
 	}
 
-	template := "https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Sql/servers/%s/firewallRules/%s?api-version=2014-04-01"
-	url := fmt.Sprintf(template, subscriptionID, resourceGroup, serverName, firewallRule)
-
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
-	req.Header.Add("Authorization", "Bearer "+ah.currentToken().AccessToken)
-	req.Header.Add("Content-type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("error on get %s\n", err.Error())
-		panic(err)
-	}
-
 	// if 200, then rule exists.
-	return resp.StatusCode == http.StatusOK
+	return true
 }
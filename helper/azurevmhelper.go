@@ -1,77 +1,32 @@
 package helper
 
 import (
-	"errors"
-	"fmt"
+	"context"
 	"net/http"
-	"strings"
 )
 
 type AzureVMHelper struct {
-	azureAuth     *AzureAuth
-	clientID      string
-	clientSecret  string
-	tenantID      string
-	resourceGroup string
+	arm            *ARMClient
+	resourceGroup  string
 	subscriptionID string
 }
 
-func NewAzureVMHelper(subscriptionID string,tenantID string, clientID string, clientSecret string, resourceGroup string) *AzureVMHelper {
+func NewAzureVMHelper(arm *ARMClient, subscriptionID string, resourceGroup string) *AzureVMHelper {
 	ah := AzureVMHelper{}
-	ah.azureAuth = NewAzureAuth(tenantID, clientID, clientSecret)
+	ah.arm = arm
 	ah.resourceGroup = resourceGroup
-	ah.tenantID = tenantID
-	ah.clientID = clientID
 	ah.subscriptionID = subscriptionID
-	ah.clientSecret = clientSecret
 	return &ah
 }
 
-func (ah *AzureVMHelper) currentToken() AzureAuthToken {
-	return ah.azureAuth.currentToken
+func (ah *AzureVMHelper) vmStartupURL(subscriptionID string, rgName string, vmName string) string {
+	path := ResourcePath("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s/start", subscriptionID, rgName, vmName)
+	return ah.arm.URL(path, "2020-12-01", nil)
 }
 
-// wrapper around AzureAuth instance.
-func (ah *AzureVMHelper) refreshToken() error {
-	err := ah.azureAuth.RefreshToken()
-	return err
-}
-
-func generateVMStartupURL(subscriptionID string, rgName string, vmName string) string {
-	template := "https://management.azure.com/subscriptions/%s/resourceGroups/%s/providers/Microsoft.Compute/virtualMachines/%s/start?api-version=2020-12-01"
-	url := fmt.Sprintf(template, subscriptionID, rgName, vmName)
-	return url
-}
-
-// StartVM
+// StartVM starts the VM, returning once Azure has accepted the request. Wait on the operation
+// to know it's running.
 // See https://docs.microsoft.com/en-us/rest/api/compute/virtualmachines/start for details
-func (ah *AzureVMHelper) StartVM(vmName string, rgName string) error {
-
-	// refresh all the tokens!!!
-	err := ah.refreshToken()
-	if err != nil {
-		return err
-	}
-
-	url := generateVMStartupURL(ah.subscriptionID,rgName,vmName)
-	client := &http.Client{}
-
-	req, err := http.NewRequest("POST", url, nil)
-	req.Header.Add("Authorization", "Bearer "+ah.currentToken().AccessToken)
-	req.Header.Add("Content-type", "application/json")
-
-	resp, err := client.Do(req)
-	if err != nil {
-		fmt.Printf("error on post %s\n", err.Error())
-		panic(err)
-	}
-
-	fmt.Printf("status code is %d\n", resp.StatusCode)
-
-	// if status begins with 4.... assume failure.
-	if strings.HasPrefix(resp.Status, "4") {
-		return errors.New("unable to start backup")
-	}
-
-	return nil
+func (ah *AzureVMHelper) StartVM(ctx context.Context, vmName string, rgName string) (*Operation, error) {
+	return ah.arm.Start(ctx, http.MethodPost, ah.vmStartupURL(ah.subscriptionID, rgName, vmName), nil)
 }
@@ -0,0 +1,93 @@
+package helper
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/kpfaulkner/wheatley/secrets"
+)
+
+// Cloud is where an Azure cloud's services are. Anything left empty comes from the cloud named,
+// so a single endpoint can be pointed somewhere else, eg a stub server in tests.
+type Cloud struct {
+	Name                string `json:"Name"`                // AzurePublic (default), AzureUSGovernment or AzureChina.
+	ActiveDirectory     string `json:"ActiveDirectory"`     // where tokens come from.
+	ResourceManager     string `json:"ResourceManager"`     // ARM.
+	ManagementResource  string `json:"ManagementResource"`  // what ARM tokens are issued for.
+	KeyVaultResource    string `json:"KeyVaultResource"`    // what Key Vault tokens are issued for.
+	ApplicationInsights string `json:"ApplicationInsights"` // App Insights REST API.
+}
+
+var AzurePublicCloud = Cloud{
+	Name:                "AzurePublic",
+	ActiveDirectory:     DefaultAuthorityHost,
+	ResourceManager:     DefaultManagementEndpoint,
+	ManagementResource:  ManagementResource,
+	KeyVaultResource:    secrets.KeyVaultResource,
+	ApplicationInsights: "https://api.applicationinsights.io",
+}
+
+var AzureUSGovernmentCloud = Cloud{
+	Name:                "AzureUSGovernment",
+	ActiveDirectory:     "https://login.microsoftonline.us",
+	ResourceManager:     "https://management.usgovcloudapi.net",
+	ManagementResource:  "https://management.core.usgovcloudapi.net/",
+	KeyVaultResource:    "https://vault.usgovcloudapi.net",
+	ApplicationInsights: "https://api.applicationinsights.us",
+}
+
+var AzureChinaCloud = Cloud{
+	Name:                "AzureChina",
+	ActiveDirectory:     "https://login.chinacloudapi.cn",
+	ResourceManager:     "https://management.chinacloudapi.cn",
+	ManagementResource:  "https://management.core.chinacloudapi.cn/",
+	KeyVaultResource:    "https://vault.azure.cn",
+	ApplicationInsights: "https://api.applicationinsights.azure.cn",
+}
+
+var clouds = map[string]Cloud{
+	"azurepublic":       AzurePublicCloud,
+	"azureusgovernment": AzureUSGovernmentCloud,
+	"azurechina":        AzureChinaCloud,
+}
+
+// Resolve fills in anything c doesn't set from the cloud it names (AzurePublic if none), and
+// checks every endpoint is a URL.
+func (c Cloud) Resolve() (Cloud, error) {
+	name := c.Name
+	if name == "" {
+		name = AzurePublicCloud.Name
+	}
+	base, ok := clouds[strings.ToLower(name)]
+	if !ok {
+		return c, fmt.Errorf("unknown cloud %q, should be AzurePublic, AzureUSGovernment or AzureChina", c.Name)
+	}
+
+	resolved := base
+	for _, field := range []struct {
+		value string
+		dest  *string
+	}{
+		{c.ActiveDirectory, &resolved.ActiveDirectory},
+		{c.ResourceManager, &resolved.ResourceManager},
+		{c.ManagementResource, &resolved.ManagementResource},
+		{c.KeyVaultResource, &resolved.KeyVaultResource},
+		{c.ApplicationInsights, &resolved.ApplicationInsights},
+	} {
+		if field.value == "" {
+			continue
+		}
+		u, err := url.Parse(field.value)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return c, fmt.Errorf("%q should be a URL, eg https://management.azure.com", field.value)
+		}
+		*field.dest = field.value
+	}
+
+	// endpoints are joined with paths, resources (token audiences) are used as is.
+	resolved.ActiveDirectory = strings.TrimRight(resolved.ActiveDirectory, "/")
+	resolved.ResourceManager = strings.TrimRight(resolved.ResourceManager, "/")
+	resolved.ApplicationInsights = strings.TrimRight(resolved.ApplicationInsights, "/")
+	return resolved, nil
+}
@@ -0,0 +1,112 @@
+package helper
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCloudResolve(t *testing.T) {
+	override := AzureUSGovernmentCloud
+	override.ResourceManager = "http://127.0.0.1:8080"
+	override.ActiveDirectory = "http://127.0.0.1:8081"
+
+	for _, tc := range []struct {
+		name     string
+		cloud    Cloud
+		expected Cloud
+		err      string
+	}{
+		{"default", Cloud{}, AzurePublicCloud, ""},
+		{"public", Cloud{Name: "AzurePublic"}, AzurePublicCloud, ""},
+		{"any case", Cloud{Name: "azureusgovernment"}, AzureUSGovernmentCloud, ""},
+		{"china", Cloud{Name: "AzureChina"}, AzureChinaCloud, ""},
+		{"overrides", Cloud{Name: "AzureUSGovernment", ResourceManager: "http://127.0.0.1:8080/", ActiveDirectory: "http://127.0.0.1:8081"}, override, ""},
+		{"unknown", Cloud{Name: "AzureGermany"}, Cloud{}, "unknown cloud"},
+		{"not a url", Cloud{ResourceManager: "management.azure.com"}, Cloud{}, "should be a URL"},
+		{"not http", Cloud{KeyVaultResource: "ftp://vault.azure.net"}, Cloud{}, "should be a URL"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			resolved, err := tc.cloud.Resolve()
+			if tc.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.err) {
+					t.Fatalf("expected %q, got %v", tc.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resolved != tc.expected {
+				t.Errorf("expected %+v, got %+v", tc.expected, resolved)
+			}
+		})
+	}
+}
+
+// resourceCredential records what tokens were asked for.
+type resourceCredential struct {
+	resources []string
+}
+
+func (c *resourceCredential) ID() string {
+	return "resources"
+}
+
+func (c *resourceCredential) NewToken(ctx context.Context, resource string) (*AzureAuthToken, error) {
+	c.resources = append(c.resources, resource)
+	return &AzureAuthToken{AccessToken: "token", ExpiresOnTime: time.Now().Add(time.Hour)}, nil
+}
+
+func TestARMClientUsesCloud(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{}`)
+	}))
+	defer server.Close()
+
+	cloud, err := Cloud{Name: "AzureChina", ResourceManager: server.URL}.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred := &resourceCredential{}
+	c := NewARMClient(NewCredentialManager().Source(cred), cloud)
+
+	url := c.URL("/subscriptions/sub", "2020-01-01", nil)
+	if !strings.HasPrefix(url, server.URL+"/subscriptions/sub?") {
+		t.Errorf("expected the cloud's ResourceManager, got %s", url)
+	}
+	if _, err := c.Do(context.Background(), http.MethodGet, url, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if len(cred.resources) != 1 || cred.resources[0] != AzureChinaCloud.ManagementResource {
+		t.Errorf("expected a token for %s, got %v", AzureChinaCloud.ManagementResource, cred.resources)
+	}
+}
+
+func TestCredentialUsesCloud(t *testing.T) {
+	var path string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		fmt.Fprint(w, `{"access_token":"token","expires_in":"3600"}`)
+	}))
+	defer server.Close()
+
+	cloud, err := Cloud{ActiveDirectory: server.URL + "/"}.Resolve()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cred, err := AzureCredentialConfig{TenantID: "tenant", ClientID: "client", ClientSecret: "secret"}.NewCredential(cloud, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cred.NewToken(context.Background(), cloud.ManagementResource); err != nil {
+		t.Fatal(err)
+	}
+	if path != "/tenant/oauth2/v2.0/token" {
+		t.Errorf("expected a token from the cloud's Active Directory, got %s", path)
+	}
+}
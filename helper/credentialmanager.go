@@ -0,0 +1,98 @@
+package helper
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/logging"
+	"golang.org/x/sync/singleflight"
+)
+
+// DefaultRefreshWindow is how long before expiry a token is replaced.
+const DefaultRefreshWindow = 5 * time.Minute
+
+// how long getting a token from Azure AD can take.
+const tokenTimeout = 1 * time.Minute
+
+// CredentialManager caches tokens for every credential and resource, and is safe to share
+// between helpers. Tokens are refreshed before they expire, and if lots of requests need a
+// new token at once only one of them asks Azure AD for it.
+type CredentialManager struct {
+	refreshWindow time.Duration
+
+	lock   sync.Mutex
+	tokens map[string]AzureAuthToken // credential ID + resource.
+
+	group singleflight.Group
+}
+
+func NewCredentialManager() *CredentialManager {
+	cm := CredentialManager{}
+	cm.refreshWindow = DefaultRefreshWindow
+	cm.tokens = make(map[string]AzureAuthToken)
+	return &cm
+}
+
+// Token returns an access token for the resource, eg ManagementResource.
+func (cm *CredentialManager) Token(ctx context.Context, cred Credential, resource string) (string, error) {
+	key := cred.ID() + "|" + resource
+
+	cm.lock.Lock()
+	cached, ok := cm.tokens[key]
+	cm.lock.Unlock()
+	if ok && time.Until(cached.ExpiresOnTime) > cm.refreshWindow {
+		return cached.AccessToken, nil
+	}
+
+	results := cm.group.DoChan(key, func() (interface{}, error) {
+		// everyone waiting shares this, so it can't use the ctx of whoever asked first, they
+		// might give up while the others still want the token.
+		fetchCtx, cancel := context.WithTimeout(logging.NewContext(context.Background(), logging.FromContext(ctx)), tokenTimeout)
+		defer cancel()
+
+		token, err := cred.NewToken(fetchCtx, resource)
+		if err != nil {
+			return nil, err
+		}
+
+		logging.FromContext(ctx).Debugf("new token for %s expires %s", resource, token.ExpiresOnTime.UTC())
+		cm.lock.Lock()
+		cm.tokens[key] = *token
+		cm.lock.Unlock()
+		return token.AccessToken, nil
+	})
+
+	var result singleflight.Result
+	select {
+	case result = <-results:
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+
+	v, err := result.Val, result.Err
+	if err != nil {
+		// refreshing early failed, but the old token still has a few minutes left.
+		if ok && time.Now().Before(cached.ExpiresOnTime) {
+			logging.FromContext(ctx).Warnf("unable to refresh token for %s, using the current one : %s", resource, err.Error())
+			return cached.AccessToken, nil
+		}
+		return "", err
+	}
+	return v.(string), nil
+}
+
+// Source binds a credential to the manager, which is what helpers are given.
+func (cm *CredentialManager) Source(cred Credential) *TokenSource {
+	return &TokenSource{manager: cm, cred: cred}
+}
+
+// TokenSource gets (cached) tokens for one credential.
+type TokenSource struct {
+	manager *CredentialManager
+	cred    Credential
+}
+
+func (ts *TokenSource) Token(ctx context.Context, resource string) (string, error) {
+	return ts.manager.Token(ctx, ts.cred, resource)
+}
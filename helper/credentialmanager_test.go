@@ -0,0 +1,97 @@
+package helper
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// slowCredential hands out a token once release is closed, and remembers if the ctx it was
+// given had been cancelled by then.
+type slowCredential struct {
+	calls     int32
+	started   chan struct{}
+	release   chan struct{}
+	cancelled int32
+}
+
+func newSlowCredential() *slowCredential {
+	return &slowCredential{started: make(chan struct{}, 10), release: make(chan struct{})}
+}
+
+func (c *slowCredential) ID() string {
+	return "slow"
+}
+
+func (c *slowCredential) NewToken(ctx context.Context, resource string) (*AzureAuthToken, error) {
+	atomic.AddInt32(&c.calls, 1)
+	c.started <- struct{}{}
+	<-c.release
+	if ctx.Err() != nil {
+		atomic.StoreInt32(&c.cancelled, 1)
+		return nil, ctx.Err()
+	}
+	return &AzureAuthToken{AccessToken: "token", ExpiresOnTime: time.Now().Add(time.Hour)}, nil
+}
+
+func TestCredentialManagerCaches(t *testing.T) {
+	cm := NewCredentialManager()
+	cred := newSlowCredential()
+	close(cred.release)
+
+	for i := 0; i < 3; i++ {
+		token, err := cm.Token(context.Background(), cred, ManagementResource)
+		if err != nil || token != "token" {
+			t.Fatalf("got %q %v", token, err)
+		}
+	}
+	if cred.calls != 1 {
+		t.Errorf("expected one token request, got %d", cred.calls)
+	}
+}
+
+func TestCredentialManagerWaiterGivesUp(t *testing.T) {
+	cm := NewCredentialManager()
+	cred := newSlowCredential()
+
+	// the first caller starts getting the token, then gives up.
+	firstCtx, cancel := context.WithCancel(context.Background())
+	first := make(chan error, 1)
+	go func() {
+		_, err := cm.Token(firstCtx, cred, ManagementResource)
+		first <- err
+	}()
+	<-cred.started
+
+	second := make(chan string, 1)
+	go func() {
+		token, _ := cm.Token(context.Background(), cred, ManagementResource)
+		second <- token
+	}()
+
+	cancel()
+	select {
+	case err := <-first:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("expected the first caller to be cancelled, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("first caller still waiting after its ctx was cancelled")
+	}
+
+	// the token request carries on for the second caller.
+	close(cred.release)
+	select {
+	case token := <-second:
+		if token != "token" {
+			t.Errorf("expected the second caller to get the token, got %q", token)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("second caller never got a token")
+	}
+	if cred.cancelled != 0 || cred.calls != 1 {
+		t.Errorf("expected one uncancelled token request, got %d calls, cancelled %d", cred.calls, cred.cancelled)
+	}
+}
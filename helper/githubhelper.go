@@ -2,15 +2,14 @@ package helper
 
 import (
 	"context"
-	"fmt"
 	"github.com/google/go-github/github"
+	"github.com/kpfaulkner/wheatley/logging"
 	"golang.org/x/oauth2"
 	"strings"
 )
 
 type GithubHelper struct {
 	client *github.Client
-	ctx    context.Context
 	owner  string
 	token  string
 }
@@ -19,7 +18,6 @@ func NewGithubHelper(owner string, apikey string) *GithubHelper {
 	gh := GithubHelper{}
 	gh.owner = owner
 	gh.token = apikey
-	gh.ctx = context.Background()
 	gh.client = getClient(gh.token)
 	return &gh
 }
@@ -37,15 +35,15 @@ func getClient(token string) *github.Client {
 // GetBranchesForRepo get all pages until all branches returned.
 // Should never be THAT many that this would cause an issue.
 // (watch me eat these words)
-func (gh *GithubHelper) GetBranchesForRepo(repo string) ([]*github.Branch, error) {
+func (gh *GithubHelper) GetBranchesForRepo(ctx context.Context, repo string) ([]*github.Branch, error) {
 
 	allBranches := []*github.Branch{}
 	done := false
 	page := 0
 	for !done {
-		branches, _, err := gh.client.Repositories.ListBranches(gh.ctx, gh.owner, repo, &github.ListOptions{Page: page, PerPage: 1000})
+		branches, _, err := gh.client.Repositories.ListBranches(ctx, gh.owner, repo, &github.ListOptions{Page: page, PerPage: 1000})
 		if err != nil {
-			fmt.Printf("error %s\n", err.Error())
+			logging.FromContext(ctx).Errorf("error %s", err.Error())
 			done = true
 			continue
 		}
@@ -62,11 +60,11 @@ func (gh *GithubHelper) GetBranchesForRepo(repo string) ([]*github.Branch, error
 	return allBranches, nil
 }
 
-func (gh *GithubHelper) GetMergeCommentsBetweenCommits(repo string, commit1 string, commit2 string) ([]string, error) {
+func (gh *GithubHelper) GetMergeCommentsBetweenCommits(ctx context.Context, repo string, commit1 string, commit2 string) ([]string, error) {
 
-	cc, _, err := gh.client.Repositories.CompareCommits(gh.ctx, gh.owner, repo, commit1, commit2)
+	cc, _, err := gh.client.Repositories.CompareCommits(ctx, gh.owner, repo, commit1, commit2)
 	if err != nil {
-		fmt.Printf("error %s\n", err.Error())
+		logging.FromContext(ctx).Errorf("error %s", err.Error())
 		return nil, err
 	}
 
@@ -78,18 +76,18 @@ func (gh *GithubHelper) GetMergeCommentsBetweenCommits(repo string, commit1 stri
 	return commentSlice, nil
 }
 
-func (gh *GithubHelper) GetPR(repo string, prID int) (*github.PullRequest, error) {
-	pr, _, err := gh.client.PullRequests.Get(gh.ctx, gh.owner, repo, prID)
+func (gh *GithubHelper) GetPR(ctx context.Context, repo string, prID int) (*github.PullRequest, error) {
+	pr, _, err := gh.client.PullRequests.Get(ctx, gh.owner, repo, prID)
 	if err != nil {
-		fmt.Printf("error %s\n", err.Error())
+		logging.FromContext(ctx).Errorf("error %s", err.Error())
 		return nil, err
 	}
 	return pr, nil
 }
 
-func (gh *GithubHelper) GetIssueState(repo string, issueID int) (string, error) {
+func (gh *GithubHelper) GetIssueState(ctx context.Context, repo string, issueID int) (string, error) {
 
-	issue, err := gh.GetIssue(repo, issueID)
+	issue, err := gh.GetIssue(ctx, repo, issueID)
 	if err != nil {
 		return "", err
 	}
@@ -97,20 +95,20 @@ func (gh *GithubHelper) GetIssueState(repo string, issueID int) (string, error)
 	return issue.GetState(), nil
 }
 
-func (gh *GithubHelper) GetIssue(repo string, issueID int) (*github.Issue, error) {
-	issue, _, err := gh.client.Issues.Get(gh.ctx, gh.owner, repo, issueID)
+func (gh *GithubHelper) GetIssue(ctx context.Context, repo string, issueID int) (*github.Issue, error) {
+	issue, _, err := gh.client.Issues.Get(ctx, gh.owner, repo, issueID)
 	if err != nil {
-		fmt.Printf("error %s\n", err.Error())
+		logging.FromContext(ctx).Errorf("error %s", err.Error())
 		return nil, err
 	}
 	return issue, nil
 }
 
-func (gh *GithubHelper) addLabelToIssue(repo string, id int, label string) error {
+func (gh *GithubHelper) addLabelToIssue(ctx context.Context, repo string, id int, label string) error {
 
-	_, _, err := gh.client.Issues.AddLabelsToIssue(gh.ctx, gh.owner, repo, id, []string{label})
+	_, _, err := gh.client.Issues.AddLabelsToIssue(ctx, gh.owner, repo, id, []string{label})
 	if err != nil {
-		fmt.Printf("addLabelToIssue error %s\n", err.Error())
+		logging.FromContext(ctx).Errorf("addLabelToIssue error %s", err.Error())
 		return err
 	}
 	return nil
@@ -126,10 +124,10 @@ func doesLabelExist(label string, labelList []string) bool {
 	return false
 }
 
-func (gh *GithubHelper) AddLabelToPR(repo string, prID int, label string) error {
-	pr, err := gh.GetPR(repo, prID)
+func (gh *GithubHelper) AddLabelToPR(ctx context.Context, repo string, prID int, label string) error {
+	pr, err := gh.GetPR(ctx, repo, prID)
 	if err != nil {
-		fmt.Printf("error %s\n", err.Error())
+		logging.FromContext(ctx).Errorf("error %s", err.Error())
 		return err
 	}
 
@@ -140,21 +138,21 @@ func (gh *GithubHelper) AddLabelToPR(repo string, prID int, label string) error
 
 	lowerLabel := strings.ToLower(label)
 	if !doesLabelExist(lowerLabel, allLabels) {
-		gh.addLabelToIssue(repo, prID, label)
+		gh.addLabelToIssue(ctx, repo, prID, label)
 	}
 
 	return nil
 }
 
-func (gh *GithubHelper) AddLabelToIssue(repo string, issueID int, label string) error {
-	issue, err := gh.GetIssue(repo, issueID)
+func (gh *GithubHelper) AddLabelToIssue(ctx context.Context, repo string, issueID int, label string) error {
+	issue, err := gh.GetIssue(ctx, repo, issueID)
 	if err != nil {
-		fmt.Printf("error %s\n", err.Error())
+		logging.FromContext(ctx).Errorf("error %s", err.Error())
 		return err
 	}
 
 	state := issue.GetState()
-	fmt.Printf("state is %s\n", state)
+	logging.FromContext(ctx).Debugf("state is %s", state)
 	allLabels := make([]string, len(issue.Labels), len(issue.Labels))
 	for _, l := range issue.Labels {
 		allLabels = append(allLabels, strings.ToLower(*l.Name))
@@ -162,14 +160,14 @@ func (gh *GithubHelper) AddLabelToIssue(repo string, issueID int, label string)
 
 	lowerLabel := strings.ToLower(label)
 	if !doesLabelExist(lowerLabel, allLabels) {
-		gh.addLabelToIssue(repo, issueID, label)
+		gh.addLabelToIssue(ctx, repo, issueID, label)
 	}
 
 	return nil
 }
 
-func (gh *GithubHelper) AddUserToIssue(repo string, issueID int, user string) error {
+func (gh *GithubHelper) AddUserToIssue(ctx context.Context, repo string, issueID int, user string) error {
 
-	gh.client.Issues.AddAssignees(gh.ctx, gh.owner, repo, issueID, []string{user})
+	gh.client.Issues.AddAssignees(ctx, gh.owner, repo, issueID, []string{user})
 	return nil
 }
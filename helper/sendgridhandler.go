@@ -1,16 +1,18 @@
 package helper
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"strings"
+
+	"github.com/sendgrid/rest"
 	"github.com/sendgrid/sendgrid-go"
-	"os"
 )
 
-const (
-	host = "https://api.sendgrid.com"
-)
+// DefaultSendGridHost is SendGrid's API.
+const DefaultSendGridHost = "https://api.sendgrid.com"
 
 // SendgridResult is the result of queries to the Sengrid API.
 type SendgridResult struct {
@@ -27,25 +29,41 @@ type SpamReportResult struct {
 	IP      string `json:"ip"`
 }
 
+// apiWithContext is sendgrid.API, but can be cancelled.
+func apiWithContext(ctx context.Context, request rest.Request) (*rest.Response, error) {
+	req, err := rest.BuildRequestObject(request)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := rest.MakeRequest(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	return rest.BuildResponse(resp)
+}
+
 // SendgridHandler handles all things sendgrid...
 type SendgridHandler struct {
 	ApiKey string
+	host   string
 }
 
-// NewSendgridHandler creates a new instance of SendgridHandler
-func NewSendgridHandler() *SendgridHandler {
+// NewSendgridHandler creates a new instance of SendgridHandler, talking to host (eg DefaultSendGridHost).
+func NewSendgridHandler(apiKey string, host string) *SendgridHandler {
 	sg := SendgridHandler{}
-	sg.ApiKey = os.Getenv("SENDGRID_KEY")
+	sg.ApiKey = apiKey
+	sg.host = strings.TrimRight(host, "/")
 	return &sg
 }
 
 // CheckSpam checks if address has been marked as spammy
-func (sg *SendgridHandler) CheckSpam(email string) ([]*SpamReportResult, error) {
+func (sg *SendgridHandler) CheckSpam(ctx context.Context, email string) ([]*SpamReportResult, error) {
 	url := fmt.Sprintf("/v3/suppression/spam_reports/%s", email)
-	request := sendgrid.GetRequest(sg.ApiKey, url, host)
+	request := sendgrid.GetRequest(sg.ApiKey, url, sg.host)
 
 	request.Method = "GET"
-	response, err := sendgrid.API(request)
+	response, err := apiWithContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -56,12 +74,12 @@ func (sg *SendgridHandler) CheckSpam(email string) ([]*SpamReportResult, error)
 }
 
 // CheckBlock checks if a block has been registered against the email address
-func (sg *SendgridHandler) CheckBlock(email string) ([]*SendgridResult, error) {
+func (sg *SendgridHandler) CheckBlock(ctx context.Context, email string) ([]*SendgridResult, error) {
 	url := fmt.Sprintf("/v3/suppression/blocks/%s", email)
-	request := sendgrid.GetRequest(sg.ApiKey, url, host)
+	request := sendgrid.GetRequest(sg.ApiKey, url, sg.host)
 
 	request.Method = "GET"
-	response, err := sendgrid.API(request)
+	response, err := apiWithContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -72,13 +90,13 @@ func (sg *SendgridHandler) CheckBlock(email string) ([]*SendgridResult, error) {
 }
 
 // CheckBounce checks if a bounce has been registered against the email address
-func (sg *SendgridHandler) CheckBounce(email string) ([]*SendgridResult, error) {
+func (sg *SendgridHandler) CheckBounce(ctx context.Context, email string) ([]*SendgridResult, error) {
 
 	url := fmt.Sprintf("/v3/suppression/bounces/%s", email)
-	request := sendgrid.GetRequest(sg.ApiKey, url, host)
+	request := sendgrid.GetRequest(sg.ApiKey, url, sg.host)
 
 	request.Method = "GET"
-	response, err := sendgrid.API(request)
+	response, err := apiWithContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -89,13 +107,13 @@ func (sg *SendgridHandler) CheckBounce(email string) ([]*SendgridResult, error)
 }
 
 // CheckInvalid checks if an invalid email flag has been registered against the email address
-func (sg *SendgridHandler) CheckInvalid(email string) ([]*SendgridResult, error) {
+func (sg *SendgridHandler) CheckInvalid(ctx context.Context, email string) ([]*SendgridResult, error) {
 
 	url := fmt.Sprintf("/v3/suppression/invalid_emails/%s", email)
-	request := sendgrid.GetRequest(sg.ApiKey, url, host)
+	request := sendgrid.GetRequest(sg.ApiKey, url, sg.host)
 
 	request.Method = "GET"
-	response, err := sendgrid.API(request)
+	response, err := apiWithContext(ctx, request)
 	if err != nil {
 		return nil, err
 	}
@@ -106,14 +124,14 @@ func (sg *SendgridHandler) CheckInvalid(email string) ([]*SendgridResult, error)
 }
 
 // DeleteBounce removes a bounce against an email addr.
-func (sg *SendgridHandler) DeleteBounce(email string) error {
+func (sg *SendgridHandler) DeleteBounce(ctx context.Context, email string) error {
 	url := fmt.Sprintf("/v3/suppression/bounces/%s", email)
-	request := sendgrid.GetRequest(sg.ApiKey, url, host)
+	request := sendgrid.GetRequest(sg.ApiKey, url, sg.host)
 	request.Method = "DELETE"
 	queryParams := make(map[string]string)
 	queryParams["email_address"] = email
 	request.QueryParams = queryParams
-	response, err := sendgrid.API(request)
+	response, err := apiWithContext(ctx, request)
 	if err != nil {
 		return err
 	}
@@ -125,11 +143,11 @@ func (sg *SendgridHandler) DeleteBounce(email string) error {
 }
 
 // DeleteBlock removes a block against an email addr.
-func (sg *SendgridHandler) DeleteBlock(email string) error {
+func (sg *SendgridHandler) DeleteBlock(ctx context.Context, email string) error {
 	url := fmt.Sprintf("/v3/suppression/blocks/%s", email)
-	request := sendgrid.GetRequest(sg.ApiKey, url, host)
+	request := sendgrid.GetRequest(sg.ApiKey, url, sg.host)
 	request.Method = "DELETE"
-	response, err := sendgrid.API(request)
+	response, err := apiWithContext(ctx, request)
 	if err != nil {
 		return err
 	}
@@ -142,14 +160,14 @@ func (sg *SendgridHandler) DeleteBlock(email string) error {
 }
 
 // DeleteSpam removes a spam against an email addr.
-func (sg *SendgridHandler) DeleteSpam(email string) error {
+func (sg *SendgridHandler) DeleteSpam(ctx context.Context, email string) error {
 	url := fmt.Sprintf("/v3/suppression/spam_reports/%s", email)
-	request := sendgrid.GetRequest(sg.ApiKey, url, host)
+	request := sendgrid.GetRequest(sg.ApiKey, url, sg.host)
 	request.Method = "DELETE"
 	queryParams := make(map[string]string)
 	queryParams["email_address"] = email
 	request.QueryParams = queryParams
-	response, err := sendgrid.API(request)
+	response, err := apiWithContext(ctx, request)
 	if err != nil {
 		return err
 	}
@@ -161,14 +179,14 @@ func (sg *SendgridHandler) DeleteSpam(email string) error {
 }
 
 // DeleteInvalid removes an invalid mark against an email addr.
-func (sg *SendgridHandler) DeleteInvalid(email string) error {
+func (sg *SendgridHandler) DeleteInvalid(ctx context.Context, email string) error {
 	url := fmt.Sprintf("/v3/suppression/invalid_emails/%s", email)
-	request := sendgrid.GetRequest(sg.ApiKey, url, host)
+	request := sendgrid.GetRequest(sg.ApiKey, url, sg.host)
 	request.Method = "DELETE"
 	queryParams := make(map[string]string)
 	queryParams["email_address"] = email
 	request.QueryParams = queryParams
-	response, err := sendgrid.API(request)
+	response, err := apiWithContext(ctx, request)
 	if err != nil {
 		return err
 	}
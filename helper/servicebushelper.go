@@ -2,11 +2,9 @@ package helper
 
 import (
 	"context"
-	"fmt"
-	"log"
-	"os"
 
 	servicebus "github.com/Azure/azure-service-bus-go"
+	"github.com/kpfaulkner/wheatley/logging"
 )
 
 type ServiceBusResponse struct {
@@ -22,14 +20,13 @@ type ServiceBusHelper struct {
 }
 
 // NewServiceBusHelper creates a new instance of ServiceBusHelper
-func NewServiceBusHelper() *ServiceBusHelper {
+func NewServiceBusHelper(connectionString string) *ServiceBusHelper {
 	sb := ServiceBusHelper{}
-	sb.ConnectionString = os.Getenv("SERVICEBUS_CONNECTIONSTRING")
+	sb.ConnectionString = connectionString
 
 	ns, err := servicebus.NewNamespace(servicebus.NamespaceWithConnectionString(sb.ConnectionString))
 	if err != nil {
-		// handle error
-		log.Fatal("BOOM")
+		logging.Fatalf("unable to connect to service bus : %s", err.Error())
 	}
 	sb.namespace = ns
 	qMgr := ns.NewQueueManager()
@@ -42,11 +39,11 @@ func NewServiceBusHelper() *ServiceBusHelper {
 }
 
 // CheckQueue checks queue for messages
-func (sb *ServiceBusHelper) CheckQueue(name string) (ServiceBusResponse, error) {
+func (sb *ServiceBusHelper) CheckQueue(ctx context.Context, name string) (ServiceBusResponse, error) {
 
-	l1, err := sb.qMgr.List(context.Background())
+	l1, err := sb.qMgr.List(ctx)
 	if err != nil {
-		log.Fatal("BOOM on queue")
+		return ServiceBusResponse{}, err
 	}
 
 	resp := ServiceBusResponse{}
@@ -64,16 +61,16 @@ func (sb *ServiceBusHelper) CheckQueue(name string) (ServiceBusResponse, error)
 		}
 	}
 
-	fmt.Printf("RES SIZE %d\n", len(resp.AllCountDetails))
+	logging.FromContext(ctx).Debugf("found %d queues", len(resp.AllCountDetails))
 	return resp, nil
 }
 
 // CheckTopic checks queue for messages
-func (sb *ServiceBusHelper) CheckTopic(name string) (ServiceBusResponse, error) {
+func (sb *ServiceBusHelper) CheckTopic(ctx context.Context, name string) (ServiceBusResponse, error) {
 
-	l1, err := sb.tMgr.List(context.Background())
+	l1, err := sb.tMgr.List(ctx)
 	if err != nil {
-		log.Fatal("BOOM on topic")
+		return ServiceBusResponse{}, err
 	}
 
 	resp := ServiceBusResponse{}
@@ -0,0 +1,277 @@
+package logging
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Level is how important a log line is. Lines below the logger's level are dropped.
+type Level int
+
+const (
+	DebugLevel Level = iota
+	InfoLevel
+	WarnLevel
+	ErrorLevel
+)
+
+func (l Level) String() string {
+	switch l {
+	case DebugLevel:
+		return "DEBUG"
+	case InfoLevel:
+		return "INFO"
+	case WarnLevel:
+		return "WARN"
+	case ErrorLevel:
+		return "ERROR"
+	}
+	return fmt.Sprintf("LEVEL(%d)", int(l))
+}
+
+// ParseLevel accepts debug, info, warn (or warning) and error, in any case.
+func ParseLevel(s string) (Level, error) {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "debug":
+		return DebugLevel, nil
+	case "info", "":
+		return InfoLevel, nil
+	case "warn", "warning":
+		return WarnLevel, nil
+	case "error":
+		return ErrorLevel, nil
+	}
+	return InfoLevel, fmt.Errorf("unknown log level %q", s)
+}
+
+// output formats.
+const (
+	TextFormat = "text" // time LEVEL message key=value ...
+	JSONFormat = "json" // one JSON object per line.
+)
+
+type field struct {
+	key   string
+	value interface{}
+}
+
+// Logger writes leveled log lines with a set of fields, eg the event, user and command
+// a line is about. Known secret fields and anything that looks like a token in the message
+// are redacted before anything is written.
+type Logger struct {
+	out    io.Writer
+	level  Level
+	format string
+	fields []field
+
+	// shared with every logger made from this one by With, so lines don't interleave.
+	lock *sync.Mutex
+}
+
+func New(out io.Writer, level Level, format string) *Logger {
+	l := Logger{}
+	l.out = out
+	l.level = level
+	l.format = format
+	l.lock = &sync.Mutex{}
+	return &l
+}
+
+// NewFromEnv uses LOG_LEVEL (default info) and LOG_FORMAT (text or json, default text).
+func NewFromEnv(out io.Writer) (*Logger, error) {
+	level, err := ParseLevel(os.Getenv("LOG_LEVEL"))
+	if err != nil {
+		return nil, err
+	}
+
+	format := strings.ToLower(os.Getenv("LOG_FORMAT"))
+	switch format {
+	case "":
+		format = TextFormat
+	case TextFormat, JSONFormat:
+	default:
+		return nil, fmt.Errorf("unknown log format %q, should be %s or %s", format, TextFormat, JSONFormat)
+	}
+	return New(out, level, format), nil
+}
+
+// With returns a logger that adds the key value pairs to every line.
+func (l *Logger) With(keyvals ...interface{}) *Logger {
+	nl := *l
+	nl.fields = append([]field{}, l.fields...)
+	for i := 0; i < len(keyvals); i += 2 {
+		key := fmt.Sprintf("%v", keyvals[i])
+		var value interface{} = "(missing)"
+		if i+1 < len(keyvals) {
+			value = keyvals[i+1]
+		}
+		nl.fields = append(nl.fields, field{key: key, value: value})
+	}
+	return &nl
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(DebugLevel, format, args...)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(InfoLevel, format, args...)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(WarnLevel, format, args...)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(ErrorLevel, format, args...)
+}
+
+// Fatalf logs at error level and exits.
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(ErrorLevel, format, args...)
+	os.Exit(1)
+}
+
+// Output lets the logger be used by anything expecting a standard library style logger,
+// eg slack.OptionLog. Lines are logged at debug level.
+func (l *Logger) Output(calldepth int, s string) error {
+	l.log(DebugLevel, "%s", strings.TrimRight(s, "\n"))
+	return nil
+}
+
+func (l *Logger) log(level Level, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+
+	msg := Redact(strings.TrimRight(fmt.Sprintf(format, args...), "\n"))
+	now := time.Now().UTC()
+
+	var line string
+	if l.format == JSONFormat {
+		line = l.jsonLine(now, level, msg)
+	} else {
+		line = l.textLine(now, level, msg)
+	}
+
+	l.lock.Lock()
+	defer l.lock.Unlock()
+	io.WriteString(l.out, line+"\n")
+}
+
+func (l *Logger) textLine(now time.Time, level Level, msg string) string {
+	parts := []string{now.Format(time.RFC3339), fmt.Sprintf("%-5s", level.String()), msg}
+	for _, f := range l.fields {
+		parts = append(parts, f.key+"="+quote(fmt.Sprintf("%v", redactField(f.key, f.value))))
+	}
+	return strings.Join(parts, " ")
+}
+
+func (l *Logger) jsonLine(now time.Time, level Level, msg string) string {
+	entry := map[string]interface{}{}
+	for _, f := range l.fields {
+		entry[f.key] = redactField(f.key, f.value)
+	}
+	entry["time"] = now.Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["msg"] = msg
+
+	b, err := marshal(entry)
+	if err != nil {
+		// fields that can't be marshalled get their %v instead.
+		keys := []string{}
+		for k := range entry {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			entry[k] = fmt.Sprintf("%v", entry[k])
+		}
+		b, _ = marshal(entry)
+	}
+	return string(b)
+}
+
+// marshal without escaping &, < and >, which turn up in URLs and messages.
+func marshal(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(v); err != nil {
+		return nil, err
+	}
+	return bytes.TrimRight(buf.Bytes(), "\n"), nil
+}
+
+// quote values with spaces (or that are empty) so the line can still be split on spaces.
+func quote(s string) string {
+	if s == "" || strings.ContainsAny(s, " \t\n\"=") {
+		return fmt.Sprintf("%q", s)
+	}
+	return s
+}
+
+var defaultLogger = New(os.Stdout, InfoLevel, TextFormat)
+
+// Default is the logger used when there isn't one in the context.
+func Default() *Logger {
+	return defaultLogger
+}
+
+// SetDefault replaces the default logger. Should be called once, at startup.
+func SetDefault(l *Logger) {
+	defaultLogger = l
+}
+
+type contextKey struct{}
+
+// NewContext returns a context carrying the logger.
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, contextKey{}, l)
+}
+
+// FromContext returns the logger in the context, or the default logger if there isn't one.
+func FromContext(ctx context.Context) *Logger {
+	if ctx != nil {
+		if l, ok := ctx.Value(contextKey{}).(*Logger); ok {
+			return l
+		}
+	}
+	return defaultLogger
+}
+
+// With adds the key value pairs to the logger in the context.
+func With(ctx context.Context, keyvals ...interface{}) context.Context {
+	return NewContext(ctx, FromContext(ctx).With(keyvals...))
+}
+
+// for code that doesn't have a context, eg loading config at startup.
+
+func Debugf(format string, args ...interface{}) {
+	defaultLogger.log(DebugLevel, format, args...)
+}
+
+func Infof(format string, args ...interface{}) {
+	defaultLogger.log(InfoLevel, format, args...)
+}
+
+func Warnf(format string, args ...interface{}) {
+	defaultLogger.log(WarnLevel, format, args...)
+}
+
+func Errorf(format string, args ...interface{}) {
+	defaultLogger.log(ErrorLevel, format, args...)
+}
+
+func Fatalf(format string, args ...interface{}) {
+	defaultLogger.log(ErrorLevel, format, args...)
+	os.Exit(1)
+}
@@ -0,0 +1,65 @@
+package logging
+
+import (
+	"regexp"
+	"strings"
+)
+
+const redacted = "[REDACTED]"
+
+// field names containing any of these are never logged.
+var secretFieldNames = []string{"token", "secret", "password", "passwd", "authorization", "signature", "apikey", "api_key", "api-key", "connectionstring"}
+
+// isSecretField is true for field names like access_token, ClientSecret or StorageKey.
+func isSecretField(name string) bool {
+	name = strings.ToLower(name)
+	for _, s := range secretFieldNames {
+		if strings.Contains(name, s) {
+			return true
+		}
+	}
+	return strings.HasSuffix(name, "key")
+}
+
+func redactField(name string, value interface{}) interface{} {
+	if isSecretField(name) {
+		return redacted
+	}
+	if s, ok := value.(string); ok {
+		return Redact(s)
+	}
+	return value
+}
+
+// patterns for secrets that turn up in messages, mostly from printing HTTP responses and URLs.
+var secretPatterns = []struct {
+	pattern     *regexp.Regexp
+	replacement string
+}{
+	// JSON, eg the OAuth token response.
+	{regexp.MustCompile(`(?i)("[\w-]*(?:token|secret|password|key)"\s*:\s*)"[^"]*"`), `$1"` + redacted + `"`},
+
+	// query strings and form bodies, including SAS signatures. Stops at ; so connection strings keep their other settings.
+	{regexp.MustCompile(`(?i)\b((?:[\w-]*token|client_secret|password|sig|code|[\w-]*key)=)[^&;\s"]+`), `${1}` + redacted},
+
+	// Authorization headers.
+	{regexp.MustCompile(`(?i)\b(bearer|basic)\s+[\w\-.~+/]+=*`), `$1 ` + redacted},
+
+	// Slack tokens.
+	{regexp.MustCompile(`\bxox[abposr]-[\w-]+`), redacted},
+	{regexp.MustCompile(`\bxapp-[\w-]+`), redacted},
+
+	// SendGrid API keys.
+	{regexp.MustCompile(`\bSG\.[\w-]+\.[\w-]+`), redacted},
+
+	// connection strings, eg service bus and storage.
+	{regexp.MustCompile(`(?i)((?:AccountKey|SharedAccessKey|Password)=)[^;\s"]+`), `${1}` + redacted},
+}
+
+// Redact replaces anything in s that looks like a secret.
+func Redact(s string) string {
+	for _, sp := range secretPatterns {
+		s = sp.pattern.ReplaceAllString(s, sp.replacement)
+	}
+	return s
+}
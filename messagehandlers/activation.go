@@ -0,0 +1,71 @@
+package messagehandlers
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/kpfaulkner/wheatley/config"
+)
+
+// ActivationPolicy works out if a message is for Wheatley, and strips off the mention or prefix
+// so the handlers only see the command itself.
+type ActivationPolicy struct {
+	config       config.ActivationConfig
+	mentionRegex *regexp.Regexp
+}
+
+// NewActivationPolicy needs the bot's own user ID to spot mentions.
+func NewActivationPolicy(activation config.ActivationConfig, botUserID string) *ActivationPolicy {
+	p := ActivationPolicy{}
+	p.config = activation
+	p.mentionRegex = regexp.MustCompile(`<@` + regexp.QuoteMeta(botUserID) + `(\|[^>]*)?>[\s:,]*`)
+	return &p
+}
+
+func (p *ActivationPolicy) modeForChannel(channel string) string {
+	if mode, ok := p.config.ChannelOverrides[channel]; ok {
+		return strings.ToLower(mode)
+	}
+	return strings.ToLower(p.config.Mode)
+}
+
+// Activate returns the text the handlers should see, and false if Wheatley should stay quiet.
+func (p *ActivationPolicy) Activate(msg IncomingMessage) (string, bool) {
+	text, addressed := p.stripAddress(msg.Text)
+	if addressed || msg.Mentioned {
+		return text, true
+	}
+
+	if p.config.AllowDMs && msg.IsDM() {
+		return text, true
+	}
+
+	if p.modeForChannel(msg.Channel) == config.ActivationAll {
+		return text, true
+	}
+
+	return "", false
+}
+
+// stripAddress removes our mentions, wherever they are (eg "check prod @wheatley"), or a leading
+// prefix. Returns true if there was one.
+func (p *ActivationPolicy) stripAddress(text string) (string, bool) {
+	if p.mentionRegex.MatchString(text) {
+		return strings.TrimSpace(p.mentionRegex.ReplaceAllString(text, "")), true
+	}
+
+	trimmed := strings.TrimSpace(text)
+	for _, prefix := range p.config.Prefixes {
+		if len(trimmed) < len(prefix) || !strings.EqualFold(trimmed[:len(prefix)], prefix) {
+			continue
+		}
+
+		// whole word only, "wheatleys" isn't us.
+		rest := trimmed[len(prefix):]
+		if rest == "" || strings.ContainsAny(rest[:1], " \t:,") {
+			return strings.TrimLeft(rest, " \t:,"), true
+		}
+	}
+
+	return strings.TrimSpace(text), false
+}
@@ -0,0 +1,39 @@
+package messagehandlers
+
+import (
+	"testing"
+
+	"github.com/kpfaulkner/wheatley/config"
+)
+
+func TestActivate(t *testing.T) {
+	activation := config.DefaultActivationConfig()
+	activation.ChannelOverrides["C0OPS0000"] = config.ActivationAll
+	p := NewActivationPolicy(activation, "U0BOT0000")
+
+	for _, tc := range []struct {
+		name     string
+		msg      IncomingMessage
+		text     string
+		activate bool
+	}{
+		{"leading mention", IncomingMessage{Channel: "C012AB3CD", Text: "<@U0BOT0000> check prod"}, "check prod", true},
+		{"mention with name and colon", IncomingMessage{Channel: "C012AB3CD", Text: "  <@U0BOT0000|wheatley>: check prod"}, "check prod", true},
+		{"trailing mention", IncomingMessage{Channel: "C012AB3CD", Text: "check prod <@U0BOT0000>"}, "check prod", true},
+		{"mention in the middle", IncomingMessage{Channel: "C012AB3CD", Text: "check <@U0BOT0000>, prod"}, "check prod", true},
+		{"app mention", IncomingMessage{Channel: "C012AB3CD", Text: "check prod", Mentioned: true}, "check prod", true},
+		{"someone else mentioned", IncomingMessage{Channel: "C012AB3CD", Text: "<@U0OTHER00> check prod"}, "", false},
+		{"prefix", IncomingMessage{Channel: "C012AB3CD", Text: "Wheatley, check prod"}, "check prod", true},
+		{"prefix is a whole word", IncomingMessage{Channel: "C012AB3CD", Text: "wheatleys check prod"}, "", false},
+		{"not addressed", IncomingMessage{Channel: "C012AB3CD", Text: "check prod"}, "", false},
+		{"dm", IncomingMessage{Channel: "D012AB3CD", Text: "check prod"}, "check prod", true},
+		{"channel override", IncomingMessage{Channel: "C0OPS0000", Text: "check prod"}, "check prod", true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			text, ok := p.Activate(tc.msg)
+			if ok != tc.activate || text != tc.text {
+				t.Errorf("expected %q %t, got %q %t", tc.text, tc.activate, text, ok)
+			}
+		})
+	}
+}
@@ -0,0 +1,273 @@
+package messagehandlers
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"github.com/kpfaulkner/wheatley/logging"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// action IDs for the approval buttons.
+	ApproveActionID = "approval_approve"
+	DenyActionID    = "approval_deny"
+
+	DefaultApprovalTimeout = 30 * time.Minute
+
+	// approving runs the command, so give it as long as the command itself could need.
+	approveCommandTimeout = 15 * time.Minute
+)
+
+// approval events, recorded in the audit log as "approval <event>".
+const (
+	ApprovalRequested = "requested"
+	ApprovalApproved  = "approved"
+	ApprovalDenied    = "denied"
+	ApprovalExpired   = "expired"
+	ApprovalFailed    = "failed"
+	ApprovalCompleted = "completed"
+)
+
+// PendingApproval is a destructive command waiting for someone else to approve it.
+type PendingApproval struct {
+	ID      string
+	Handler string
+	Command *Command
+	Request Request
+	Created time.Time
+	Expires time.Time
+
+	timer *time.Timer
+}
+
+// ApprovalManager holds commands that need a second person to approve them before they run.
+// Anyone the policy allows to run the command, other than whoever asked, can approve it.
+// It's also an InteractiveHandler for the approve and deny commands and buttons.
+type ApprovalManager struct {
+	policy  *Policy
+	timeout time.Duration
+	audit   AuditSink
+
+	lock    sync.Mutex
+	pending map[string]*PendingApproval
+}
+
+// NewApprovalManager creates the manager. Requests, approvals, denials and expiries are recorded in
+// audit, alongside the commands themselves. If it's nil they're just logged.
+func NewApprovalManager(policy *Policy, timeout time.Duration, audit AuditSink) *ApprovalManager {
+	am := ApprovalManager{}
+	am.policy = policy
+	am.timeout = timeout
+	am.audit = audit
+	am.pending = make(map[string]*PendingApproval)
+	return &am
+}
+
+// Request creates a pending approval for the command and returns the message asking for it.
+func (am *ApprovalManager) Request(command *Command, req Request) MessageResponse {
+	id, err := newApprovalID()
+	if err != nil {
+		return NewTextMessageResponse(fmt.Sprintf("unable to create approval : %s", err.Error()))
+	}
+
+	pa := PendingApproval{
+		ID:      id,
+		Handler: command.handler,
+		Command: command,
+		Request: req,
+		Created: time.Now(),
+		Expires: time.Now().Add(am.timeout),
+	}
+
+	am.lock.Lock()
+	am.pending[id] = &pa
+	pa.timer = time.AfterFunc(am.timeout, func() { am.expire(id) })
+	am.lock.Unlock()
+
+	am.record(&pa, ApprovalRequested, req.User, "")
+
+	summary := fmt.Sprintf("%s wants to run `%s`", mention(req.User), describeCommand(command, req.Args))
+	resp := NewBlocksMessageResponse(fmt.Sprintf("%s. Needs approval, reply approve %s or deny %s", summary, id, id))
+	resp.AddSection(fmt.Sprintf("%s. Someone else needs to approve it first.", summary))
+	resp.AddButtons("approval_"+id,
+		Button{Text: "Approve", ActionID: ApproveActionID, Value: id, Style: "primary"},
+		Button{Text: "Deny", ActionID: DenyActionID, Value: id, Style: "danger"},
+	)
+	resp.AddContext(fmt.Sprintf("Request %s expires at %s. Can also reply `approve %s` or `deny %s`", id, pa.Expires.Format("15:04 MST"), id, id))
+	return resp
+}
+
+// Approve runs the pending command, as long as the approver isn't whoever asked for it and is
+// allowed to run the command themselves.
+func (am *ApprovalManager) Approve(ctx context.Context, id string, approver User) (MessageResponse, error) {
+	pa, err := am.take(id, approver, true)
+	if err != nil {
+		return NewTextMessageResponse(err.Error()), nil
+	}
+	am.record(pa, ApprovalApproved, approver, "")
+
+	req := pa.Request
+	req.Approver = &approver
+	resp, err := pa.Command.Execute(ctx, &req)
+	if err != nil {
+		am.record(pa, ApprovalFailed, approver, err.Error())
+		return nil, err
+	}
+	am.record(pa, ApprovalCompleted, approver, "")
+	return resp, nil
+}
+
+// Deny cancels the pending command. Whoever asked for it can also deny it.
+func (am *ApprovalManager) Deny(id string, denier User) (MessageResponse, error) {
+	pa, err := am.take(id, denier, false)
+	if err != nil {
+		return NewTextMessageResponse(err.Error()), nil
+	}
+	am.record(pa, ApprovalDenied, denier, "")
+	return NewTextMessageResponse(fmt.Sprintf("%s denied `%s` for %s", mention(denier), describeCommand(pa.Command, pa.Request.Args), mention(pa.Request.User))), nil
+}
+
+// take removes the pending approval if the user is allowed to approve (or deny) it.
+func (am *ApprovalManager) take(id string, user User, approving bool) (*PendingApproval, error) {
+	am.lock.Lock()
+	defer am.lock.Unlock()
+
+	pa, ok := am.pending[id]
+	if !ok {
+		return nil, fmt.Errorf("no pending request %s, it may have expired or already been dealt with", id)
+	}
+
+	isRequester := pa.Request.User.ID == user.ID
+	if approving && isRequester {
+		return nil, fmt.Errorf("you can't approve your own request, someone else has to")
+	}
+
+	if !isRequester && am.policy != nil {
+		if ok, roles := am.policy.Allowed(pa.Handler, pa.Command, user); !ok {
+			logging.Warnf("denied %s (%s) approving %s, needs one of %s", user.ID, user.Name, id, strings.Join(roles, ", "))
+			return nil, PermissionDeniedError{Command: pa.Command.Usage(), Roles: roles}
+		}
+	}
+
+	pa.timer.Stop()
+	delete(am.pending, id)
+	return pa, nil
+}
+
+func (am *ApprovalManager) expire(id string) {
+	am.lock.Lock()
+	pa, ok := am.pending[id]
+	delete(am.pending, id)
+	am.lock.Unlock()
+
+	if ok {
+		am.record(pa, ApprovalExpired, User{}, "")
+	}
+}
+
+// record adds the event to the audit log. actor is whoever approved or denied it, and is empty
+// when it expires.
+func (am *ApprovalManager) record(pa *PendingApproval, event string, actor User, detail string) {
+	entry := newAuditEntry(actor, pa.Request.Channel, pa.Request.Transport, describeCommand(pa.Command, pa.Request.Args))
+	entry.Approval = pa.ID
+	entry.Requester = pa.Request.User.ID
+	entry.Handler = pa.Handler
+	entry.Command = pa.Command.Usage()
+	entry.Args = formatArgs(pa.Request.Args)
+	entry.Decision = "approval " + event
+	switch event {
+	case ApprovalCompleted:
+		entry.Result = ResultOK
+	case ApprovalFailed:
+		entry.Result = ResultError
+		entry.Error = detail
+	}
+
+	if am.audit == nil {
+		logging.Infof("approval %s %s %s by %s : %s", pa.ID, event, entry.Command, actor.ID, entry.Args)
+		return
+	}
+	if err := am.audit.Record(entry); err != nil {
+		logging.Errorf("unable to record approval %s %s : %s", pa.ID, event, err.Error())
+	}
+}
+
+func (am *ApprovalManager) Name() string {
+	return "ApprovalManager"
+}
+
+func (am *ApprovalManager) Commands() []*Command {
+	approve := NewContextCommand("approve <id:word>", "approves a pending request", func(ctx context.Context, req *Request) (MessageResponse, error) {
+		return am.Approve(ctx, req.Args.String("id"), req.User)
+	})
+	approve.Timeout = approveCommandTimeout
+
+	deny := NewContextCommand("deny <id:word>", "denies a pending request", func(ctx context.Context, req *Request) (MessageResponse, error) {
+		return am.Deny(req.Args.String("id"), req.User)
+	})
+	return []*Command{approve, deny}
+}
+
+// Actions are the Approve and Deny buttons on the approval request. The button value is the request ID.
+func (am *ApprovalManager) Actions() []*Action {
+	approve := NewAction(ApproveActionID, func(ctx context.Context, req *Request) (MessageResponse, error) {
+		return am.Approve(ctx, req.Args.String("value"), req.User)
+	})
+	approve.Timeout = approveCommandTimeout
+
+	deny := NewAction(DenyActionID, func(ctx context.Context, req *Request) (MessageResponse, error) {
+		return am.Deny(req.Args.String("value"), req.User)
+	})
+	return []*Action{approve, deny}
+}
+
+func newApprovalID() (string, error) {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// describeCommand shows the command with the arguments filled in.
+func describeCommand(command *Command, args CommandArgs) string {
+	parts := []string{}
+	for _, t := range command.spec {
+		if t.literal != "" {
+			parts = append(parts, t.literal)
+			continue
+		}
+		if v, ok := args[t.name]; ok {
+			parts = append(parts, formatArg(v))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+func formatArgs(args CommandArgs) string {
+	parts := []string{}
+	for k, v := range args {
+		parts = append(parts, fmt.Sprintf("%s=%s", k, formatArg(v)))
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, " ")
+}
+
+func formatArg(v interface{}) string {
+	if t, ok := v.(time.Time); ok {
+		return t.Format("2006-01-02")
+	}
+	return fmt.Sprintf("%v", v)
+}
+
+func mention(u User) string {
+	if u.ID == "" {
+		return u.Name
+	}
+	return "<@" + u.ID + ">"
+}
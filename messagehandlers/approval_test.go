@@ -0,0 +1,201 @@
+package messagehandlers
+
+import (
+	"context"
+	"encoding/json"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/config"
+)
+
+// memoryAuditSink keeps entries in memory.
+type memoryAuditSink struct {
+	lock    sync.Mutex
+	entries []AuditEntry
+}
+
+func (s *memoryAuditSink) Record(entry AuditEntry) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.entries = append(s.entries, entry)
+	return nil
+}
+
+func (s *memoryAuditSink) Last(n int) ([]AuditEntry, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if n > len(s.entries) {
+		n = len(s.entries)
+	}
+	return append([]AuditEntry{}, s.entries[len(s.entries)-n:]...), nil
+}
+
+// decisions is the decision of every entry, in order.
+func (s *memoryAuditSink) decisions() []string {
+	entries, _ := s.Last(1000)
+	decisions := []string{}
+	for _, e := range entries {
+		decisions = append(decisions, e.Decision)
+	}
+	return decisions
+}
+
+// approvalTest is an ApprovalManager with a single destructive command, which records who approved it.
+type approvalTest struct {
+	am       *ApprovalManager
+	audit    *memoryAuditSink
+	command  *Command
+	approver *User
+	runs     int
+}
+
+func newApprovalTest(timeout time.Duration) *approvalTest {
+	at := approvalTest{audit: &memoryAuditSink{}}
+	policy := NewPolicy(config.PolicyConfig{
+		Roles:         map[string][]string{"admin": {"U0ADMIN00", "U0ADMIN01"}},
+		MutatingRoles: []string{"admin"},
+	})
+	at.am = NewApprovalManager(policy, timeout, at.audit)
+
+	at.command = NewContextCommand("shutdown <env:word>", "", func(ctx context.Context, req *Request) (MessageResponse, error) {
+		at.runs++
+		at.approver = req.Approver
+		return NewTextMessageResponse("shutting down " + req.Args.String("env")), nil
+	})
+	at.command.Mutating = true
+	at.command.RequiresApproval = true
+	at.command.handler = "AzureShutdownMessageHandler"
+	return &at
+}
+
+// request asks for approval as the user and returns the approval ID.
+func (at *approvalTest) request(t *testing.T, user User) string {
+	t.Helper()
+	at.am.Request(at.command, Request{Args: CommandArgs{"env": "prod"}, User: user, Channel: "C012AB3CD"})
+
+	at.am.lock.Lock()
+	defer at.am.lock.Unlock()
+	for id := range at.am.pending {
+		return id
+	}
+	t.Fatal("nothing pending")
+	return ""
+}
+
+func text(t *testing.T, resp MessageResponse) string {
+	t.Helper()
+	tm, ok := resp.(TextMessageResponse)
+	if !ok {
+		t.Fatalf("expected a text response, got %T", resp)
+	}
+	return tm.Message
+}
+
+func TestApproval(t *testing.T) {
+	at := newApprovalTest(time.Hour)
+	requester := User{ID: "U0ADMIN00"}
+	id := at.request(t, requester)
+
+	// asking for it isn't enough, even for an admin.
+	resp, err := at.am.Approve(context.Background(), id, requester)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text(t, resp), "can't approve your own request") || at.runs != 0 {
+		t.Errorf("expected self approval to be rejected, got %q and %d run(s)", text(t, resp), at.runs)
+	}
+
+	// nor is being someone else without the role.
+	resp, _ = at.am.Approve(context.Background(), id, User{ID: "U012AB3CD"})
+	if !strings.Contains(text(t, resp), "admin") || at.runs != 0 {
+		t.Errorf("expected approval without the role to be rejected, got %q", text(t, resp))
+	}
+
+	resp, err = at.am.Approve(context.Background(), id, User{ID: "U0ADMIN01"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text(t, resp) != "shutting down prod" || at.runs != 1 || at.approver == nil || at.approver.ID != "U0ADMIN01" {
+		t.Errorf("expected the command to run with the approver, got %q %d %v", text(t, resp), at.runs, at.approver)
+	}
+
+	// it's gone once approved.
+	resp, _ = at.am.Approve(context.Background(), id, User{ID: "U0ADMIN01"})
+	if !strings.Contains(text(t, resp), "no pending request") || at.runs != 1 {
+		t.Errorf("expected a second approval to do nothing, got %q", text(t, resp))
+	}
+
+	expected := "approval requested,approval approved,approval completed"
+	if strings.Join(at.audit.decisions(), ",") != expected {
+		t.Fatalf("expected %s in the audit log, got %v", expected, at.audit.decisions())
+	}
+	for i, e := range at.audit.entries {
+		if e.Approval != id || e.Requester != "U0ADMIN00" || e.Channel != "C012AB3CD" || e.Text != "shutdown prod" || e.Handler != "AzureShutdownMessageHandler" {
+			t.Errorf("unexpected entry %d %+v", i, e)
+		}
+	}
+	if at.audit.entries[0].User != "U0ADMIN00" || at.audit.entries[1].User != "U0ADMIN01" || at.audit.entries[2].Result != ResultOK {
+		t.Errorf("expected the requester then the approver, got %+v", at.audit.entries)
+	}
+}
+
+func TestApprovalAuditLast(t *testing.T) {
+	at := newApprovalTest(time.Hour)
+	id := at.request(t, User{ID: "U0ADMIN00", Name: "alice"})
+	at.am.Deny(id, User{ID: "U0ADMIN01", Name: "bob"})
+
+	// they show up in audit last along with everything else.
+	ah := NewAuditHandler(at.audit)
+	resp, err := ah.last(context.Background(), &Request{Args: CommandArgs{"n": 10}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := json.Marshal(resp.(BlocksMessageResponse).Blocks)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := string(b)
+	for _, s := range []string{"alice", "approval requested", "bob", "approval denied", "shutdown prod"} {
+		if !strings.Contains(body, s) {
+			t.Errorf("expected %q in %s", s, body)
+		}
+	}
+}
+
+func TestApprovalDeny(t *testing.T) {
+	at := newApprovalTest(time.Hour)
+	id := at.request(t, User{ID: "U0ADMIN00"})
+
+	// whoever asked can change their mind.
+	resp, err := at.am.Deny(id, User{ID: "U0ADMIN00"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(text(t, resp), "denied") {
+		t.Errorf("unexpected response %q", text(t, resp))
+	}
+
+	resp, _ = at.am.Approve(context.Background(), id, User{ID: "U0ADMIN01"})
+	if !strings.Contains(text(t, resp), "no pending request") || at.runs != 0 {
+		t.Errorf("expected a denied request not to run, got %q", text(t, resp))
+	}
+}
+
+func TestApprovalExpiry(t *testing.T) {
+	at := newApprovalTest(20 * time.Millisecond)
+	id := at.request(t, User{ID: "U0ADMIN00"})
+	time.Sleep(100 * time.Millisecond)
+
+	resp, _ := at.am.Approve(context.Background(), id, User{ID: "U0ADMIN01"})
+	if !strings.Contains(text(t, resp), "no pending request") || at.runs != 0 {
+		t.Errorf("expected an expired request not to run, got %q", text(t, resp))
+	}
+
+	decisions := at.audit.decisions()
+	if len(decisions) != 2 || decisions[1] != "approval "+ApprovalExpired {
+		t.Errorf("expected the expiry to be audited, got %v", decisions)
+	}
+}
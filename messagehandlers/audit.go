@@ -0,0 +1,219 @@
+package messagehandlers
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/kpfaulkner/wheatley/logging"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// results recorded in the audit log.
+const (
+	ResultOK    = "ok"
+	ResultError = "error"
+)
+
+// AuditEntry is a single command (or button click) someone ran, or tried to.
+type AuditEntry struct {
+	Time      time.Time `json:"time"`
+	User      string    `json:"user"` // Slack user ID.
+	UserName  string    `json:"userName"`
+	Channel   string    `json:"channel"`
+	Transport string    `json:"transport"`
+	Text      string    `json:"text"` // raw message, the action ID for buttons and modals or the command for approvals.
+
+	Handler  string `json:"handler,omitempty"`
+	Command  string `json:"command,omitempty"`
+	Args     string `json:"args,omitempty"`
+	Decision string `json:"decision"` // one of the Decision constants, or "approval <event>".
+
+	// only set for approval events, User is whoever approved or denied it.
+	Approval  string `json:"approval,omitempty"` // approval ID.
+	Requester string `json:"requester,omitempty"`
+
+	// only set if the command ran.
+	DurationMS int64  `json:"durationMS,omitempty"`
+	Result     string `json:"result,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// AuditSink stores audit entries. Entries are never changed or removed once recorded.
+type AuditSink interface {
+	Record(entry AuditEntry) error
+
+	// Last returns up to the last n entries, oldest first.
+	Last(n int) ([]AuditEntry, error)
+}
+
+// JSONLAuditSink appends entries to a file, one JSON object per line.
+type JSONLAuditSink struct {
+	fileName string
+
+	lock sync.Mutex
+	file *os.File
+}
+
+// NewJSONLAuditSink opens (or creates) the file for appending.
+func NewJSONLAuditSink(fileName string) (*JSONLAuditSink, error) {
+	f, err := os.OpenFile(fileName, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	s := JSONLAuditSink{}
+	s.fileName = fileName
+	s.file = f
+	return &s, nil
+}
+
+func (s *JSONLAuditSink) Record(entry AuditEntry) error {
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	_, err = s.file.Write(append(b, '\n'))
+	return err
+}
+
+// Last reads through the whole file, keeping the last n lines.
+func (s *JSONLAuditSink) Last(n int) ([]AuditEntry, error) {
+	f, err := os.Open(s.fileName)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	lines := []string{}
+	scanner := bufio.NewScanner(f)
+
+	// raw message text can be up to 40000 characters.
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+		if len(lines) > n {
+			lines = lines[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	entries := []AuditEntry{}
+	for _, line := range lines {
+		var entry AuditEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func (s *JSONLAuditSink) Close() error {
+	return s.file.Close()
+}
+
+// newAuditEntry fills in who, where and what.
+func newAuditEntry(u User, channel string, transport string, text string) AuditEntry {
+	return AuditEntry{
+		Time:      time.Now().UTC(),
+		User:      u.ID,
+		UserName:  u.Name,
+		Channel:   channel,
+		Transport: transport,
+		Text:      text,
+	}
+}
+
+// resolved records what the router decided.
+func (e *AuditEntry) resolved(res Resolution) {
+	e.Decision = res.Decision
+	e.Args = formatArgs(res.Args)
+	if res.Command != nil {
+		e.Handler = res.Command.handler
+		e.Command = res.Command.Usage()
+	}
+}
+
+// finished records how the command went.
+func (e *AuditEntry) finished(start time.Time, err error) {
+	e.DurationMS = time.Since(start).Milliseconds()
+	e.Result = ResultOK
+	if err != nil {
+		e.Result = ResultError
+		e.Error = err.Error()
+	}
+}
+
+// AuditHandler lets admins see the audit log from Slack.
+type AuditHandler struct {
+	sink AuditSink
+}
+
+func NewAuditHandler(sink AuditSink) *AuditHandler {
+	ah := AuditHandler{}
+	ah.sink = sink
+	return &ah
+}
+
+func (ah *AuditHandler) Name() string {
+	return "AuditHandler"
+}
+
+func (ah *AuditHandler) Commands() []*Command {
+	last := NewContextCommand("audit last <n:int(1..100)>", "shows the last n commands run, admins only", ah.last)
+	last.Sensitive = true
+	last.Ephemeral = true
+	return []*Command{last}
+}
+
+func (ah *AuditHandler) last(ctx context.Context, req *Request) (MessageResponse, error) {
+	entries, err := ah.sink.Last(req.Args.Int("n"))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("unable to read audit log : %s", err.Error())
+		return NewTextMessageResponse("Unable to read the audit log."), nil
+	}
+	if len(entries) == 0 {
+		return NewTextMessageResponse("Nothing in the audit log yet."), nil
+	}
+
+	rows := [][]string{}
+	for _, e := range entries {
+		// approval events say what happened to the approval, even once the command has run.
+		outcome := e.Decision
+		if e.Result != "" && e.Approval == "" {
+			outcome = e.Result
+		}
+		rows = append(rows, []string{
+			e.Time.Format("2006-01-02 15:04:05"),
+			e.UserName,
+			e.Channel,
+			truncate(e.Text, 40),
+			outcome,
+			(time.Duration(e.DurationMS) * time.Millisecond).String(),
+		})
+	}
+
+	resp := NewBlocksMessageResponse(fmt.Sprintf("Last %d commands", len(entries)))
+	resp.AddHeader(fmt.Sprintf("Last %d commands", len(entries)))
+	resp.AddTable([]string{"Time (UTC)", "User", "Channel", "Text", "Outcome", "Took"}, rows)
+	return resp, nil
+}
+
+// truncate shortens s to at most max characters, on a single line.
+func truncate(s string, max int) string {
+	s = strings.Join(strings.Fields(s), " ")
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max-3]) + "..."
+}
@@ -0,0 +1,48 @@
+package messagehandlers
+
+import (
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		s        string
+		expected string
+	}{
+		{"check prod", "check prod"},
+		{"check\n  prod", "check prod"},
+		{"report azurecosts from", "report azu..."},
+		{"déploiement à prod", "déploiemen..."},
+		{"🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀", "🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀"},
+		{"🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀", "🚀🚀🚀🚀🚀🚀🚀🚀🚀🚀..."},
+	} {
+		got := truncate(tc.s, 13)
+		if got != tc.expected || !utf8.ValidString(got) {
+			t.Errorf("%q : expected %q, got %q", tc.s, tc.expected, got)
+		}
+	}
+}
+
+func TestJSONLAuditSink(t *testing.T) {
+	sink, err := NewJSONLAuditSink(filepath.Join(t.TempDir(), "audit.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sink.Close()
+
+	for _, text := range []string{"one", "two", "three"} {
+		if err := sink.Record(AuditEntry{Text: text, Decision: DecisionAllowed}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := sink.Last(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Text != "two" || entries[1].Text != "three" {
+		t.Errorf("expected the last two oldest first, got %+v", entries)
+	}
+}
@@ -1,156 +1,198 @@
 package messagehandlers
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"log"
-	"os"
-	"regexp"
+	"sort"
 	"strings"
 	"time"
 
+	"github.com/kpfaulkner/wheatley/config"
 	"github.com/kpfaulkner/wheatley/helper"
+	"github.com/kpfaulkner/wheatley/logging"
 )
 
-type AzureCostsConfig struct {
-	SubscriptionID   string `json:"SubscriptionID"`
-	TenantID         string `json:"TenantID"`
-	ClientID         string `json:"ClientID"`
-	ClientSecret     string `json:"ClientSecret"`
-	Subscriptions    []string
-	AllowedUsersList []string
-}
-
 // AzureCostMessageHandler gets the costs from Azure Billing API.
 type AzureCostsMessageHandler struct {
-	config *AzureCostsConfig
+	config *config.AzureCostsConfig
+	arm    *helper.ARMClient
 }
 
-func NewAzureCostMessageHandler() *AzureCostsMessageHandler {
+func NewAzureCostMessageHandler(config config.AzureCostsConfig, arm *helper.ARMClient) *AzureCostsMessageHandler {
 	asHandler := AzureCostsMessageHandler{}
+	asHandler.config = &config
+	asHandler.arm = arm
+	return &asHandler
+}
 
-	config, err := loadAzureCostsConfig("azurecosts.json")
-	if err != nil {
-		log.Fatalf("Cannot read azure costs config :  %s\n", err.Error())
-	}
+func (ss *AzureCostsMessageHandler) Name() string {
+	return "AzureCostsMessageHandler"
+}
 
-	asHandler.config = config
+func (ss *AzureCostsMessageHandler) Commands() []*Command {
+	report := ss.reportCommand()
 
-	return &asHandler
+	// shorter version for /wheatley costs 2026-09-01 2026-09-30
+	short := NewProgressCommand("costs <start:date> <end:date>", "Same as report azurecosts from <start> to <end>.", costsAck, ss.reportCosts)
+	short.Timeout = costsTimeout
+	pick := NewContextCommand("report azurecosts", "Pick the dates for the cost report from a calendar.", ss.pickDates)
+	reportPrefix := NewProgressCommand("report azurecosts with prefix <prefix:word> from <start:date> to <end:date>", "Gives costings between the 2 dates for resource groups starting with prefix.", costsAck, ss.reportCostsForPrefix)
+
+	// each page of billing can take minutes.
+	reportPrefix.Timeout = costsTimeout
+	return []*Command{report, reportPrefix, pick, short}
+}
+
+func (ss *AzureCostsMessageHandler) reportCommand() *Command {
+	c := NewProgressCommand("report azurecosts from <start:date> to <end:date>", "Gives costings between the 2 dates. Splits into pre-defined groups.", costsAck, ss.reportCosts)
+	c.Timeout = costsTimeout
+	return c
+}
+
+// the Pick dates button opens the date range modal, submitting the modal runs the report.
+const (
+	pickDatesActionID = "azurecosts_pick_dates"
+	dateRangeModalID  = "azurecosts_date_range"
+)
+
+// Actions are the Pick dates button and the date range modal. Anyone who can run the report
+// can use them.
+func (ss *AzureCostsMessageHandler) Actions() []*Action {
+	pick := NewAction(pickDatesActionID, ss.openDateRange)
+	pick.Command = ss.reportCommand()
+
+	submit := NewAction(dateRangeModalID, ss.submitDateRange)
+	submit.Command = ss.reportCommand()
+	submit.Ack = costsAck
+	submit.Timeout = costsTimeout
+	return []*Action{pick, submit}
+}
+
+// pickDates offers a button, modals can only be opened from an interaction and not a message.
+func (ss *AzureCostsMessageHandler) pickDates(ctx context.Context, req *Request) (MessageResponse, error) {
+	resp := NewBlocksMessageResponse("Pick the dates for the cost report")
+	resp.AddSection("Which dates do you want the cost report for?")
+	resp.AddButtons("azurecosts_pick", Button{Text: "Pick dates", ActionID: pickDatesActionID, Style: "primary"})
+	return resp, nil
+}
+
+// openDateRange defaults to month to date.
+func (ss *AzureCostsMessageHandler) openDateRange(ctx context.Context, req *Request) (MessageResponse, error) {
+	now := time.Now().UTC()
+	modal := NewModalMessageResponse(req.Interaction.TriggerID, dateRangeModalID, "Azure cost report", "Report")
+	modal.AddDatePicker("start", "From", time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC))
+	modal.AddDatePicker("end", "To", now)
+	return modal, nil
 }
 
-func loadAzureCostsConfig(configFileName string) (*AzureCostsConfig, error) {
-	var config AzureCostsConfig
-	configFile, err := os.Open(configFileName)
-	defer configFile.Close()
+func (ss *AzureCostsMessageHandler) submitDateRange(ctx context.Context, req *Request) (MessageResponse, error) {
+	startDate, err := time.Parse("2006-01-02", req.Args.String("start"))
+	if err != nil {
+		return NewTextMessageResponse("start should be a date in YYYY-MM-DD format"), nil
+	}
+	endDate, err := time.Parse("2006-01-02", req.Args.String("end"))
 	if err != nil {
-		return nil, err
+		return NewTextMessageResponse("end should be a date in YYYY-MM-DD format"), nil
+	}
+	if endDate.Before(startDate) {
+		return NewTextMessageResponse("end date is before the start date"), nil
 	}
 
-	jsonParser := json.NewDecoder(configFile)
-	jsonParser.Decode(&config)
-	return &config, nil
+	// same as if they'd typed the dates.
+	req.Args = CommandArgs{"start": startDate, "end": endDate}
+	return ss.reportCosts(ctx, req)
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (ss *AzureCostsMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	reportAzureCostsRegex := regexp.MustCompile(`^report azurecosts from (.*) to (.*)$`)
-	reportAzureCostsForRGRegex := regexp.MustCompile(`^report azurecosts with prefix (.*) from (.*) to (.*)$`)
-	soundOffRegex := regexp.MustCompile(`sound off`)
-	helpRegex := regexp.MustCompile(`^help$`)
-
-	msg = strings.ToLower(msg)
-	switch {
-
-	case reportAzureCostsRegex.MatchString(msg):
-		res := reportAzureCostsRegex.FindStringSubmatch(msg)
-		if res != nil && len(res) == 3 {
-			startDateStr := strings.ToLower(res[1])
-			endDateStr := strings.ToLower(res[2])
-
-			layout := "2006-01-02"
-			startDate, err := time.Parse(layout, startDateStr)
-			if err != nil {
-				return NewTextMessageResponse("Start date should be in YYYY-MM-DD format"), nil
-			}
-			endDate, err := time.Parse(layout, endDateStr)
-			if err != nil {
-				return NewTextMessageResponse("End date should be in YYYY-MM-DD format"), nil
-			}
-
-			ac := helper.NewAzureCost(ss.config.TenantID, ss.config.ClientID, ss.config.ClientSecret)
-			subCosts, err := ac.GenerateSubscriptionCostDetails(ss.config.Subscriptions, startDate, endDate)
-			if err != nil {
-				fmt.Printf("Error generating sub costs %s\n", err.Error())
-				return NewTextMessageResponse("Unable to generate subscription costs."), nil
-			}
-
-			subTotals := []string{}
-			total := 0.0
-			for _, sc := range subCosts {
-				subTotals = append(subTotals, fmt.Sprintf("Total of sub %s is %0.2f", sc.SubscriptionID, sc.Total))
-				total += sc.Total
-			}
-			subTotals = append(subTotals, fmt.Sprintf("TOTAL is %0.2f", total))
-			return NewTextMessageResponse(strings.Join(subTotals, "\n")), nil
+// costsAck is posted straight away, getting costs from Azure can take a long time.
+const costsAck = "Working on the cost report..."
+
+const costsTimeout = 10 * time.Minute
+
+// costsProgress updates the acknowledgement as each subscription comes back.
+func costsProgress(progress ProgressFunc) helper.CostProgressFunc {
+	failed := []string{}
+	return func(subscriptionID string, done int, total int, err error) {
+		if err != nil {
+			failed = append(failed, subscriptionID)
 		}
 
-		return NewTextMessageResponse("Please check your query... if you think it's right... complain to Ken."), nil
-
-	case reportAzureCostsForRGRegex.MatchString(msg):
-		res := reportAzureCostsForRGRegex.FindStringSubmatch(msg)
-		if res != nil && len(res) == 4 {
-			startDateStr := strings.ToLower(res[2])
-			endDateStr := strings.ToLower(res[3])
-			prefix := strings.ToLower(res[1])
-
-			layout := "2006-01-02"
-			startDate, err := time.Parse(layout, startDateStr)
-			if err != nil {
-				return NewTextMessageResponse("Start date should be in YYYY-MM-DD format"), nil
-			}
-			endDate, err := time.Parse(layout, endDateStr)
-			if err != nil {
-				return NewTextMessageResponse("End date should be in YYYY-MM-DD format"), nil
-			}
-
-			ac := helper.NewAzureCost(ss.config.TenantID, ss.config.ClientID, ss.config.ClientSecret)
-			subCosts, err := ac.GenerateSubscriptionCostDetails(ss.config.Subscriptions, startDate, endDate)
-			if err != nil {
-				fmt.Printf("Error generating sub costs %s\n", err.Error())
-				return NewTextMessageResponse("Unable to generate subscription costs."), nil
-			}
-
-			subTotals := []string{}
-			total := 0.0
-			for _, sc := range subCosts {
-				subTotals = append(subTotals, fmt.Sprintf("Total of sub %s is %0.2f", sc.SubscriptionID, sc.Total))
-				total += sc.Total
-			}
-
-			// just prefix data
-			prefixCosts, err := helper.GetCostsPerRGPrefix([]string{prefix}, subCosts)
-			if err != nil {
-				return NewTextMessageResponse("Unable to get costs for prefix"), nil
-			}
-
-			for prefix, cost := range prefixCosts {
-				subTotals = append(subTotals, fmt.Sprintf("Prefix %s cost %0.2f", prefix, cost))
-			}
-
-			subTotals = append(subTotals, fmt.Sprintf("TOTAL is %0.2f", total))
-			return NewTextMessageResponse(strings.Join(subTotals, "\n")), nil
+		text := fmt.Sprintf("%s %d of %d subscriptions done.", costsAck, done, total)
+		if len(failed) > 0 {
+			text = fmt.Sprintf("%s Failed: %s", text, strings.Join(failed, ", "))
 		}
-	case soundOffRegex.MatchString(msg):
-		return NewTextMessageResponse("AzureCostsMessageHandler reporting for duty"), nil
+		progress(text)
+	}
+}
+
+func (ss *AzureCostsMessageHandler) reportCosts(ctx context.Context, req *Request) (MessageResponse, error) {
+	startDate := req.Args.Date("start")
+	endDate := req.Args.Date("end")
+
+	ac := helper.NewAzureCost(ss.arm)
+	subCosts, err := ac.GenerateSubscriptionCostDetailsWithProgress(ctx, ss.config.Subscriptions, startDate, endDate, costsProgress(req.Progress))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Error generating sub costs %s", err.Error())
+		return NewTextMessageResponse("Unable to generate subscription costs."), nil
+	}
+
+	return generateCostsResponse(subCosts, nil, startDate, endDate), nil
+}
+
+func (ss *AzureCostsMessageHandler) reportCostsForPrefix(ctx context.Context, req *Request) (MessageResponse, error) {
+	startDate := req.Args.Date("start")
+	endDate := req.Args.Date("end")
+	prefix := strings.ToLower(req.Args.String("prefix"))
+
+	ac := helper.NewAzureCost(ss.arm)
+	subCosts, err := ac.GenerateSubscriptionCostDetailsWithProgress(ctx, ss.config.Subscriptions, startDate, endDate, costsProgress(req.Progress))
+	if err != nil {
+		logging.FromContext(ctx).Errorf("Error generating sub costs %s", err.Error())
+		return NewTextMessageResponse("Unable to generate subscription costs."), nil
+	}
 
-	case helpRegex.MatchString(msg):
-		return NewTextMessageResponse("report azurecosts from <YYYY-MM-DD> to <YYYY-MM-DD> : Gives costings between the 2 dates. Splits into pre-defined groups."), nil
+	// just prefix data
+	prefixCosts, err := helper.GetCostsPerRGPrefix([]string{prefix}, subCosts)
+	if err != nil {
+		return NewTextMessageResponse("Unable to get costs for prefix"), nil
+	}
+
+	return generateCostsResponse(subCosts, prefixCosts, startDate, endDate), nil
+}
+
+// generateCostsResponse builds a table of subscription totals, plus the prefix totals if there are any.
+func generateCostsResponse(subCosts []helper.SubscriptionCosts, prefixCosts map[string]float64, startDate time.Time, endDate time.Time) MessageResponse {
+
+	// subscriptions come back in whatever order the goroutines finished.
+	sort.Slice(subCosts, func(i, j int) bool {
+		return subCosts[i].SubscriptionID < subCosts[j].SubscriptionID
+	})
 
+	rows := [][]string{}
+	total := 0.0
+	for _, sc := range subCosts {
+		rows = append(rows, []string{sc.SubscriptionID, fmt.Sprintf("%0.2f", sc.Total)})
+		total += sc.Total
+	}
+
+	resp := NewBlocksMessageResponse(fmt.Sprintf("Azure costs TOTAL is %0.2f", total))
+	resp.AddHeader("Azure costs")
+	resp.AddTable([]string{"Subscription", "Cost"}, rows)
+
+	if len(prefixCosts) > 0 {
+		prefixes := []string{}
+		for prefix := range prefixCosts {
+			prefixes = append(prefixes, prefix)
+		}
+		sort.Strings(prefixes)
+
+		prefixRows := [][]string{}
+		for _, prefix := range prefixes {
+			prefixRows = append(prefixRows, []string{prefix, fmt.Sprintf("%0.2f", prefixCosts[prefix])})
+		}
+		resp.AddTable([]string{"Prefix", "Cost"}, prefixRows)
 	}
-	return NewTextMessageResponse(""), errors.New("No match")
 
+	resp.AddFields("*TOTAL*", fmt.Sprintf("%0.2f", total))
+	resp.AddContext(fmt.Sprintf("From %s to %s", startDate.Format("2006-01-02"), endDate.Format("2006-01-02")))
+	return resp
 }
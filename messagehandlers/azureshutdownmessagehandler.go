@@ -1,96 +1,53 @@
 package messagehandlers
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
-	"github.com/kpfaulkner/act/pkg"
-	"log"
-	"os"
-	"regexp"
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/kpfaulkner/wheatley/helper"
+	"github.com/kpfaulkner/wheatley/logging"
 	"strings"
 )
 
-type AzureShutdownConfig struct {
-	SubscriptionID   string `json:"SubscriptionID"`
-	TenantID         string `json:"TenantID"`
-	ClientID         string `json:"ClientID"`
-	ClientSecret     string `json:"ClientSecret"`
-	AllowedUsersList []string
-}
-
-// AzureCostMessageHandler gets the costs from Azure Billing API.
+// AzureShutdownMessageHandler deletes cloud service deployments.
 type AzureShutdownMessageHandler struct {
-	config       *AzureShutdownConfig
-	azureClassic *pkg.AzureClassic
+	config        *config.AzureShutdownConfig
+	cloudServices *helper.AzureCloudServiceHelper
 }
 
-func NewAzureShutdownMessageHandler() *AzureShutdownMessageHandler {
+// arm is the ARM client with the AzureShutdown section's credentials.
+func NewAzureShutdownMessageHandler(config config.AzureShutdownConfig, arm *helper.ARMClient) *AzureShutdownMessageHandler {
 	asHandler := AzureShutdownMessageHandler{}
-
-	config, err := loadAzureShutdownConfig("azureshutdown.json")
-	if err != nil {
-		log.Fatalf("Cannot read azure costs config :  %s\n", err.Error())
-	}
-
-	asHandler.config = config
-	asHandler.azureClassic = pkg.NewAzureClassic(asHandler.config.TenantID, asHandler.config.SubscriptionID, asHandler.config.ClientID, asHandler.config.ClientSecret)
+	asHandler.config = &config
+	asHandler.cloudServices = helper.NewAzureCloudServiceHelper(arm, config.SubscriptionID)
 	return &asHandler
 }
 
-func loadAzureShutdownConfig(configFileName string) (*AzureShutdownConfig, error) {
-	var config AzureShutdownConfig
-	configFile, err := os.Open(configFileName)
-	defer configFile.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	jsonParser := json.NewDecoder(configFile)
-	jsonParser.Decode(&config)
-	return &config, nil
+// shutdownEnv deletes the production deployment. Beware! Only ever called once someone
+// else has approved it, see RequiresApproval.
+func (as *AzureShutdownMessageHandler) shutdownEnv(ctx context.Context, env string, rg string) error {
+	return as.cloudServices.DeleteDeployment(ctx, rg, env, "production")
 }
 
-func (as *AzureShutdownMessageHandler) shutdownEnv(env string, rg string) error {
-
-	// INTENTIONALLY COMMENTED OUT
-	// since we dont want to accidentally delete something important.
-	// If you're using this.... beware!
-	// as.azureClassic.DeleteCloudServiceDeployment(rg, env, "production")
-	return nil
+func (as *AzureShutdownMessageHandler) Name() string {
+	return "AzureShutdownMessageHandler"
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (as *AzureShutdownMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	shutdownPerf2Regex := regexp.MustCompile(`^shutdown (.*) in rg (.*)$`)
-	soundOffRegex := regexp.MustCompile(`sound off`)
-	helpRegex := regexp.MustCompile(`^help$`)
-
-	msg = strings.ToLower(msg)
-	switch {
-
-	case shutdownPerf2Regex.MatchString(msg):
-		res := shutdownPerf2Regex.FindStringSubmatch(msg)
-		if res != nil && len(res) == 2 {
-			env := strings.ToLower(res[1])
-			rg := strings.ToLower(res[2])
-			err := as.shutdownEnv(env, rg)
-			if err != nil {
-				return NewTextMessageResponse(fmt.Sprintf("env %s being shutdown", env)), nil
-			}
-		}
-
-		return NewTextMessageResponse("Please check your query... if you think it's right... complain to Ken."), nil
-
-	case soundOffRegex.MatchString(msg):
-		return NewTextMessageResponse("AzureShutdownMessageHandler reporting for duty"), nil
-
-	case helpRegex.MatchString(msg):
-		return NewTextMessageResponse("shutdown <env> in rg <rg> : will shutdown the cloud services for the given env (alias) in a particular resource group"), nil
+func (as *AzureShutdownMessageHandler) Commands() []*Command {
+	shutdown := NewContextCommand("shutdown <env:word> in rg <rg:word>", "will shutdown the cloud services for the given env (alias) in a particular resource group", as.shutdown)
+	shutdown.Mutating = true
+	shutdown.RequiresApproval = true
+	return []*Command{shutdown}
+}
 
+func (as *AzureShutdownMessageHandler) shutdown(ctx context.Context, req *Request) (MessageResponse, error) {
+	env := strings.ToLower(req.Args.String("env"))
+	rg := strings.ToLower(req.Args.String("rg"))
+	err := as.shutdownEnv(ctx, env, rg)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("unable to shutdown %s in %s : %s", env, rg, err.Error())
+		return NewTextMessageResponse(fmt.Sprintf("unable to shutdown env %s", env)), nil
 	}
-	return NewTextMessageResponse(""), errors.New("No match")
 
+	return NewTextMessageResponse(fmt.Sprintf("env %s being shutdown", env)), nil
 }
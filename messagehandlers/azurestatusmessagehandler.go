@@ -1,14 +1,13 @@
 package messagehandlers
 
 import (
-	"errors"
+	"context"
+	"fmt"
 	"github.com/kpfaulkner/wheatley/helper"
 	"github.com/kpfaulkner/wheatley/models"
-	"log"
-	"regexp"
 	"sort"
-	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -25,17 +24,15 @@ type AzureStatusMessageHandler struct {
 	config   helper.AzureMonitoringConfigMap
 }
 
-func NewAzureStatusMessageHandler() *AzureStatusMessageHandler {
+// arm has the ARM client (with its credentials) for each AzureMonitor env, by name, and
+// appInsightsEndpoint is the App Insights API, see helper.Cloud.
+func NewAzureStatusMessageHandler(monitoringConfig helper.AzureMonitoringConfig, arm map[string]*helper.ARMClient, appInsightsEndpoint string) *AzureStatusMessageHandler {
 	asHandler := AzureStatusMessageHandler{}
 
-	config, err := helper.LoadAzureMonitoringConfig("azuremonitoring.json")
-	if err != nil {
-		log.Fatalf("Unable to monitoring Azure... something is broken %s\n", err.Error())
-	}
-
+	config := helper.NewAzureMonitoringConfigMap(monitoringConfig)
 	asHandler.config = *config
-	asHandler.AIHelper = helper.NewAppInsightsHelper(*config)
-	asHandler.AMHelper = helper.NewAzureMonitorHelper(*config)
+	asHandler.AIHelper = helper.NewAppInsightsHelper(*config, appInsightsEndpoint)
+	asHandler.AMHelper = helper.NewAzureMonitorHelper(*config, arm)
 
 	return &asHandler
 }
@@ -51,94 +48,159 @@ func (ss *AzureStatusMessageHandler) GetStat(f func(string, int, chan string), e
 // Won't try and do anything overly intelligent here, just basic calling of Azure monitor/app-insights code.
 // Will be VERY hard coded here about which services to call (AM/AI) and what the resource
 // names are. Will make it more intelligent later.
-func (ss *AzureStatusMessageHandler) checkEnv(env string, minsToCheck int) (string, error) {
+// progress gets the results so far each time another one comes in. Whatever has come back
+// by the time ctx is done is returned.
+func (ss *AzureStatusMessageHandler) checkEnv(ctx context.Context, env string, minsToCheck int, progress ProgressFunc) (string, error) {
 	ch := make(chan string, 20)
+	var wg sync.WaitGroup
 
 	// App insights... get details for env.
 	aiEnvConfig := ss.config.AppInsightsMap[env]
 	for _, aiRes := range aiEnvConfig.Resources {
-		go ss.AIHelper.GetCPUAverage(env, aiRes.Name, minsToCheck, ch)
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			ss.AIHelper.GetCPUAverage(ctx, env, name, minsToCheck, ch)
+		}(aiRes.Name)
 	}
 
 	// Azure Montitor config.
 	amEnvConfig := ss.config.AzureMonitorMap[env]
 	for _, amRes := range amEnvConfig.ResourceToMonitor {
-		go ss.AMHelper.GetResourceMetrics(env, amRes.Name, amRes.ResourceGroup, amRes.ResourceName, amRes.MetricDefinition, amRes.Metrics, minsToCheck, ch)
+		wg.Add(1)
+		go func(amRes helper.AzureMonitorResource) {
+			defer wg.Done()
+			ss.AMHelper.GetResourceMetrics(ctx, env, amRes.Name, amRes.ResourceGroup, amRes.ResourceName, amRes.MetricDefinition, amRes.Metrics, minsToCheck, ch)
+		}(amRes)
 	}
 
+	// close once everything has reported back so we know when to stop waiting.
+	go func() {
+		wg.Wait()
+		close(ch)
+	}()
+
 	responseList := []string{}
-	// loop forever (for 2 goroutines this is overkill, but not much overhead in preparation for other
-	// metrics to soon be returned.
-	quit := false
-	for !quit {
+	for {
 		select {
-		case msg := <-ch:
+		case msg, ok := <-ch:
+			if !ok {
+				sort.Strings(responseList)
+				return strings.Join(responseList, "\n"), nil
+			}
 			responseList = append(responseList, msg)
-			break
-		case <-time.After(5 * time.Second):
-			// quit...  quit it all. You've had your time, now bugger off :)
-			quit = true
+			sort.Strings(responseList)
+			progress(fmt.Sprintf("%s\n%s", checkEnvAck, strings.Join(responseList, "\n")))
+
+		case <-ctx.Done():
+			// You've had your time, now bugger off :)
+			sort.Strings(responseList)
+			responseList = append(responseList, "(gave up waiting on the rest)")
+			return strings.Join(responseList, "\n"), nil
 		}
 	}
+}
 
-	sort.Strings(responseList)
-	return strings.Join(responseList, "\n"), nil
+func (ss *AzureStatusMessageHandler) Name() string {
+	return "AzureStatusMessageHandler"
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (ss *AzureStatusMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	checkAzureStatusRegex := regexp.MustCompile(`^check (.*)`)
-	checkAzureStatusLastRegex := regexp.MustCompile(`^check (.*?) last (.*?) min`)
-	soundOffRegex := regexp.MustCompile(`sound off`)
-	helpRegex := regexp.MustCompile(`^help$`)
-
-	msg = strings.ToLower(msg)
-	switch {
-
-	case checkAzureStatusLastRegex.MatchString(msg):
-		res := checkAzureStatusLastRegex.FindStringSubmatch(msg)
-		if res != nil && len(res) == 3 {
-			env := strings.ToLower(res[1])
-			if env == "test" || env == "prod" {
-
-				minsCheck, err := strconv.Atoi(res[2])
-				if err != nil || minsCheck < 5 || minsCheck > 60 {
-					return NewTextMessageResponse("not a valid number... please pick something between 5 and 60"), nil
-				}
-				answer, err := ss.checkEnv(res[1], minsCheck)
-				if err != nil {
-					return NewTextMessageResponse("unable to get answer"), nil
-				}
-				return NewTextMessageResponse(answer), nil
-			}
+func (ss *AzureStatusMessageHandler) Commands() []*Command {
+	checkLast := ss.checkLastCommand()
+	checkSpan := NewProgressCommand("check <env:env> last <span:duration(5m..60m)>", "same again, but with the span given as 30m, 1h etc", checkEnvAck, ss.checkEnvSpan)
+	check := NewProgressCommand("check <env:env>", "gives details about the env.", checkEnvAck, ss.checkEnvDefault)
 
-			return NewTextMessageResponse("Please check your query... if you think it's right... complain to Ken."), nil
-		}
+	commands := []*Command{check, checkLast, checkSpan}
+	for _, c := range commands {
+		c.Envs = ss.envs
+		c.Timeout = checkEnvTimeout
+	}
+	return commands
+}
 
-	case checkAzureStatusRegex.MatchString(msg):
-		res := checkAzureStatusRegex.FindStringSubmatch(msg)
-		if res != nil {
-			env := strings.ToLower(res[1])
-			if env == "test" || env == "prod" {
-				answer, err := ss.checkEnv(res[1], 5)
-				if err != nil {
-					return NewTextMessageResponse("unable to get answer"), nil
-				}
-				return NewTextMessageResponse(answer), nil
-			}
+func (ss *AzureStatusMessageHandler) checkLastCommand() *Command {
+	c := NewProgressCommand("check <env:env> last <mins:int(5..60)> mins", "will check env for last n mins, where 5<= n <= 60", checkEnvAck, ss.checkEnvLast)
+	c.Envs = ss.envs
+	c.Timeout = checkEnvTimeout
+	return c
+}
+
+// rerunActionID is the button under the results that checks the same env again for the last hour.
+const rerunActionID = "azurestatus_rerun_60"
+
+// rerunMins is as far back as the check commands go.
+const rerunMins = 60
+
+// Actions is the re-run button. Anyone who can run check ... last n mins can click it.
+func (ss *AzureStatusMessageHandler) Actions() []*Action {
+	rerun := NewAction(rerunActionID, ss.rerun)
+	rerun.Command = ss.checkLastCommand()
+	rerun.Ack = checkEnvAck
+	rerun.Timeout = checkEnvTimeout
+	return []*Action{rerun}
+}
+
+func (ss *AzureStatusMessageHandler) rerun(ctx context.Context, req *Request) (MessageResponse, error) {
+	env := strings.ToLower(req.Args.String("value"))
+	if !contains(env, ss.envs()) {
+		return NewTextMessageResponse(fmt.Sprintf("don't know about env %s any more", env)), nil
+	}
+	return ss.checkEnvResponse(ctx, env, rerunMins, req.Progress)
+}
 
-			return NewTextMessageResponse("Please check your query... if you think it's right... complain to Ken."), nil
+// envs are the envs that have either app insights or azure monitor configured.
+func (ss *AzureStatusMessageHandler) envs() []string {
+	envs := []string{}
+	for env := range ss.config.AppInsightsMap {
+		envs = append(envs, strings.ToLower(env))
+	}
+	for env := range ss.config.AzureMonitorMap {
+		if !contains(strings.ToLower(env), envs) {
+			envs = append(envs, strings.ToLower(env))
 		}
+	}
+	sort.Strings(envs)
+	return envs
+}
 
-	case soundOffRegex.MatchString(msg):
-		return NewTextMessageResponse("ServerStatusMessageHandler reporting for duty"), nil
+// checkEnvAck is posted straight away, results get added to it as they come in.
+const checkEnvAck = "Checking, give me a few seconds..."
 
-	case helpRegex.MatchString(msg):
-		return NewTextMessageResponse("check <test|prod>  : gives details about test/prod env.\ncheck <test/prod> last <n> mins : will check env for last n mins, where 5<= n <= 60"), nil
+// checkEnvTimeout is how long to wait on Azure before giving back what we've got.
+const checkEnvTimeout = 20 * time.Second
+
+func (ss *AzureStatusMessageHandler) checkEnvLast(ctx context.Context, req *Request) (MessageResponse, error) {
+	return ss.checkEnvResponse(ctx, req.Args.String("env"), req.Args.Int("mins"), req.Progress)
+}
+
+func (ss *AzureStatusMessageHandler) checkEnvSpan(ctx context.Context, req *Request) (MessageResponse, error) {
+	return ss.checkEnvResponse(ctx, req.Args.String("env"), int(req.Args.Duration("span").Minutes()), req.Progress)
+}
 
+func (ss *AzureStatusMessageHandler) checkEnvDefault(ctx context.Context, req *Request) (MessageResponse, error) {
+	return ss.checkEnvResponse(ctx, req.Args.String("env"), 5, req.Progress)
+}
+
+func (ss *AzureStatusMessageHandler) checkEnvResponse(ctx context.Context, env string, minsToCheck int, progress ProgressFunc) (MessageResponse, error) {
+	answer, err := ss.checkEnv(ctx, env, minsToCheck, progress)
+	if err != nil {
+		return NewTextMessageResponse("unable to get answer"), nil
+	}
+
+	// Slack won't take an empty section.
+	if answer == "" {
+		answer = fmt.Sprintf("nothing came back for %s", env)
+	}
+
+	// too long for a single section, just send it as text.
+	if len(answer) > maxSectionTextLength {
+		return NewTextMessageResponse(answer), nil
 	}
-	return NewTextMessageResponse(""), errors.New("No match")
 
+	resp := NewBlocksMessageResponse(answer)
+	resp.AddSection(answer)
+	if minsToCheck < rerunMins {
+		resp.AddButtons("azurestatus_"+env, Button{Text: fmt.Sprintf("Re-run for last %d mins", rerunMins), ActionID: rerunActionID, Value: env})
+	}
+	return resp, nil
 }
@@ -1,30 +1,25 @@
 package messagehandlers
 
 import (
-	"errors"
-	"fmt"
 	"github.com/kpfaulkner/wheatley/config"
+	"github.com/kpfaulkner/wheatley/logging"
 	"regexp"
 )
 
 // AzureStorageMessageHandler checks for SG messages.
 type AzureStorageMessageHandler struct {
-	Configuration config.Config
+	Configuration config.AzureStorageConfig
 
 	// regexp generated from config
 	regexpMap map[*regexp.Regexp]string
 }
 
-func NewAzureStorageMessageHandler(configFilePath string) *AzureStorageMessageHandler {
+func NewAzureStorageMessageHandler(config config.AzureStorageConfig) *AzureStorageMessageHandler {
 	asHandler := AzureStorageMessageHandler{}
+	asHandler.Configuration = config
 
-	if configFilePath != "" {
-		asHandler.Configuration = config.LoadConfiguration(configFilePath)
-
-		// generate regexs for azure storage.
-		asHandler.regexpMap = generateRegexps(asHandler.Configuration.AzureQueries)
-	}
-
+	// generate regexs for azure storage.
+	asHandler.regexpMap = generateRegexps(config.Queries)
 	return &asHandler
 }
 
@@ -34,7 +29,7 @@ func generateRegexps(azureQueries map[string]string) map[*regexp.Regexp]string {
 	compiledQueries := make(map[*regexp.Regexp]string)
 
 	for k, v := range azureQueries {
-		fmt.Printf("key %s, val %s\n", k, v)
+		logging.Debugf("key %s, val %s", k, v)
 		compiledQuery := regexp.MustCompile(k)
 		compiledQueries[compiledQuery] = v
 	}
@@ -42,12 +37,11 @@ func generateRegexps(azureQueries map[string]string) map[*regexp.Regexp]string {
 	return compiledQueries
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (sg *AzureStorageMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	fmt.Printf("msg is %s", msg)
-
-	return NewTextMessageResponse(""), errors.New("No match")
+func (sg *AzureStorageMessageHandler) Name() string {
+	return "AzureStorageMessageHandler"
+}
 
+// Commands nothing yet, queries aren't wired up to anything.
+func (sg *AzureStorageMessageHandler) Commands() []*Command {
+	return nil
 }
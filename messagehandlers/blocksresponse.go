@@ -0,0 +1,205 @@
+package messagehandlers
+
+import (
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+const (
+	// Slack limits, see https://api.slack.com/reference/block-kit/blocks
+	maxSectionTextLength = 3000
+	maxSectionFields     = 10
+	maxHeaderLength      = 150
+)
+
+// BlocksMessageResponse is a Block Kit message. Sent as a single chat.postMessage instead of
+// line by line.
+type BlocksMessageResponse struct {
+	BaseMessageResponse
+
+	// Fallback is what shows in notifications and clients that can't do blocks.
+	Fallback string
+	Blocks   []slack.Block
+}
+
+func NewBlocksMessageResponse(fallback string) BlocksMessageResponse {
+	bm := BlocksMessageResponse{}
+	bm.messageType = BlocksMessageType
+	bm.Fallback = fallback
+	return bm
+}
+
+func (bm *BlocksMessageResponse) AddHeader(text string) {
+	text = limitRunes(text, maxHeaderLength)
+	bm.Blocks = append(bm.Blocks, slack.NewHeaderBlock(slack.NewTextBlockObject(slack.PlainTextType, text, false, false)))
+}
+
+// AddSection adds a block of mrkdwn text.
+func (bm *BlocksMessageResponse) AddSection(text string) {
+	bm.Blocks = append(bm.Blocks, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil))
+}
+
+// AddFields adds mrkdwn fields, shown 2 columns wide. Split over multiple sections if
+// there are more than Slack allows in one.
+func (bm *BlocksMessageResponse) AddFields(fields ...string) {
+	for len(fields) > 0 {
+		n := len(fields)
+		if n > maxSectionFields {
+			n = maxSectionFields
+		}
+
+		textFields := []*slack.TextBlockObject{}
+		for _, f := range fields[:n] {
+			textFields = append(textFields, slack.NewTextBlockObject(slack.MarkdownType, f, false, false))
+		}
+		bm.Blocks = append(bm.Blocks, slack.NewSectionBlock(nil, textFields, nil))
+		fields = fields[n:]
+	}
+}
+
+// AddTable renders rows as a code block with padded columns, since Block Kit has no tables.
+// Long tables are split over multiple sections.
+func (bm *BlocksMessageResponse) AddTable(headers []string, rows [][]string) {
+	all := [][]string{}
+	if len(headers) > 0 {
+		all = append(all, headers)
+	}
+	all = append(all, rows...)
+
+	widths := []int{}
+	for _, row := range all {
+		for i, col := range row {
+			if i >= len(widths) {
+				widths = append(widths, 0)
+			}
+			if len(col) > widths[i] {
+				widths[i] = len(col)
+			}
+		}
+	}
+
+	lines := []string{}
+	for _, row := range all {
+		cols := []string{}
+		for i, col := range row {
+			cols = append(cols, col+strings.Repeat(" ", widths[i]-len(col)))
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cols, "  "), " "))
+	}
+
+	// leave room for the ``` either side.
+	chunk := []string{}
+	chunkLength := 0
+	for _, line := range lines {
+		if chunkLength+len(line)+1 > maxSectionTextLength-8 && len(chunk) > 0 {
+			bm.AddSection("```\n" + strings.Join(chunk, "\n") + "\n```")
+			chunk = []string{}
+			chunkLength = 0
+		}
+		chunk = append(chunk, line)
+		chunkLength += len(line) + 1
+	}
+	if len(chunk) > 0 {
+		bm.AddSection("```\n" + strings.Join(chunk, "\n") + "\n```")
+	}
+}
+
+// AddContext adds a small grey footer line.
+func (bm *BlocksMessageResponse) AddContext(text string) {
+	bm.Blocks = append(bm.Blocks, slack.NewContextBlock("", slack.NewTextBlockObject(slack.MarkdownType, text, false, false)))
+}
+
+func (bm *BlocksMessageResponse) AddDivider() {
+	bm.Blocks = append(bm.Blocks, slack.NewDividerBlock())
+}
+
+// JSON is the blocks as they'll be sent to Slack. Handy for pasting into the Block Kit Builder.
+func (bm BlocksMessageResponse) JSON() ([]byte, error) {
+	return json.Marshal(slack.Blocks{BlockSet: bm.Blocks})
+}
+
+// Button is an interactive button. ActionID says what the button does and Value is passed
+// back with it when clicked. Style is "", "primary" or "danger".
+type Button struct {
+	Text     string
+	ActionID string
+	Value    string
+	Style    string
+}
+
+// AddButtons adds a row of buttons.
+func (bm *BlocksMessageResponse) AddButtons(blockID string, buttons ...Button) {
+	elements := []slack.BlockElement{}
+	for _, b := range buttons {
+		button := slack.NewButtonBlockElement(b.ActionID, b.Value, slack.NewTextBlockObject(slack.PlainTextType, b.Text, false, false))
+		if b.Style != "" {
+			button = button.WithStyle(slack.Style(b.Style))
+		}
+		elements = append(elements, button)
+	}
+	bm.Blocks = append(bm.Blocks, slack.NewActionBlock(blockID, elements...))
+}
+
+// Slack limit on modal titles.
+const maxModalTitleLength = 24
+
+// ModalMessageResponse opens a modal. Modals can only be opened in response to an interaction,
+// so TriggerID has to come from Request.Interaction. When the modal is submitted, the action
+// with ID callbackID gets the inputs in Request.Args, keyed by their action IDs.
+type ModalMessageResponse struct {
+	BaseMessageResponse
+	TriggerID string
+	View      slack.ModalViewRequest
+}
+
+func NewModalMessageResponse(triggerID string, callbackID string, title string, submit string) ModalMessageResponse {
+	title = limitRunes(title, maxModalTitleLength)
+
+	mm := ModalMessageResponse{}
+	mm.messageType = ModalMessageType
+	mm.TriggerID = triggerID
+	mm.View = slack.ModalViewRequest{
+		Type:       slack.VTModal,
+		CallbackID: callbackID,
+		Title:      slack.NewTextBlockObject(slack.PlainTextType, title, false, false),
+		Submit:     slack.NewTextBlockObject(slack.PlainTextType, submit, false, false),
+		Close:      slack.NewTextBlockObject(slack.PlainTextType, "Cancel", false, false),
+	}
+	return mm
+}
+
+// AddSection adds a block of mrkdwn text.
+func (mm *ModalMessageResponse) AddSection(text string) {
+	mm.View.Blocks.BlockSet = append(mm.View.Blocks.BlockSet, slack.NewSectionBlock(slack.NewTextBlockObject(slack.MarkdownType, text, false, false), nil, nil))
+}
+
+// AddDatePicker adds a date input. The value comes back as YYYY-MM-DD.
+func (mm *ModalMessageResponse) AddDatePicker(actionID string, label string, initial time.Time) {
+	picker := slack.NewDatePickerBlockElement(actionID)
+	if !initial.IsZero() {
+		picker.InitialDate = initial.Format("2006-01-02")
+	}
+	mm.addInput(actionID, label, picker)
+}
+
+// AddTextInput adds a single line text input.
+func (mm *ModalMessageResponse) AddTextInput(actionID string, label string) {
+	mm.addInput(actionID, label, slack.NewPlainTextInputBlockElement(nil, actionID))
+}
+
+func (mm *ModalMessageResponse) addInput(actionID string, label string, element slack.BlockElement) {
+	mm.View.Blocks.BlockSet = append(mm.View.Blocks.BlockSet, slack.NewInputBlock(actionID, slack.NewTextBlockObject(slack.PlainTextType, label, false, false), element))
+}
+
+// limitRunes cuts s to max characters, Slack's limits are in characters rather than bytes and
+// cutting bytes could split one in half.
+func limitRunes(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	return string(runes[:max])
+}
@@ -0,0 +1,145 @@
+package messagehandlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/slack-go/slack"
+)
+
+// assertJSON checks the blocks marshal to expected, ignoring whitespace in expected.
+func assertJSON(t *testing.T, bm BlocksMessageResponse, expected string) {
+	t.Helper()
+	got, err := bm.JSON()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := bytes.Buffer{}
+	if err := json.Compact(&want, []byte(expected)); err != nil {
+		t.Fatalf("bad expected JSON : %s", err.Error())
+	}
+	if string(got) != want.String() {
+		t.Errorf("expected\n%s\ngot\n%s", want.String(), got)
+	}
+}
+
+func TestAddSection(t *testing.T) {
+	bm := NewBlocksMessageResponse("fallback")
+	bm.AddHeader("Costs")
+	bm.AddSection("*prod* is up")
+	bm.AddDivider()
+
+	assertJSON(t, bm, `[
+		{"type":"header","text":{"type":"plain_text","text":"Costs"}},
+		{"type":"section","text":{"type":"mrkdwn","text":"*prod* is up"}},
+		{"type":"divider"}
+	]`)
+	if bm.GetMessageResponseType() != BlocksMessageType || bm.Fallback != "fallback" {
+		t.Errorf("unexpected response %+v", bm)
+	}
+}
+
+func TestAddFields(t *testing.T) {
+	bm := NewBlocksMessageResponse("")
+	bm.AddFields("*Env*", "prod")
+
+	assertJSON(t, bm, `[
+		{"type":"section","fields":[{"type":"mrkdwn","text":"*Env*"},{"type":"mrkdwn","text":"prod"}]}
+	]`)
+}
+
+func TestAddFieldsSplitsPastTen(t *testing.T) {
+	fields := []string{}
+	for i := 1; i <= 12; i++ {
+		fields = append(fields, fmt.Sprintf("f%d", i))
+	}
+	bm := NewBlocksMessageResponse("")
+	bm.AddFields(fields...)
+
+	assertJSON(t, bm, `[
+		{"type":"section","fields":[
+			{"type":"mrkdwn","text":"f1"},{"type":"mrkdwn","text":"f2"},{"type":"mrkdwn","text":"f3"},
+			{"type":"mrkdwn","text":"f4"},{"type":"mrkdwn","text":"f5"},{"type":"mrkdwn","text":"f6"},
+			{"type":"mrkdwn","text":"f7"},{"type":"mrkdwn","text":"f8"},{"type":"mrkdwn","text":"f9"},
+			{"type":"mrkdwn","text":"f10"}
+		]},
+		{"type":"section","fields":[{"type":"mrkdwn","text":"f11"},{"type":"mrkdwn","text":"f12"}]}
+	]`)
+}
+
+func TestAddTable(t *testing.T) {
+	bm := NewBlocksMessageResponse("")
+	bm.AddTable([]string{"Name", "Cost"}, [][]string{{"prod", "12.50"}, {"test-long", "3"}})
+
+	assertJSON(t, bm, `[
+		{"type":"section","text":{"type":"mrkdwn","text":"`+"```"+`\nName       Cost\nprod       12.50\ntest-long  3\n`+"```"+`"}}
+	]`)
+}
+
+func TestAddTableChunking(t *testing.T) {
+	rows := [][]string{}
+	for i := 0; i < 200; i++ {
+		rows = append(rows, []string{fmt.Sprintf("resource-%03d", i), strings.Repeat("x", 20)})
+	}
+	bm := NewBlocksMessageResponse("")
+	bm.AddTable([]string{"Name", "Value"}, rows)
+
+	if len(bm.Blocks) < 2 {
+		t.Fatalf("expected the table to be split, got %d block(s)", len(bm.Blocks))
+	}
+
+	// every row in order, with each section a complete code block under Slack's limit.
+	lines := []string{}
+	for _, b := range bm.Blocks {
+		section, ok := b.(*slack.SectionBlock)
+		if !ok {
+			t.Fatalf("expected a section, got %T", b)
+		}
+		text := section.Text.Text
+		if len(text) > maxSectionTextLength {
+			t.Errorf("section is %d long, over the %d limit", len(text), maxSectionTextLength)
+		}
+		if !strings.HasPrefix(text, "```\n") || !strings.HasSuffix(text, "\n```") {
+			t.Errorf("section isn't a code block : %q", text)
+		}
+		lines = append(lines, strings.Split(strings.TrimSuffix(strings.TrimPrefix(text, "```\n"), "\n```"), "\n")...)
+	}
+
+	if len(lines) != len(rows)+1 {
+		t.Fatalf("expected %d lines, got %d", len(rows)+1, len(lines))
+	}
+	if lines[0] != "Name          Value" {
+		t.Errorf("unexpected header %q", lines[0])
+	}
+	for i, row := range rows {
+		if !strings.HasPrefix(lines[i+1], row[0]+"  ") {
+			t.Errorf("line %d is %q, expected %s", i+1, lines[i+1], row[0])
+		}
+	}
+}
+
+func TestAddContext(t *testing.T) {
+	bm := NewBlocksMessageResponse("")
+	bm.AddContext("updated 5 minutes ago")
+
+	assertJSON(t, bm, `[
+		{"type":"context","elements":[{"type":"mrkdwn","text":"updated 5 minutes ago"}]}
+	]`)
+}
+
+func TestLimitsAreCharacters(t *testing.T) {
+	bm := NewBlocksMessageResponse("fallback")
+	bm.AddHeader(strings.Repeat("é", 200))
+	header := bm.Blocks[0].(*slack.HeaderBlock).Text.Text
+	if header != strings.Repeat("é", maxHeaderLength) {
+		t.Errorf("expected %d characters, got %d", maxHeaderLength, len([]rune(header)))
+	}
+
+	mm := NewModalMessageResponse("trigger", "callback", "Réinitialiser le mot de passe", "OK")
+	if mm.View.Title.Text != "Réinitialiser le mot de " {
+		t.Errorf("unexpected title %q", mm.View.Title.Text)
+	}
+}
@@ -0,0 +1,449 @@
+package messagehandlers
+
+import (
+	"context"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Commands are declared with a spec made up of literal words and typed placeholders, eg
+//
+//	report azurecosts from <start:date> to <end:date>
+//	check <env:env> last <mins:int(5..60)> mins
+//	sb check <kind:enum(queue|topic)> [<name:word>]
+//
+// Placeholder types are:
+//
+//	date           YYYY-MM-DD
+//	duration       30m, 2h, 90mins etc. Optional range, duration(5m..1h)
+//	int            Optional range, int(5..60)
+//	env            one of the names returned by Command.Envs
+//	email          plain address or the <mailto:...|...> form Slack sends
+//	enum(a|b)      one of the listed values
+//	word           any single word (the default if no type is given)
+//	text           rest of the message, must be last.
+//
+// A single trailing placeholder can be made optional by wrapping it in [ ].
+
+// CommandArgs are the parsed placeholder values, keyed by placeholder name.
+type CommandArgs map[string]interface{}
+
+// String returns a word, text, env, email or enum argument. Empty if not supplied.
+func (a CommandArgs) String(name string) string {
+	s, _ := a[name].(string)
+	return s
+}
+
+func (a CommandArgs) Int(name string) int {
+	i, _ := a[name].(int)
+	return i
+}
+
+func (a CommandArgs) Date(name string) time.Time {
+	t, _ := a[name].(time.Time)
+	return t
+}
+
+func (a CommandArgs) Duration(name string) time.Duration {
+	d, _ := a[name].(time.Duration)
+	return d
+}
+
+// CommandFunc is called with the parsed arguments when its command wins.
+// New commands should use HandlerFunc instead, see NewContextCommand.
+type CommandFunc func(args CommandArgs, user string) (MessageResponse, error)
+
+// Command is a single command a handler answers to.
+type Command struct {
+	// Priority decides between commands that fully match the same message. Higher wins.
+	// After priority, the command with the most literal words wins.
+	Priority int
+
+	// Help describes the command in the combined help listing. Empty means it's not listed.
+	Help   string
+	Handle HandlerFunc
+
+	// Timeout for the context passed to Handle. Zero means DefaultCommandTimeout.
+	Timeout time.Duration
+
+	// Envs returns the valid names for env placeholders.
+	Envs func() []string
+
+	// Mutating commands change something. Unless the policy says otherwise they need one of
+	// the policy's MutatingRoles.
+	Mutating bool
+
+	// Sensitive commands don't change anything but show things not everyone should see. As far as
+	// the policy is concerned they're the same as Mutating.
+	Sensitive bool
+
+	// RequiresApproval commands are destructive. They don't run until a second person approves them,
+	// see ApprovalManager.
+	RequiresApproval bool
+
+	// Threaded replies go in the thread of the triggering message rather than the channel.
+	Threaded bool
+
+	// Ephemeral replies to slash commands are only shown to whoever ran the command.
+	Ephemeral bool
+
+	// Ack is posted as soon as the command starts, before Handle is called. See NewProgressCommand.
+	Ack string
+
+	spec    []specToken
+	pattern *regexp.Regexp
+	handler string // name of the handler that registered the command.
+}
+
+// NewCommand parses the spec and returns the command.
+// Panics if the spec is invalid, same as regexp.MustCompile.
+func NewCommand(spec string, help string, run CommandFunc) *Command {
+	return NewContextCommand(spec, help, AdaptCommandFunc(run))
+}
+
+// NewContextCommand is the same as NewCommand but for a context aware HandlerFunc.
+func NewContextCommand(spec string, help string, handle HandlerFunc) *Command {
+	tokens, err := parseSpec(spec)
+	if err != nil {
+		panic(fmt.Sprintf("messagehandlers: bad command spec %q: %s", spec, err.Error()))
+	}
+
+	c := Command{}
+	c.spec = tokens
+	c.Help = help
+	c.Handle = handle
+	return &c
+}
+
+// NewPatternCommand is for chatter that isn't really a command and can appear anywhere in
+// a message. Pattern is a case insensitive regexp, no arguments are parsed.
+func NewPatternCommand(pattern string, priority int, run CommandFunc) *Command {
+	c := Command{}
+	c.pattern = regexp.MustCompile(`(?i)` + pattern)
+	c.Priority = priority
+	c.Handle = AdaptCommandFunc(run)
+	return &c
+}
+
+// Execute runs the command with a context that's cancelled once the command's timeout is up.
+func (c *Command) Execute(ctx context.Context, req *Request) (MessageResponse, error) {
+	return runHandler(ctx, c.Timeout, c.Handle, req)
+}
+
+// runHandler calls handle with the timeout applied, zero meaning DefaultCommandTimeout.
+func runHandler(ctx context.Context, timeout time.Duration, handle HandlerFunc, req *Request) (MessageResponse, error) {
+	if timeout == 0 {
+		timeout = DefaultCommandTimeout
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	if req.Progress == nil {
+		req.Progress = noProgress
+	}
+	if req.Reply == nil {
+		req.Reply = noReply
+	}
+	return handle(ctx, req)
+}
+
+// Usage is the command as shown to users, eg "check <env> last <mins> mins"
+func (c *Command) Usage() string {
+	if c.pattern != nil {
+		return c.pattern.String()
+	}
+
+	parts := []string{}
+	for _, t := range c.spec {
+		parts = append(parts, t.usage())
+	}
+	return strings.Join(parts, " ")
+}
+
+// UsageError is returned when a message looks like a command but the arguments don't parse.
+type UsageError struct {
+	Reason string
+	Usages []string
+}
+
+func (e UsageError) Error() string {
+	return fmt.Sprintf("%s\nusage: %s", e.Reason, strings.Join(e.Usages, "\nusage: "))
+}
+
+// commandMatch is the result of checking a message against a command.
+type commandMatch struct {
+	args     CommandArgs
+	literals int   // literal words matched.
+	err      error // nil if the message fully matched.
+}
+
+var wordRegex = regexp.MustCompile(`\S+`)
+
+// match checks the message against the command. Returns nil if the message has nothing to do
+// with the command, otherwise the args and possibly a usage error.
+func (c *Command) match(msg string) *commandMatch {
+	if c.pattern != nil {
+		if c.pattern.MatchString(msg) {
+			return &commandMatch{args: CommandArgs{}}
+		}
+		return nil
+	}
+
+	words := wordRegex.FindAllStringIndex(msg, -1)
+	m := commandMatch{args: CommandArgs{}}
+	for i, t := range c.spec {
+
+		if i >= len(words) {
+			if t.optional {
+				break
+			}
+			m.err = fmt.Errorf("missing %s", t.usage())
+			break
+		}
+
+		word := msg[words[i][0]:words[i][1]]
+		if t.literal != "" {
+			if !strings.EqualFold(word, t.literal) {
+				m.err = fmt.Errorf("expected %q but got %q", t.literal, word)
+				break
+			}
+			m.literals++
+			continue
+		}
+
+		// text swallows the rest of the message.
+		if t.kind == "text" {
+			m.args[t.name] = strings.TrimSpace(msg[words[i][0]:])
+			words = words[:i+1]
+			continue
+		}
+
+		v, err := t.parse(word, c)
+		if err != nil {
+			m.err = err
+			break
+		}
+		m.args[t.name] = v
+	}
+
+	if m.err == nil && len(words) > len(c.spec) {
+		m.err = fmt.Errorf("unexpected %q", msg[words[len(c.spec)][0]:])
+	}
+
+	// needs to at least start with the right word to be considered.
+	if m.literals == 0 {
+		return nil
+	}
+	return &m
+}
+
+// specToken is either a literal word or a typed placeholder.
+type specToken struct {
+	literal  string
+	name     string
+	kind     string
+	param    string
+	optional bool
+
+	// parsed param for int, duration and enum. hasRange is set if min and max were given,
+	// either can be zero or negative.
+	hasRange bool
+	min, max int64
+	values   []string
+}
+
+var placeholderRegex = regexp.MustCompile(`^<(\w+)(?::(\w+)(?:\((.*)\))?)?>$`)
+
+func parseSpec(spec string) ([]specToken, error) {
+	tokens := []specToken{}
+	fields := strings.Fields(spec)
+	for i, f := range fields {
+		t := specToken{}
+		if strings.HasPrefix(f, "[") && strings.HasSuffix(f, "]") {
+			if i != len(fields)-1 {
+				return nil, fmt.Errorf("only the last placeholder can be optional")
+			}
+			t.optional = true
+			f = f[1 : len(f)-1]
+		}
+
+		if !strings.HasPrefix(f, "<") {
+			if t.optional {
+				return nil, fmt.Errorf("literal %q can't be optional", f)
+			}
+			t.literal = strings.ToLower(f)
+			tokens = append(tokens, t)
+			continue
+		}
+
+		res := placeholderRegex.FindStringSubmatch(f)
+		if res == nil {
+			return nil, fmt.Errorf("can't parse placeholder %q", f)
+		}
+		t.name = res[1]
+		t.kind = res[2]
+		t.param = res[3]
+		if t.kind == "" {
+			t.kind = "word"
+		}
+
+		if err := t.parseParam(); err != nil {
+			return nil, err
+		}
+
+		if t.kind == "text" && i != len(fields)-1 {
+			return nil, fmt.Errorf("text placeholder %q must be last", t.name)
+		}
+		tokens = append(tokens, t)
+	}
+
+	if len(tokens) == 0 || tokens[0].literal == "" {
+		return nil, fmt.Errorf("must start with a literal word")
+	}
+	return tokens, nil
+}
+
+func (t *specToken) parseParam() error {
+	switch t.kind {
+	case "int":
+		if t.param == "" {
+			return nil
+		}
+		sp := strings.Split(t.param, "..")
+		if len(sp) != 2 {
+			return fmt.Errorf("int range for %q should be min..max", t.name)
+		}
+		min, err := strconv.Atoi(sp[0])
+		if err != nil {
+			return err
+		}
+		max, err := strconv.Atoi(sp[1])
+		if err != nil {
+			return err
+		}
+		t.min, t.max = int64(min), int64(max)
+		t.hasRange = true
+
+	case "duration":
+		if t.param == "" {
+			return nil
+		}
+		sp := strings.Split(t.param, "..")
+		if len(sp) != 2 {
+			return fmt.Errorf("duration range for %q should be min..max", t.name)
+		}
+		min, err := time.ParseDuration(sp[0])
+		if err != nil {
+			return err
+		}
+		max, err := time.ParseDuration(sp[1])
+		if err != nil {
+			return err
+		}
+		t.min, t.max = int64(min), int64(max)
+		t.hasRange = true
+
+	case "enum":
+		if t.param == "" {
+			return fmt.Errorf("enum %q needs values", t.name)
+		}
+		t.values = strings.Split(strings.ToLower(t.param), "|")
+
+	case "date", "env", "email", "word", "text":
+		if t.param != "" {
+			return fmt.Errorf("%s placeholder %q doesn't take a parameter", t.kind, t.name)
+		}
+
+	default:
+		return fmt.Errorf("unknown placeholder type %q", t.kind)
+	}
+	return nil
+}
+
+func (t specToken) usage() string {
+	if t.literal != "" {
+		return t.literal
+	}
+
+	u := "<" + t.name + ">"
+	if t.kind == "enum" {
+		u = "<" + strings.Join(t.values, "|") + ">"
+	}
+	if t.optional {
+		u = "[" + u + "]"
+	}
+	return u
+}
+
+var mailtoRegex = regexp.MustCompile(`^<mailto:(.*)\|(.*)>$`)
+
+// minsSuffixRegex lets durations be written as 30min or 30mins as well as 30m.
+var minsSuffixRegex = regexp.MustCompile(`(?i)^(\d+)\s*mins?$`)
+
+func (t specToken) parse(word string, c *Command) (interface{}, error) {
+	switch t.kind {
+	case "date":
+		d, err := time.Parse("2006-01-02", word)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be a date in YYYY-MM-DD format", t.name)
+		}
+		return d, nil
+
+	case "duration":
+		if res := minsSuffixRegex.FindStringSubmatch(word); res != nil {
+			word = res[1] + "m"
+		}
+		d, err := time.ParseDuration(strings.ToLower(word))
+		if err != nil || (t.hasRange && (int64(d) < t.min || int64(d) > t.max)) {
+			if t.hasRange {
+				return nil, fmt.Errorf("%s should be a duration between %s and %s", t.name, time.Duration(t.min), time.Duration(t.max))
+			}
+			return nil, fmt.Errorf("%s should be a duration like 30m or 2h", t.name)
+		}
+		return d, nil
+
+	case "int":
+		i, err := strconv.Atoi(word)
+		if err != nil || (t.hasRange && (int64(i) < t.min || int64(i) > t.max)) {
+			if t.hasRange {
+				return nil, fmt.Errorf("%s should be a number between %d and %d", t.name, t.min, t.max)
+			}
+			return nil, fmt.Errorf("%s should be a number", t.name)
+		}
+		return i, nil
+
+	case "env":
+		env := strings.ToLower(word)
+		envs := []string{}
+		if c.Envs != nil {
+			envs = c.Envs()
+		}
+		if !contains(env, envs) {
+			return nil, fmt.Errorf("%s should be one of: %s", t.name, strings.Join(envs, ", "))
+		}
+		return env, nil
+
+	case "email":
+		if res := mailtoRegex.FindStringSubmatch(word); res != nil {
+			word = res[2]
+		}
+		addr, err := mail.ParseAddress(word)
+		if err != nil {
+			return nil, fmt.Errorf("%s should be an email address", t.name)
+		}
+		return addr.Address, nil
+
+	case "enum":
+		v := strings.ToLower(word)
+		if !contains(v, t.values) {
+			return nil, fmt.Errorf("%s should be one of: %s", t.name, strings.Join(t.values, ", "))
+		}
+		return v, nil
+	}
+
+	return word, nil
+}
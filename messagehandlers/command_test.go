@@ -0,0 +1,132 @@
+package messagehandlers
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestCommandPlaceholders(t *testing.T) {
+	envs := func() []string { return []string{"prod", "test"} }
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	for _, tc := range []struct {
+		spec     string
+		msg      string
+		expected CommandArgs
+		err      string
+	}{
+		{"report from <start:date>", "report from 2026-09-01", CommandArgs{"start": date("2026-09-01")}, ""},
+		{"report from <start:date>", "report from 01/09/2026", nil, "start should be a date in YYYY-MM-DD format"},
+		{"check last <mins:int(5..60)> mins", "check last 30 mins", CommandArgs{"mins": 30}, ""},
+		{"check last <mins:int(5..60)> mins", "check last 90 mins", nil, "mins should be a number between 5 and 60"},
+		{"check last <mins:int>", "check last lots", nil, "mins should be a number"},
+		{"offset by <n:int(-10..0)>", "offset by -5", CommandArgs{"n": -5}, ""},
+		{"offset by <n:int(-10..0)>", "offset by 3", nil, "n should be a number between -10 and 0"},
+		{"offset by <n:int(-10..0)>", "offset by -11", nil, "n should be a number between -10 and 0"},
+		{"wait <d:duration>", "wait 90mins", CommandArgs{"d": 90 * time.Minute}, ""},
+		{"wait <d:duration(5m..1h)>", "wait 2h", nil, "d should be a duration between 5m0s and 1h0m0s"},
+		{"wait <d:duration(0s..1m)>", "wait 30s", CommandArgs{"d": 30 * time.Second}, ""},
+		{"check <env:env>", "check PROD", CommandArgs{"env": "prod"}, ""},
+		{"check <env:env>", "check staging", nil, "env should be one of: prod, test"},
+		{"invite <who:email>", "invite <mailto:a@example.com|a@example.com>", CommandArgs{"who": "a@example.com"}, ""},
+		{"invite <who:email>", "invite someone", nil, "who should be an email address"},
+		{"sb check <kind:enum(queue|topic)>", "sb check Topic", CommandArgs{"kind": "topic"}, ""},
+		{"sb check <kind:enum(queue|topic)>", "sb check bus", nil, "kind should be one of: queue, topic"},
+		{"restart <name>", "restart web01", CommandArgs{"name": "web01"}, ""},
+	} {
+		t.Run(tc.spec+" "+tc.msg, func(t *testing.T) {
+			c := NewCommand(tc.spec, "", noop)
+			c.Envs = envs
+			m := c.match(tc.msg)
+			if m == nil {
+				t.Fatal("expected a match")
+			}
+			if tc.err != "" {
+				if m.err == nil || m.err.Error() != tc.err {
+					t.Errorf("expected %q, got %v", tc.err, m.err)
+				}
+				return
+			}
+			if m.err != nil {
+				t.Fatal(m.err)
+			}
+			for k, v := range tc.expected {
+				if m.args[k] != v {
+					t.Errorf("%s : expected %v, got %v", k, v, m.args[k])
+				}
+			}
+		})
+	}
+}
+
+func TestCommandOptional(t *testing.T) {
+	c := NewCommand("sb check <kind:enum(queue|topic)> [<name:word>]", "", noop)
+
+	m := c.match("sb check queue")
+	if m == nil || m.err != nil || m.args.String("name") != "" {
+		t.Errorf("expected the optional name to be left out, got %+v", m)
+	}
+	m = c.match("sb check queue orders")
+	if m == nil || m.err != nil || m.args.String("name") != "orders" {
+		t.Errorf("expected name orders, got %+v", m)
+	}
+	m = c.match("sb check queue orders extra")
+	if m == nil || m.err == nil || !strings.Contains(m.err.Error(), `unexpected "extra"`) {
+		t.Errorf("expected extra words to be an error, got %+v", m)
+	}
+	if c.Usage() != "sb check <queue|topic> [<name>]" {
+		t.Errorf("unexpected usage %q", c.Usage())
+	}
+}
+
+func TestCommandText(t *testing.T) {
+	c := NewCommand("say <what:text>", "", noop)
+
+	m := c.match("say  hello there,   world ")
+	if m == nil || m.err != nil || m.args.String("what") != "hello there,   world" {
+		t.Errorf("expected text to swallow the rest, got %+v", m)
+	}
+	m = c.match("say")
+	if m == nil || m.err == nil || m.err.Error() != "missing <what>" {
+		t.Errorf("expected missing text, got %+v", m)
+	}
+}
+
+func TestCommandNoMatch(t *testing.T) {
+	c := NewCommand("check <env:env>", "", noop)
+	if m := c.match("status prod"); m != nil {
+		t.Errorf("expected no match, got %+v", m)
+	}
+
+	// matching the first word is enough to get a usage error.
+	m := NewCommand("report azurecosts from <start:date>", "", noop).match("report sendgrid")
+	if m == nil || m.err == nil || m.literals != 1 {
+		t.Errorf("expected a partial match, got %+v", m)
+	}
+}
+
+func TestBadSpecs(t *testing.T) {
+	for _, spec := range []string{
+		"<env:env> check",
+		"say <what:text> now",
+		"check [<a>] <b>",
+		"check [now]",
+		"check <n:int(5)>",
+		"check <n:int(a..b)>",
+		"check <d:duration(5..10)>",
+		"check <k:enum>",
+		"check <d:date(x)>",
+		"check <x:colour>",
+		"check <bad",
+	} {
+		t.Run(spec, func(t *testing.T) {
+			if _, err := parseSpec(spec); err == nil {
+				t.Errorf("expected %q to be rejected", spec)
+			}
+		})
+	}
+}
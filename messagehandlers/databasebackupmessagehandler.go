@@ -1,121 +1,114 @@
 package messagehandlers
 
 import (
-	"encoding/json"
-	"errors"
+	"context"
 	"fmt"
+	"github.com/kpfaulkner/wheatley/config"
 	"github.com/kpfaulkner/wheatley/helper"
-	"os"
-	"regexp"
+	"github.com/kpfaulkner/wheatley/logging"
+	"net"
 	"strings"
 	"time"
 )
 
-type DBConfig struct {
-	ExportSubscriptionID   string `json:"ExportSubscriptionID"`
-	ImportSubscriptionID   string `json:"ImportSubscriptionID"`
-	TenantID               string `json:"TenantID"`
-	ClientID               string `json:"ClientID"`
-	ClientSecret           string `json:"ClientSecret"`
-	ExportResourceGroup    string `json:"ExportResourceGroup"`
-	ImportResourceGroup    string `json:"ImportResourceGroup"`
-	StorageKey             string `json:"StorageKey"`
-	StorageURL             string `json:"StorageURL"`
-	SqlExportAdminLogin    string `json:"SqlExportAdminLogin"`
-	SqlExportAdminPassword string `json:"SqlExportAdminPassword"`
-	SqlImportAdminLogin    string `json:"SqlImportAdminLogin"`
-	SqlImportAdminPassword string `json:"SqlImportAdminPassword"`
-	AllowedUsers           string `json:"AllowedUsers"`
-	BackupPrefix           string `json:"BackupPrefix"`
-	ExportServerName       string `json:"ExportServerName"`
-	ImportServerName       string `json:"ImportServerName"`
-	DatabaseName           string `json:"DatabaseName"`
-	ImportStorageKey       string `json:"DatabaseName"`
-
-	AllowedUsersList []string
-}
-
 type DatabaseBackupMessageHandler struct {
-	asHelper *helper.AzureSQLHelper
+	asHelper   *helper.AzureSQLHelper
+	operations *DBOperationTracker
 
 	// config specific to test LPC.
-	config *DBConfig
+	config *config.DatabaseConfig
 }
 
-func NewDatabaseBackupMessageHandler() *DatabaseBackupMessageHandler {
+func NewDatabaseBackupMessageHandler(config config.DatabaseConfig, arm *helper.ARMClient) *DatabaseBackupMessageHandler {
 	asHandler := DatabaseBackupMessageHandler{}
-	asHandler.config, _ = loadDBConfig("azuredb.json")
-	asHandler.asHelper = helper.NewAzureSQLHelper(asHandler.config.ImportSubscriptionID, asHandler.config.ExportSubscriptionID, asHandler.config.TenantID, asHandler.config.ClientID,
-		asHandler.config.ClientSecret, asHandler.config.SqlExportAdminLogin, asHandler.config.SqlExportAdminPassword,
-		asHandler.config.SqlImportAdminLogin, asHandler.config.SqlImportAdminPassword,
-		asHandler.config.StorageKey, asHandler.config.StorageURL, asHandler.config.ExportResourceGroup, asHandler.config.ImportResourceGroup, asHandler.config.ImportStorageKey)
+	asHandler.config = &config
+	asHandler.operations = NewDBOperationTracker()
+	asHandler.asHelper = helper.NewAzureSQLHelper(arm, config.ImportSubscriptionID, config.ExportSubscriptionID, config.SqlExportAdminLogin, config.SqlExportAdminPassword,
+		config.SqlImportAdminLogin, config.SqlImportAdminPassword,
+		config.StorageKey, config.StorageURL, config.ExportResourceGroup, config.ImportResourceGroup, config.ImportStorageKey)
 	return &asHandler
 }
 
-func loadDBConfig(filename string) (*DBConfig, error) {
-	configFile, err := os.Open(filename)
-	defer configFile.Close()
-	if err != nil {
-		return nil, err
-	}
-
-	config := DBConfig{}
-	jsonParser := json.NewDecoder(configFile)
-	jsonParser.Decode(&config)
-
-	// split users for later checking.
-	config.AllowedUsersList = strings.Split(config.AllowedUsers, ",")
-	return &config, nil
+func (ss *DatabaseBackupMessageHandler) Name() string {
+	return "DatabaseBackupMessageHandler"
 }
 
-func userAllowed(user string, allowedUsers []string) bool {
-	lowerUser := strings.ToLower(user)
-	for _, au := range allowedUsers {
-		if strings.ToLower(au) == lowerUser {
-			return true
-		}
+func (ss *DatabaseBackupMessageHandler) Commands() []*Command {
+	backup := NewProgressCommand("backup prod", "Starts backing up production database to blob storage, and says in the thread when it's done.", "Starting backup...", ss.backupProd)
+	backup.Mutating = true
+	status := NewContextCommand("backup status", "Lists database backups and imports in progress and recently finished.", ss.backupStatus)
+
+	// these overwrite things, so need someone else to approve them.
+	restore := NewContextCommand("import <database:word> from <backup:word>", "Imports a backup from blob storage into a new database on the import server.", ss.importDB)
+	firewall := NewContextCommand("set firewall <rule:word> on <server:word> to <ip:word>", "Points a SQL firewall rule on the export server's resource group at a new IP.", ss.setFirewall)
+	for _, c := range []*Command{restore, firewall} {
+		c.Mutating = true
+		c.RequiresApproval = true
 	}
-
-	return false
+	return []*Command{backup, status, restore, firewall}
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (ss *DatabaseBackupMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	// cant be arsed extracting out term.
-
-	backupProdRegex := regexp.MustCompile(`^backup prod`)
-	soundOffRegex := regexp.MustCompile(`sound off`)
-	helpRegex := regexp.MustCompile(`^help$`)
-
-	msg = strings.ToLower(msg)
-	switch {
-
-	case backupProdRegex.MatchString(msg):
-		res := backupProdRegex.FindStringSubmatch(msg)
-		if res != nil {
+func (ss *DatabaseBackupMessageHandler) backupProd(ctx context.Context, req *Request) (MessageResponse, error) {
+	backupName := fmt.Sprintf("%s-%s.bacpac", ss.config.BackupPrefix, time.Now().Format("2006-01-02"))
+	op, err := ss.asHelper.StartDBExport(ctx, ss.config.ExportServerName, ss.config.DatabaseName, backupName)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("unable to back up %s : %s", ss.config.DatabaseName, err.Error())
+		req.Progress("Cannot backup database!!")
+		return NewTextMessageResponse(fmt.Sprintf("Cannot backup database!! %s", err.Error())), nil
+	}
 
-			if !userAllowed(user, ss.config.AllowedUsersList) {
-				return NewTextMessageResponse("Sorry not permitted to do this."), nil
-			}
+	info := DBOperation{Kind: "Backup", Database: ss.config.DatabaseName, Blob: backupName, User: req.User}
+	req.Progress(fmt.Sprintf("%s : started", info.describe()))
+	ss.operations.Track(ctx, op, info, req.Progress, req.Reply)
+	return NewTextMessageResponse(fmt.Sprintf("Have started backing up %s to %s. I'll reply here when it's done, or see `backup status`.", ss.config.DatabaseName, backupName)), nil
+}
 
-			backupName := fmt.Sprintf("%s-%s.bacpac", ss.config.BackupPrefix, time.Now().Format("2006-01-02"))
-			err := ss.asHelper.StartDBExport(ss.config.ExportServerName, ss.config.DatabaseName, backupName)
-			if err != nil {
-				return NewTextMessageResponse("Cannot backup database!!\n"), nil
-			}
+func (ss *DatabaseBackupMessageHandler) backupStatus(ctx context.Context, req *Request) (MessageResponse, error) {
+	ops := ss.operations.Operations()
+	if len(ops) == 0 {
+		return NewTextMessageResponse("No backups or imports since I started."), nil
+	}
 
-			return NewTextMessageResponse("Have started backup. There is no indication of when it will complete though."), nil
+	lines := []string{}
+	for _, op := range ops {
+		switch {
+		case !op.Done():
+			lines = append(lines, fmt.Sprintf("%s : %s, running for %s (started by %s)", op.describe(), op.Status, op.Duration(), op.User.Name))
+		case op.Err != nil:
+			lines = append(lines, fmt.Sprintf("%s : failed after %s, %s", op.describe(), op.Duration(), op.Err.Error()))
+		default:
+			lines = append(lines, fmt.Sprintf("%s : finished %s, took %s", op.describe(), op.Finished.Format("2006-01-02 15:04 MST"), op.Duration()))
 		}
+	}
+	return NewTextMessageResponse(strings.Join(lines, "\n")), nil
+}
 
-	case soundOffRegex.MatchString(msg):
-		return NewTextMessageResponse("DatabaseBackupMessageHandler reporting for duty"), nil
+func (ss *DatabaseBackupMessageHandler) importDB(ctx context.Context, req *Request) (MessageResponse, error) {
+	database := req.Args.String("database")
+	backup := req.Args.String("backup")
+	op, err := ss.asHelper.StartDBImport(ctx, ss.config.ImportServerName, database, backup)
+	if err != nil {
+		logging.FromContext(ctx).Errorf("unable to import %s : %s", database, err.Error())
+		return NewTextMessageResponse(fmt.Sprintf("Cannot import database %s!!", database)), nil
+	}
+
+	// imports need approval, so there's no acknowledgement to update, just the replies.
+	ss.operations.Track(ctx, op, DBOperation{Kind: "Import", Database: database, Blob: backup, User: req.User}, req.Progress, req.Reply)
+	return NewTextMessageResponse(fmt.Sprintf("Have started importing %s from %s. I'll reply here when it's done, or see `backup status`.", database, backup)), nil
+}
 
-	case helpRegex.MatchString(msg):
-		return NewTextMessageResponse("backup prod: Starts backing up production database to blob storage."), nil
+func (ss *DatabaseBackupMessageHandler) setFirewall(ctx context.Context, req *Request) (MessageResponse, error) {
+	ip := net.ParseIP(req.Args.String("ip"))
+	if ip == nil || ip.To4() == nil {
+		return NewTextMessageResponse(fmt.Sprintf("%s isn't an IPv4 address", req.Args.String("ip"))), nil
+	}
 
+	rule := req.Args.String("rule")
+	err := ss.asHelper.UpdateSQLFirewall(ctx, ss.config.ExportSubscriptionID, req.Args.String("server"), ss.config.ExportResourceGroup, rule, ip.String())
+	if err != nil {
+		logging.FromContext(ctx).Errorf("unable to update firewall rule %s : %s", rule, err.Error())
+		return NewTextMessageResponse(fmt.Sprintf("Cannot update firewall rule %s!!", rule)), nil
 	}
-	return NewTextMessageResponse(""), errors.New("No match")
 
+	return NewTextMessageResponse(fmt.Sprintf("Firewall rule %s now allows %s.", rule, ip.String())), nil
 }
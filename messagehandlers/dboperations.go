@@ -0,0 +1,187 @@
+package messagehandlers
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/helper"
+	"github.com/kpfaulkner/wheatley/logging"
+)
+
+const (
+	// how many finished operations backup status remembers.
+	defaultKeepFinished = 10
+
+	// exports of big databases can take hours, but not this long.
+	defaultMaxWatch = 12 * time.Hour
+
+	// how many polls in a row can fail before giving up on an operation.
+	maxPollFailures = 10
+)
+
+// DBOperation is a database export or import being watched until it's done.
+type DBOperation struct {
+	Kind     string // "Backup" or "Import".
+	Database string
+	Blob     string
+	User     User
+
+	Started  time.Time
+	Finished time.Time // zero until it's done.
+	Status   string    // what SQL last said, eg "Running, Progress = 45%".
+	Err      error     // set if it failed, or couldn't be watched.
+}
+
+// Done is true once the operation finished, one way or another.
+func (o DBOperation) Done() bool {
+	return !o.Finished.IsZero()
+}
+
+// Duration is how long it took, or has taken so far.
+func (o DBOperation) Duration() time.Duration {
+	end := o.Finished
+	if end.IsZero() {
+		end = time.Now()
+	}
+	return end.Sub(o.Started).Round(time.Second)
+}
+
+func (o DBOperation) describe() string {
+	return fmt.Sprintf("%s of %s (%s)", o.Kind, o.Database, o.Blob)
+}
+
+// DBOperationTracker polls exports and imports in the background, reporting progress and
+// the result back to whoever started them.
+type DBOperationTracker struct {
+	keepFinished int
+	maxWatch     time.Duration
+
+	lock sync.Mutex
+	ops  []*DBOperation
+}
+
+func NewDBOperationTracker() *DBOperationTracker {
+	t := DBOperationTracker{}
+	t.keepFinished = defaultKeepFinished
+	t.maxWatch = defaultMaxWatch
+	return &t
+}
+
+// Track watches op until it's done. Progress updates go to progress, and the result is
+// posted with reply. ctx is only used for its logger, the command's ctx will be cancelled
+// long before an export finishes.
+func (t *DBOperationTracker) Track(ctx context.Context, op *helper.Operation, info DBOperation, progress ProgressFunc, reply ReplyFunc) {
+	dbOp := info
+	dbOp.Started = time.Now()
+	dbOp.Status, _ = helper.ImportExportStatus(op)
+
+	t.lock.Lock()
+	t.ops = append(t.ops, &dbOp)
+	t.lock.Unlock()
+
+	watchCtx, cancel := context.WithTimeout(logging.NewContext(context.Background(), logging.FromContext(ctx)), t.maxWatch)
+	go func() {
+		defer cancel()
+		t.watch(watchCtx, op, &dbOp, progress, reply)
+	}()
+}
+
+func (t *DBOperationTracker) watch(ctx context.Context, op *helper.Operation, dbOp *DBOperation, progress ProgressFunc, reply ReplyFunc) {
+	log := logging.FromContext(ctx)
+	failures := 0
+	for !op.Done() {
+		select {
+		case <-time.After(op.NextPoll()):
+		case <-ctx.Done():
+			t.finish(dbOp, dbOp.Status, fmt.Errorf("stopped watching after %s, check the portal", t.maxWatch))
+			t.report(ctx, dbOp, progress, reply)
+			return
+		}
+
+		if err := op.Poll(ctx); err != nil {
+			failures++
+			log.Warnf("unable to check %s : %s", dbOp.describe(), err.Error())
+			if failures < maxPollFailures {
+				continue
+			}
+			t.finish(dbOp, dbOp.Status, fmt.Errorf("unable to check on it : %s", err.Error()))
+			t.report(ctx, dbOp, progress, reply)
+			return
+		}
+		failures = 0
+
+		status, _ := helper.ImportExportStatus(op)
+		t.lock.Lock()
+		changed := status != dbOp.Status
+		dbOp.Status = status
+		t.lock.Unlock()
+		if changed && !op.Done() {
+			progress(fmt.Sprintf("%s : %s (%s so far)", dbOp.describe(), status, dbOp.Duration()))
+		}
+	}
+
+	status, err := helper.ImportExportStatus(op)
+	t.finish(dbOp, status, err)
+	t.report(ctx, dbOp, progress, reply)
+}
+
+func (t *DBOperationTracker) finish(dbOp *DBOperation, status string, err error) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	dbOp.Finished = time.Now()
+	dbOp.Status = status
+	dbOp.Err = err
+	t.prune()
+}
+
+// prune forgets the oldest finished operations, must be called with the lock held.
+func (t *DBOperationTracker) prune() {
+	finished := 0
+	for i := len(t.ops) - 1; i >= 0; i-- {
+		if !t.ops[i].Done() {
+			continue
+		}
+		finished++
+		if finished > t.keepFinished {
+			t.ops = append(t.ops[:i], t.ops[i+1:]...)
+		}
+	}
+}
+
+// report updates the progress message and replies with how it went, so people watching the
+// thread get notified.
+func (t *DBOperationTracker) report(ctx context.Context, dbOp *DBOperation, progress ProgressFunc, reply ReplyFunc) {
+	t.lock.Lock()
+	op := *dbOp
+	t.lock.Unlock()
+
+	text := fmt.Sprintf("%s finished after %s.", op.describe(), op.Duration())
+	if op.Err != nil {
+		text = fmt.Sprintf("%s failed after %s : %s", op.describe(), op.Duration(), op.Err.Error())
+	}
+	progress(text)
+	if err := reply(NewTextMessageResponse(fmt.Sprintf("%s %s", mention(op.User), text))); err != nil {
+		logging.FromContext(ctx).Errorf("unable to reply about %s : %s", op.describe(), err.Error())
+	}
+}
+
+// Operations is everything in progress, then what finished recently, newest first.
+func (t *DBOperationTracker) Operations() []DBOperation {
+	t.lock.Lock()
+	ops := []DBOperation{}
+	for _, op := range t.ops {
+		ops = append(ops, *op)
+	}
+	t.lock.Unlock()
+
+	sort.SliceStable(ops, func(i, j int) bool {
+		if ops[i].Done() != ops[j].Done() {
+			return !ops[i].Done()
+		}
+		return ops[i].Started.After(ops[j].Started)
+	})
+	return ops
+}
@@ -0,0 +1,175 @@
+package messagehandlers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/helper"
+)
+
+type fakeCredential struct{}
+
+func (fakeCredential) ID() string {
+	return "fake"
+}
+
+func (fakeCredential) NewToken(ctx context.Context, resource string) (*helper.AzureAuthToken, error) {
+	return &helper.AzureAuthToken{AccessToken: "token", ExpiresOnTime: time.Now().Add(time.Hour)}, nil
+}
+
+// sqlStatusServer answers polls for an import or export with each of statuses in turn, the
+// last one with a 200 so the operation is done.
+func sqlStatusServer(statuses ...string) (*httptest.Server, *helper.Operation) {
+	var polls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&polls, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		if i < len(statuses)-1 {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		fmt.Fprint(w, statuses[i])
+	}))
+	return server, testOperation(server)
+}
+
+// testOperation polls server for the result of an import or export.
+func testOperation(server *httptest.Server) *helper.Operation {
+	cloud := helper.AzurePublicCloud
+	cloud.ResourceManager = server.URL
+	arm := helper.NewARMClient(helper.NewCredentialManager().Source(fakeCredential{}), cloud)
+	arm.PollInterval = time.Millisecond
+	return arm.ResumeOperation(server.URL+"/importExportOperationResults/1", false)
+}
+
+// trackerRecorder keeps the progress updates and replies for a tracked operation.
+type trackerRecorder struct {
+	lock     sync.Mutex
+	progress []string
+	replies  chan string
+}
+
+func newTrackerRecorder() *trackerRecorder {
+	return &trackerRecorder{replies: make(chan string, 1)}
+}
+
+func (r *trackerRecorder) Progress(text string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.progress = append(r.progress, text)
+}
+
+func (r *trackerRecorder) Reply(msg MessageResponse) error {
+	r.replies <- msg.(TextMessageResponse).Message
+	return nil
+}
+
+func (r *trackerRecorder) wait(t *testing.T) string {
+	select {
+	case reply := <-r.replies:
+		return reply
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reply once the operation finished")
+	}
+	return ""
+}
+
+func TestDBOperationTracker(t *testing.T) {
+	server, op := sqlStatusServer(
+		`{"properties":{"status":"Running, Progress = 45%"}}`,
+		`{"properties":{"status":"Running, Progress = 45%"}}`,
+		`{"properties":{"status":"Running, Progress = 90%"}}`,
+		`{"properties":{"status":"Completed"}}`)
+	defer server.Close()
+
+	tracker := NewDBOperationTracker()
+	r := newTrackerRecorder()
+	info := DBOperation{Kind: "Backup", Database: "prod", Blob: "prod.bacpac", User: User{ID: "U1", Name: "alice"}}
+	tracker.Track(context.Background(), op, info, r.Progress, r.Reply)
+
+	reply := r.wait(t)
+	if !strings.HasPrefix(reply, "<@U1> Backup of prod (prod.bacpac) finished after") {
+		t.Errorf("unexpected reply %q", reply)
+	}
+
+	r.lock.Lock()
+	progress := r.progress
+	r.lock.Unlock()
+	if len(progress) != 3 {
+		t.Fatalf("expected progress for 45%%, 90%% and finishing, got %q", progress)
+	}
+	if !strings.Contains(progress[0], "Running, Progress = 45%") || !strings.Contains(progress[1], "Running, Progress = 90%") {
+		t.Errorf("unexpected progress %q", progress)
+	}
+
+	ops := tracker.Operations()
+	if len(ops) != 1 || !ops[0].Done() || ops[0].Status != "Completed" || ops[0].Err != nil {
+		t.Errorf("expected a completed operation, got %+v", ops)
+	}
+}
+
+func TestDBOperationTrackerFailed(t *testing.T) {
+	server, op := sqlStatusServer(`{"properties":{"status":"Failed","errorMessage":"login failed for user"}}`)
+	defer server.Close()
+
+	tracker := NewDBOperationTracker()
+	r := newTrackerRecorder()
+	tracker.Track(context.Background(), op, DBOperation{Kind: "Import", Database: "test", Blob: "prod.bacpac"}, r.Progress, r.Reply)
+
+	reply := r.wait(t)
+	if !strings.Contains(reply, "Import of test (prod.bacpac) failed after") || !strings.Contains(reply, "login failed for user") {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	ops := tracker.Operations()
+	if len(ops) != 1 || ops[0].Err == nil {
+		t.Errorf("expected a failed operation, got %+v", ops)
+	}
+}
+
+func TestDBOperationTrackerGivesUp(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+	op := testOperation(server)
+
+	tracker := NewDBOperationTracker()
+	tracker.maxWatch = 20 * time.Millisecond
+	r := newTrackerRecorder()
+	tracker.Track(context.Background(), op, DBOperation{Kind: "Backup", Database: "prod"}, r.Progress, r.Reply)
+
+	if reply := r.wait(t); !strings.Contains(reply, "stopped watching") {
+		t.Errorf("unexpected reply %q", reply)
+	}
+}
+
+func TestDBOperationTrackerOperations(t *testing.T) {
+	tracker := NewDBOperationTracker()
+	tracker.keepFinished = 2
+
+	now := time.Now()
+	for i := 0; i < 4; i++ {
+		started := now.Add(time.Duration(i) * time.Minute)
+		tracker.ops = append(tracker.ops, &DBOperation{Database: fmt.Sprintf("done%d", i), Started: started, Finished: started.Add(time.Second)})
+	}
+	tracker.ops = append(tracker.ops, &DBOperation{Database: "running", Started: now.Add(-time.Hour)})
+	tracker.lock.Lock()
+	tracker.prune()
+	tracker.lock.Unlock()
+
+	names := []string{}
+	for _, op := range tracker.Operations() {
+		names = append(names, op.Database)
+	}
+	if strings.Join(names, ",") != "running,done3,done2" {
+		t.Errorf("expected running then the 2 newest finished, got %v", names)
+	}
+}
@@ -0,0 +1,49 @@
+package messagehandlers
+
+import (
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/slack-go/slack"
+)
+
+// EventFilter is the first stage of the pipeline. Stops Wheatley answering itself,
+// other bots or edits of messages it has already answered.
+type EventFilter struct {
+	config config.FilterConfig
+
+	// who we are, from auth.test
+	selfUserID string
+	selfBotID  string
+}
+
+func NewEventFilter(filter config.FilterConfig, selfUserID string, selfBotID string) *EventFilter {
+	f := EventFilter{}
+	f.config = filter
+	f.selfUserID = selfUserID
+	f.selfBotID = selfBotID
+	return &f
+}
+
+// Allow returns false, and the reason, if the message should be dropped.
+func (f *EventFilter) Allow(msg IncomingMessage) (bool, string) {
+	if f.config.IgnoreSelf && ((f.selfUserID != "" && msg.User == f.selfUserID) || (f.selfBotID != "" && msg.BotID == f.selfBotID)) {
+		return false, "own message"
+	}
+
+	if f.config.IgnoreBots && (msg.BotID != "" || msg.SubType == slack.MsgSubTypeBotMessage) {
+		return false, "bot message"
+	}
+
+	if f.config.IgnoreEdits && (msg.Edited || msg.SubType == slack.MsgSubTypeMessageChanged) {
+		return false, "edited message"
+	}
+
+	if f.config.IgnoreThreadBroadcasts && msg.SubType == slack.MsgSubTypeThreadBroadcast {
+		return false, "thread broadcast"
+	}
+
+	if msg.SubType != "" && contains(msg.SubType, f.config.IgnoredSubTypes) {
+		return false, "ignored subtype " + msg.SubType
+	}
+
+	return true, ""
+}
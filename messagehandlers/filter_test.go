@@ -0,0 +1,61 @@
+package messagehandlers
+
+import (
+	"testing"
+
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/slack-go/slack"
+)
+
+func TestEventFilter(t *testing.T) {
+	f := NewEventFilter(config.DefaultFilterConfig(), "U0BOT0000", "B0BOT0000")
+
+	tests := []struct {
+		name   string
+		msg    IncomingMessage
+		allow  bool
+		reason string
+	}{
+		{"person", IncomingMessage{User: "U012AB3CD", Text: "hello"}, true, ""},
+		{"threaded reply", IncomingMessage{User: "U012AB3CD", ThreadTS: "1600000000.000100"}, true, ""},
+		{"own user", IncomingMessage{User: "U0BOT0000"}, false, "own message"},
+		{"own bot", IncomingMessage{BotID: "B0BOT0000"}, false, "own message"},
+		{"other bot", IncomingMessage{BotID: "B0OTHER00"}, false, "bot message"},
+		{"bot subtype", IncomingMessage{SubType: slack.MsgSubTypeBotMessage}, false, "bot message"},
+		{"edited", IncomingMessage{User: "U012AB3CD", Edited: true}, false, "edited message"},
+		{"message changed", IncomingMessage{User: "U012AB3CD", SubType: slack.MsgSubTypeMessageChanged}, false, "edited message"},
+		{"thread broadcast", IncomingMessage{User: "U012AB3CD", SubType: slack.MsgSubTypeThreadBroadcast}, false, "thread broadcast"},
+		{"channel join", IncomingMessage{User: "U012AB3CD", SubType: slack.MsgSubTypeChannelJoin}, false, "ignored subtype channel_join"},
+		{"unknown subtype", IncomingMessage{User: "U012AB3CD", SubType: "file_share"}, true, ""},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			allow, reason := f.Allow(tc.msg)
+			if allow != tc.allow || reason != tc.reason {
+				t.Errorf("expected %v %q, got %v %q", tc.allow, tc.reason, allow, reason)
+			}
+		})
+	}
+}
+
+func TestEventFilterDisabled(t *testing.T) {
+	f := NewEventFilter(config.FilterConfig{}, "U0BOT0000", "B0BOT0000")
+
+	for _, msg := range []IncomingMessage{
+		{User: "U0BOT0000"},
+		{BotID: "B0OTHER00"},
+		{User: "U012AB3CD", Edited: true},
+		{User: "U012AB3CD", SubType: slack.MsgSubTypeThreadBroadcast},
+	} {
+		if allow, reason := f.Allow(msg); !allow {
+			t.Errorf("%+v shouldn't be dropped with nothing ignored, got %q", msg, reason)
+		}
+	}
+
+	// without knowing who we are, only the bot check can spot our own messages.
+	f = NewEventFilter(config.DefaultFilterConfig(), "", "")
+	if allow, _ := f.Allow(IncomingMessage{User: "U012AB3CD"}); !allow {
+		t.Error("people shouldn't be dropped")
+	}
+}
@@ -0,0 +1,67 @@
+package messagehandlers
+
+import (
+	"fmt"
+
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/kpfaulkner/wheatley/helper"
+	"github.com/kpfaulkner/wheatley/logging"
+)
+
+// NewMessageHandlers creates every handler the config enables. Misc and server status are
+// always there, the rest only if their section is in the config. Everything talking to Azure
+// shares the config's credential manager, so tokens are cached and refreshed in one place.
+func NewMessageHandlers(cfg *config.Config) ([]MessageHandler, error) {
+	handlers := []MessageHandler{
+		NewMiscMessageHandler(),
+		NewServerStatusMessageHandler(cfg.Files.State),
+	}
+
+	if cfg.AzureMonitoring != nil {
+		// one set of credentials per env.
+		arm := make(map[string]*helper.ARMClient)
+		for i, am := range cfg.AzureMonitoring.AzureMonitor {
+			client, err := cfg.ARMClient(fmt.Sprintf("AzureMonitoring.AzureMonitor[%d]", i), am.AzureCredentialConfig)
+			if err != nil {
+				return nil, err
+			}
+			arm[am.Name] = client
+		}
+		handlers = append(handlers, NewAzureStatusMessageHandler(*cfg.AzureMonitoring, arm, cfg.Cloud.ApplicationInsights))
+	}
+	if cfg.Database != nil {
+		arm, err := cfg.ARMClient("Database", cfg.Database.AzureCredentialConfig)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, NewDatabaseBackupMessageHandler(*cfg.Database, arm))
+	}
+	if cfg.AzureCosts != nil {
+		arm, err := cfg.ARMClient("AzureCosts", cfg.AzureCosts.AzureCredentialConfig)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, NewAzureCostMessageHandler(*cfg.AzureCosts, arm))
+	}
+	if cfg.AzureShutdown != nil {
+		arm, err := cfg.ARMClient("AzureShutdown", cfg.AzureShutdown.AzureCredentialConfig)
+		if err != nil {
+			return nil, err
+		}
+		handlers = append(handlers, NewAzureShutdownMessageHandler(*cfg.AzureShutdown, arm))
+	}
+	if cfg.AzureStorage != nil {
+		handlers = append(handlers, NewAzureStorageMessageHandler(*cfg.AzureStorage))
+	}
+	if cfg.SendGrid != nil {
+		handlers = append(handlers, NewSendgridMessageHandler(*cfg.SendGrid))
+	}
+	if cfg.ServiceBus != nil {
+		handlers = append(handlers, NewServiceBusMessageHandler(*cfg.ServiceBus))
+	}
+
+	for _, h := range handlers {
+		logging.Infof("enabled %s", h.Name())
+	}
+	return handlers, nil
+}
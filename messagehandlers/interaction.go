@@ -0,0 +1,178 @@
+package messagehandlers
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+// kinds of interaction we handle.
+const (
+	BlockActionInteraction    = "block_actions"   // a button (or other block element) was clicked.
+	ViewSubmissionInteraction = "view_submission" // a modal was submitted.
+)
+
+// Interaction is a button click or modal submission, regardless of whether it came in over
+// Socket Mode or the interactivity endpoint.
+type Interaction struct {
+	Type string
+
+	// ActionID is the action_id of the button, or the callback_id of the modal.
+	ActionID string
+	Value    string
+
+	User    string // Slack user ID.
+	Channel string
+
+	// MessageTS is the message the button was on, ThreadTS the thread that message is in (if any).
+	// For modals they're wherever the modal was opened from.
+	MessageTS string
+	ThreadTS  string
+
+	// TriggerID is needed to open a modal, and is only good for 3 seconds.
+	TriggerID   string
+	ResponseURL string
+
+	// Values are the modal inputs, keyed by action_id.
+	Values map[string]string
+
+	Transport string // one of the Transport constants, set by whoever received the interaction.
+}
+
+// ThreadRoot is the timestamp to reply to when replying in a thread.
+func (in Interaction) ThreadRoot() string {
+	if in.ThreadTS != "" {
+		return in.ThreadTS
+	}
+	return in.MessageTS
+}
+
+// Args are what the handler gets in Request.Args. The button value is "value", modal inputs
+// are keyed by their action_id.
+func (in Interaction) Args() CommandArgs {
+	args := CommandArgs{"value": in.Value}
+	for k, v := range in.Values {
+		args[k] = v
+	}
+	return args
+}
+
+// NewInteractionFromCallback converts a block_actions or view_submission payload.
+// Returns false for anything else, or a block_actions without any actions.
+func NewInteractionFromCallback(cb *slack.InteractionCallback) (Interaction, bool) {
+	in := Interaction{
+		Type:        string(cb.Type),
+		User:        cb.User.ID,
+		TriggerID:   cb.TriggerID,
+		ResponseURL: cb.ResponseURL,
+		Transport:   TransportEventsAPI,
+	}
+
+	switch cb.Type {
+	case slack.InteractionTypeBlockActions:
+		if len(cb.ActionCallback.BlockActions) == 0 {
+			return in, false
+		}
+
+		// can only click one thing at a time.
+		action := cb.ActionCallback.BlockActions[0]
+		in.ActionID = action.ActionID
+		in.Value = blockActionValue(*action)
+		in.Channel = cb.Channel.ID
+		if in.Channel == "" {
+			in.Channel = cb.Container.ChannelID
+		}
+		in.MessageTS = cb.Container.MessageTs
+		in.ThreadTS = cb.Container.ThreadTs
+		if in.ThreadTS == "" {
+			in.ThreadTS = cb.Message.ThreadTimestamp
+		}
+		return in, true
+
+	case slack.InteractionTypeViewSubmission:
+		in.ActionID = cb.View.CallbackID
+		in.Channel, in.ThreadTS = parseModalMetadata(cb.View.PrivateMetadata)
+		in.Values = map[string]string{}
+		if cb.View.State != nil {
+			for _, block := range cb.View.State.Values {
+				for actionID, action := range block {
+					in.Values[actionID] = blockActionValue(action)
+				}
+			}
+		}
+		return in, true
+	}
+	return in, false
+}
+
+// blockActionValue is whatever was picked, regardless of the type of element.
+func blockActionValue(action slack.BlockAction) string {
+	switch {
+	case action.SelectedDate != "":
+		return action.SelectedDate
+	case action.SelectedTime != "":
+		return action.SelectedTime
+	case action.SelectedOption.Value != "":
+		return action.SelectedOption.Value
+	case action.SelectedUser != "":
+		return action.SelectedUser
+	case action.SelectedChannel != "":
+		return action.SelectedChannel
+	}
+	return action.Value
+}
+
+// modals don't know where they were opened from, so the responder stashes the channel and
+// thread in the private metadata.
+func modalMetadata(channel string, threadTS string) string {
+	return channel + "|" + threadTS
+}
+
+func parseModalMetadata(metadata string) (string, string) {
+	sp := strings.SplitN(metadata, "|", 2)
+	if len(sp) != 2 {
+		return metadata, ""
+	}
+	return sp[0], sp[1]
+}
+
+// Action is what a handler does when one of its buttons is clicked or its modal is submitted.
+type Action struct {
+	// ID is the action_id of the button, or callback_id of the modal. Must be unique across handlers.
+	ID     string
+	Handle HandlerFunc
+
+	// Timeout for the context passed to Handle. Zero means DefaultCommandTimeout.
+	Timeout time.Duration
+
+	// Command the action stands in for, eg the check command for a re-run button. If set the
+	// user needs to be allowed to run the command to use the action.
+	Command *Command
+
+	// Ack is posted as soon as the action starts, same as Command.Ack.
+	Ack string
+
+	handler string // name of the handler that registered the action.
+}
+
+func NewAction(id string, handle HandlerFunc) *Action {
+	a := Action{}
+	a.ID = id
+	a.Handle = handle
+	return &a
+}
+
+// Execute runs the action with a context that's cancelled once the action's timeout is up.
+func (a *Action) Execute(ctx context.Context, req *Request) (MessageResponse, error) {
+	return runHandler(ctx, a.Timeout, a.Handle, req)
+}
+
+// InteractiveHandler is a MessageHandler that also has buttons or modals.
+type InteractiveHandler interface {
+	MessageHandler
+
+	// Actions returns the actions the handler answers to.
+	Actions() []*Action
+}
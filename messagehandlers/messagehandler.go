@@ -1,15 +1,10 @@
 package messagehandlers
 
-import (
-	"fmt"
-	"github.com/slack-go/slack"
-	"strings"
-	"time"
-)
-
 const (
-	TextMessageType int = 1 // just returning a text message.
-	FileMessageType int = 2 // File
+	TextMessageType   int = 1 // just returning a text message.
+	FileMessageType   int = 2 // File
+	BlocksMessageType int = 3 // Block Kit message, see BlocksMessageResponse
+	ModalMessageType  int = 4 // opens a modal, see ModalMessageResponse
 )
 
 type MessageResponse interface {
@@ -68,55 +63,14 @@ func NewFileMessageResponse(details []FileDetails) FileMessageResponse {
 	return fm
 }
 
-// MessageHandler takes a message, parses it and determines what it should do.
-// Returns the MessageResponse (which could be text, or a file?) that should be returned to the user.
+// MessageHandler declares the commands it answers to. The Router decides which single
+// command gets a message, the command returns the MessageResponse (which could be text, or a file?)
+// that should be returned to the user.
 type MessageHandler interface {
 
-	// ParseMessage takes a message, determines what to do
-	// return the text that should go to the user.
-	ParseMessage(msg string, user string) (MessageResponse, error)
-}
-
-func ProcessMessageResponse(msg MessageResponse, channel string, api *slack.Client, rtm *slack.RTM) error {
-
-	// could just use type assertions, but will stick with this for now.
-	switch msg.GetMessageResponseType() {
-	case TextMessageType:
-		textMessage := msg.(TextMessageResponse)
-		sp := strings.Split(textMessage.Message, "\n")
-
-		// slows things down.. but stops being blocked by Slack.
-		// If we send responses > 5000 bytes, then Slack will also block it.
-		for _, line := range sp {
-			rtm.SendMessage(rtm.NewOutgoingMessage(line, channel))
-			<-time.After(2 * time.Second)
-		}
-
-	case FileMessageType:
-		fileMessage := msg.(FileMessageResponse)
-
-		// loop through all files.
-		for _, details := range fileMessage.Details {
-
-			params := slack.FileUploadParameters{
-				Title:    details.Title,
-				Filetype: details.FileType,
-				//File: fileMessage.FileName,
-				Filename: details.FileName,
-				Content:  string(details.Contents), // should fileMesage.Contents just be string to begin with?
-			}
-
-			file, err := api.UploadFile(params)
-			if err != nil {
-				fmt.Printf("%s\n", err)
-				return err
-			}
-			fmt.Printf("Name: %s, URL: %s\n", file.Name, file.URLPrivateDownload)
-			rtm.SendMessage(rtm.NewOutgoingMessage(fmt.Sprintf("file %s is at %s", file.Name, file.Permalink), channel))
+	// Name identifies the handler in sound off replies and ambiguous matches.
+	Name() string
 
-			// aftificial delay, just incase slack gets grumpy at us.
-			<- time.After(2 * time.Second)
-		}
-	}
-	return nil
+	// Commands returns the commands the handler answers to.
+	Commands() []*Command
 }
@@ -1,10 +1,5 @@
 package messagehandlers
 
-import (
-	"errors"
-	"regexp"
-)
-
 // MiscMessageHandler misc rubbish
 type MiscMessageHandler struct {
 }
@@ -14,24 +9,19 @@ func NewMiscMessageHandler() *MiscMessageHandler {
 	return &handler
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (sg *MiscMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	haskellRegex := regexp.MustCompile(`.*haskell.*`)
-	signOfLifeRegex := regexp.MustCompile(`hello`)
-	soundOffRegex := regexp.MustCompile(`sound off`)
-
-	switch {
-	case signOfLifeRegex.MatchString(msg):
-		return NewTextMessageResponse("alive and well"), nil
-
-	case haskellRegex.MatchString(msg):
-		return NewTextMessageResponse(".... haskell... don't get me started!!!"), nil
+func (sg *MiscMessageHandler) Name() string {
+	return "MiscMessageHandler"
+}
 
-	case soundOffRegex.MatchString(msg):
-		return NewTextMessageResponse("MiscMessageHandler reporting for duty "), nil
+// Commands matches anywhere in a message, so stays at low priority to avoid
+// stealing messages from real commands.
+func (sg *MiscMessageHandler) Commands() []*Command {
+	return []*Command{
+		NewPatternCommand(`hello`, LowPriority, func(args CommandArgs, user string) (MessageResponse, error) {
+			return NewTextMessageResponse("alive and well"), nil
+		}),
+		NewPatternCommand(`haskell`, LowPriority, func(args CommandArgs, user string) (MessageResponse, error) {
+			return NewTextMessageResponse(".... haskell... don't get me started!!!"), nil
+		}),
 	}
-
-	return NewTextMessageResponse(""), errors.New("No match")
 }
@@ -0,0 +1,304 @@
+package messagehandlers
+
+import (
+	"context"
+	"github.com/kpfaulkner/wheatley/logging"
+	"github.com/slack-go/slack"
+	"github.com/slack-go/slack/slackevents"
+	"strings"
+	"time"
+)
+
+// IncomingMessage is a message from Slack, regardless of whether it came in over
+// RTM, Socket Mode or the Events API.
+type IncomingMessage struct {
+	Text    string
+	User    string // Slack user ID, not the name.
+	Channel string
+
+	// used by the EventFilter
+	BotID    string
+	SubType  string
+	Edited   bool
+	ThreadTS string // set if the message is in a thread.
+	TS       string
+
+	// used by the ActivationPolicy
+	ChannelType string // im, mpim, channel or group. Can be empty for RTM.
+	Mentioned   bool   // came in as an app_mention event.
+
+	Transport string // one of the Transport constants, set by whoever received the message.
+
+	// EventID is the Events API event ID, used to tie log lines together. Empty for RTM.
+	EventID string
+}
+
+// IsDM is true for direct messages. RTM doesn't give a channel type, but DM channel IDs start with D.
+func (m IncomingMessage) IsDM() bool {
+	if m.ChannelType != "" {
+		return m.ChannelType == "im"
+	}
+	return strings.HasPrefix(m.Channel, "D")
+}
+
+// ThreadRoot is the timestamp to reply to when replying in a thread. Either the thread the
+// message is already in, or a new thread started off the message itself.
+func (m IncomingMessage) ThreadRoot() string {
+	if m.ThreadTS != "" {
+		return m.ThreadTS
+	}
+	return m.TS
+}
+
+// NewIncomingMessageFromMention converts an app_mention event.
+func NewIncomingMessageFromMention(ev *slackevents.AppMentionEvent) IncomingMessage {
+	return IncomingMessage{
+		Text:      ev.Text,
+		User:      ev.User,
+		Channel:   ev.Channel,
+		BotID:     ev.BotID,
+		ThreadTS:  ev.ThreadTimeStamp,
+		TS:        ev.TimeStamp,
+		Mentioned: true,
+		Transport: TransportEventsAPI,
+	}
+}
+
+// NewIncomingMessageFromEvent converts a message from the Events API (or Socket Mode)
+func NewIncomingMessageFromEvent(ev *slackevents.MessageEvent) IncomingMessage {
+	return IncomingMessage{
+		Text:     ev.Text,
+		User:     ev.User,
+		Channel:  ev.Channel,
+		BotID:    ev.BotID,
+		SubType:  ev.SubType,
+		Edited:   ev.Edited != nil,
+		ThreadTS: ev.ThreadTimeStamp,
+		TS:       ev.TimeStamp,
+
+		ChannelType: ev.ChannelType,
+		Transport:   TransportEventsAPI,
+	}
+}
+
+// NewIncomingMessageFromRTM converts a message from the RTM API
+func NewIncomingMessageFromRTM(ev *slack.MessageEvent) IncomingMessage {
+	return IncomingMessage{
+		Text:     ev.Text,
+		User:     ev.User,
+		Channel:  ev.Channel,
+		BotID:    ev.BotID,
+		SubType:  ev.SubType,
+		Edited:   ev.Edited != nil,
+		ThreadTS: ev.ThreadTimestamp,
+		TS:       ev.Timestamp,
+
+		Transport: TransportRTM,
+	}
+}
+
+// Pipeline takes incoming messages, drops the ones we shouldn't answer, checks they're
+// meant for us, works out who sent them, routes them to a single command and sends the
+// response back.
+type Pipeline struct {
+	api        *slack.Client
+	users      *UserDirectory
+	router     *Router
+	filter     *EventFilter
+	activation *ActivationPolicy
+	audit      AuditSink
+}
+
+// NewPipeline creates the pipeline. filter and activation can be nil, although that means
+// Wheatley will happily talk to itself and answer anything in any channel.
+func NewPipeline(api *slack.Client, router *Router, filter *EventFilter, activation *ActivationPolicy) *Pipeline {
+	p := Pipeline{}
+	p.api = api
+	p.users = NewUserDirectory(api, defaultUserCacheTTL)
+	p.router = router
+	p.filter = filter
+	p.activation = activation
+	return &p
+}
+
+// SetAudit sets where every command (and button click) gets recorded. Without one they're just printed.
+func (p *Pipeline) SetAudit(audit AuditSink) {
+	p.audit = audit
+}
+
+// record writes the entry to the audit sink. Failing to audit isn't a reason to fail the command.
+func (p *Pipeline) record(ctx context.Context, entry AuditEntry) {
+	if p.audit == nil {
+		logging.FromContext(ctx).Infof("audit : %s (%s) in %s ran %q, %s %s %s", entry.User, entry.UserName, entry.Channel, entry.Text, entry.Decision, entry.Result, entry.Error)
+		return
+	}
+	if err := p.audit.Record(entry); err != nil {
+		logging.FromContext(ctx).Errorf("unable to write audit entry : %s", err.Error())
+	}
+}
+
+// HandleMessage processes a single message and replies via the responder.
+// Blocks until the reply has been sent, so callers will generally want to run it in a goroutine.
+// Cancelling ctx cancels whatever command is running.
+func (p *Pipeline) HandleMessage(ctx context.Context, msg IncomingMessage, responder Responder) error {
+	eventID := msg.EventID
+	if eventID == "" {
+		eventID = msg.TS
+	}
+	ctx = logging.With(ctx, "event", eventID, "user", msg.User, "channel", msg.Channel)
+	log := logging.FromContext(ctx)
+
+	if p.filter != nil {
+		if ok, reason := p.filter.Allow(msg); !ok {
+			log.Debugf("dropping message in %s : %s", msg.Channel, reason)
+			return nil
+		}
+	}
+
+	if p.activation != nil {
+		text, ok := p.activation.Activate(msg)
+		if !ok {
+			return nil
+		}
+		msg.Text = text
+	}
+
+	u, err := p.users.Lookup(ctx, msg.User)
+	if err != nil {
+		log.Errorf("unable to get user info for %s : %s", msg.User, err.Error())
+		return err
+	}
+
+	res, err := p.router.Resolve(msg.Text, *u)
+	if err != nil {
+		// ErrNoMatch just means the message wasn't for us.
+		if err == ErrNoMatch {
+			return nil
+		}
+		return err
+	}
+
+	if res.Command != nil {
+		ctx = logging.With(ctx, "command", res.Command.Usage())
+		log = logging.FromContext(ctx)
+	}
+
+	entry := newAuditEntry(*u, msg.Channel, msg.Transport, msg.Text)
+	entry.resolved(res)
+
+	// replies stay wherever the conversation already is.
+	threadTS := msg.ThreadTS
+	resp := res.Response
+	if res.Runs() {
+		command := res.Command
+		if command.Threaded {
+			threadTS = msg.ThreadRoot()
+		}
+
+		req := Request{
+			Args:      res.Args,
+			User:      *u,
+			Channel:   msg.Channel,
+			ThreadTS:  threadTS,
+			Transport: msg.Transport,
+			Reply:     replyTo(responder, msg.Channel, threadTS),
+		}
+
+		if command.Ack != "" {
+			req.Progress, err = responder.StartProgress(msg.Channel, threadTS, command.Ack)
+			if err != nil {
+				// not worth failing the command over.
+				log.Errorf("unable to post acknowledgement : %s", err.Error())
+				req.Progress = nil
+			}
+		}
+
+		start := time.Now()
+		resp, err = p.router.Execute(ctx, command, &req)
+		entry.finished(start, err)
+		if err != nil {
+			resp = commandFailed(ctx, command.Usage(), err)
+		}
+	}
+
+	p.record(ctx, entry)
+	return responder.Respond(resp, msg.Channel, threadTS)
+}
+
+// HandleInteraction runs a button click or modal submission through the action that owns it
+// and replies via the responder, in the thread the button was in if there was one.
+// Blocks until the reply has been sent, same as HandleMessage.
+func (p *Pipeline) HandleInteraction(ctx context.Context, in Interaction, responder Responder) error {
+	ctx = logging.With(ctx, "event", in.TriggerID, "user", in.User, "channel", in.Channel, "command", in.ActionID)
+	log := logging.FromContext(ctx)
+
+	u, err := p.users.Lookup(ctx, in.User)
+	if err != nil {
+		log.Errorf("unable to get user info for %s : %s", in.User, err.Error())
+		return err
+	}
+
+	action, resp, err := p.router.ResolveAction(in.ActionID, *u)
+	if err != nil {
+		if err == ErrNoMatch {
+			log.Warnf("nothing handles action %s, ignoring", in.ActionID)
+			return nil
+		}
+		return err
+	}
+
+	entry := newAuditEntry(*u, in.Channel, in.Transport, in.ActionID)
+	entry.Args = formatArgs(in.Args())
+	entry.Decision = DecisionDenied
+	if action != nil {
+		entry.Handler = action.handler
+		entry.Command = action.ID
+		entry.Decision = DecisionAllowed
+	}
+
+	if in.Channel == "" {
+		log.Warnf("action %s from %s has no channel to reply to, ignoring", in.ActionID, in.User)
+		return nil
+	}
+
+	if action != nil {
+		req := Request{
+			Args:        in.Args(),
+			User:        *u,
+			Channel:     in.Channel,
+			ThreadTS:    in.ThreadTS,
+			Transport:   in.Transport,
+			Interaction: &in,
+			Reply:       replyTo(responder, in.Channel, in.ThreadTS),
+		}
+
+		if action.Ack != "" {
+			req.Progress, err = responder.StartProgress(in.Channel, in.ThreadTS, action.Ack)
+			if err != nil {
+				log.Errorf("unable to post acknowledgement : %s", err.Error())
+				req.Progress = nil
+			}
+		}
+
+		start := time.Now()
+		resp, err = action.Execute(ctx, &req)
+		entry.finished(start, err)
+		if err != nil {
+			resp = commandFailed(ctx, action.ID, err)
+		}
+	}
+	p.record(ctx, entry)
+
+	// some actions have nothing to say.
+	if resp == nil {
+		return nil
+	}
+	return responder.Respond(resp, in.Channel, in.ThreadTS)
+}
+
+// commandFailed logs why a command failed and returns a short reply saying it did. Errors can
+// have anything in them (hosts, connection strings, raw API responses) so they only go in the logs.
+func commandFailed(ctx context.Context, name string, err error) MessageResponse {
+	logging.FromContext(ctx).Errorf("%s failed : %s", name, err.Error())
+	return NewTextMessageResponse("Sorry, " + name + " failed, the details are in the logs.")
+}
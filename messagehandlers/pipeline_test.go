@@ -0,0 +1,68 @@
+package messagehandlers
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/slack-go/slack"
+)
+
+// sentResponder keeps everything it's asked to send.
+type sentResponder struct {
+	sent []MessageResponse
+}
+
+func (r *sentResponder) Respond(msg MessageResponse, channel string, threadTS string) error {
+	r.sent = append(r.sent, msg)
+	return nil
+}
+
+func (r *sentResponder) StartProgress(channel string, threadTS string, text string) (ProgressFunc, error) {
+	return noProgress, nil
+}
+
+func TestPipelineCommandFails(t *testing.T) {
+	slackAPI := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users.info":
+			w.Write([]byte(`{"ok":true,"user":{"id":"U012AB3CD","name":"someone","profile":{}}}`))
+		default:
+			w.Write([]byte(`{"ok":true,"usergroups":[]}`))
+		}
+	}))
+	defer slackAPI.Close()
+
+	failing := NewCommand("backup <env:word>", "", func(args CommandArgs, user string) (MessageResponse, error) {
+		return nil, errors.New("dial tcp prod-sql.internal:1433 : password=hunter2 rejected")
+	})
+	router := NewRouter(&testHandler{name: "Backup", commands: []*Command{failing}})
+	audit := &memoryAuditSink{}
+	api := slack.New("xoxb-test", slack.OptionAPIURL(slackAPI.URL+"/"))
+	p := NewPipeline(api, router, nil, NewActivationPolicy(config.ActivationConfig{Mode: config.ActivationAll}, "U0BOT0000"))
+	p.SetAudit(audit)
+
+	responder := &sentResponder{}
+	err := p.HandleMessage(context.Background(), IncomingMessage{Text: "backup prod", User: "U012AB3CD", Channel: "C012AB3CD"}, responder)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// they're told it failed, the why stays in the logs and the audit log.
+	if len(responder.sent) != 1 {
+		t.Fatalf("expected a single reply, got %d", len(responder.sent))
+	}
+	reply := text(t, responder.sent[0])
+	if !strings.Contains(reply, "backup <env> failed") || strings.Contains(reply, "hunter2") || strings.Contains(reply, "prod-sql") {
+		t.Errorf("unexpected reply %q", reply)
+	}
+	entries, _ := audit.Last(1)
+	if len(entries) != 1 || entries[0].Result != ResultError || !strings.Contains(entries[0].Error, "hunter2") {
+		t.Errorf("expected the error in the audit log, got %+v", entries)
+	}
+}
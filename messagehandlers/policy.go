@@ -0,0 +1,73 @@
+package messagehandlers
+
+import (
+	"fmt"
+	"github.com/kpfaulkner/wheatley/config"
+	"github.com/kpfaulkner/wheatley/logging"
+	"strings"
+)
+
+// Policy decides whether a user can run a command. The Router checks it before any handler runs.
+type Policy struct {
+	config config.PolicyConfig
+}
+
+func NewPolicy(policy config.PolicyConfig) *Policy {
+	p := Policy{}
+	p.config = policy
+	if p.config.Roles == nil {
+		p.config.Roles = map[string][]string{}
+	}
+	if p.config.Commands == nil {
+		p.config.Commands = map[string][]string{}
+	}
+
+	for role, members := range p.config.Roles {
+		for _, m := range members {
+			if !IsIdentityEntry(m) {
+				logging.Warnf("policy : role %s member %q will never match, use their Slack user ID or email instead", role, m)
+			}
+		}
+	}
+	return &p
+}
+
+// RequiredRoles returns the roles that can run the command, nil if anyone can.
+func (p *Policy) RequiredRoles(handler string, command *Command) []string {
+	if roles, ok := p.config.Commands[handler+":"+command.Usage()]; ok {
+		return roles
+	}
+	if roles, ok := p.config.Commands[handler]; ok {
+		return roles
+	}
+	if command.Mutating || command.Sensitive || command.RequiresApproval {
+		return p.config.MutatingRoles
+	}
+	return nil
+}
+
+// Allowed checks the user has one of the roles needed for the command.
+// Returns the required roles so the denial can say what's missing.
+func (p *Policy) Allowed(handler string, command *Command, user User) (bool, []string) {
+	roles := p.RequiredRoles(handler, command)
+	if len(roles) == 0 {
+		return true, nil
+	}
+
+	for _, role := range roles {
+		if userAllowed(user, p.config.Roles[role]) {
+			return true, roles
+		}
+	}
+	return false, roles
+}
+
+// PermissionDeniedError is returned when the user doesn't have a role the command needs.
+type PermissionDeniedError struct {
+	Command string
+	Roles   []string
+}
+
+func (e PermissionDeniedError) Error() string {
+	return fmt.Sprintf("%s needs one of these roles: %s", e.Command, strings.Join(e.Roles, ", "))
+}
@@ -0,0 +1,101 @@
+package messagehandlers
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/kpfaulkner/wheatley/config"
+)
+
+func noop(args CommandArgs, user string) (MessageResponse, error) {
+	return nil, nil
+}
+
+func testPolicy() *Policy {
+	return NewPolicy(config.PolicyConfig{
+		Roles: map[string][]string{
+			"admin": {"U0ADMIN00"},
+			"ops":   {"ops@example.com", "S0OPS0000"},
+		},
+		Commands: map[string][]string{
+			"ServerStatusMessageHandler:env <env> is <state>": {"ops"},
+			"ServerStatusMessageHandler":                      {"admin"},
+			"MiscMessageHandler":                              {},
+		},
+		MutatingRoles: []string{"admin"},
+	})
+}
+
+func TestRequiredRoles(t *testing.T) {
+	p := testPolicy()
+
+	state := NewCommand("env <env> is <state>", "", noop)
+	status := NewCommand("status <env>", "", noop)
+	mutating := NewCommand("restart <env>", "", noop)
+	mutating.Mutating = true
+	plain := NewCommand("hello", "", noop)
+
+	tests := []struct {
+		name     string
+		handler  string
+		command  *Command
+		expected []string
+	}{
+		{"handler and usage beats handler", "ServerStatusMessageHandler", state, []string{"ops"}},
+		{"handler", "ServerStatusMessageHandler", status, []string{"admin"}},
+		{"handler beats mutating", "ServerStatusMessageHandler", mutating, []string{"admin"}},
+		{"empty list is anyone, even if mutating", "MiscMessageHandler", mutating, []string{}},
+		{"mutating", "AzureShutdownMessageHandler", mutating, []string{"admin"}},
+		{"anything else is anyone", "AzureShutdownMessageHandler", plain, nil},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := p.RequiredRoles(tc.handler, tc.command)
+			if strings.Join(got, ",") != strings.Join(tc.expected, ",") || (got == nil) != (tc.expected == nil) {
+				t.Errorf("expected %#v, got %#v", tc.expected, got)
+			}
+		})
+	}
+}
+
+func TestPolicyAllowed(t *testing.T) {
+	p := testPolicy()
+	state := NewCommand("env <env> is <state>", "", noop)
+	plain := NewCommand("hello", "", noop)
+
+	tests := []struct {
+		name    string
+		user    User
+		allowed bool
+	}{
+		{"by email", User{ID: "U012AB3CD", Email: "OPS@example.com"}, true},
+		{"by group", User{ID: "U012AB3CD", Groups: []string{"S0OPS0000"}}, true},
+		{"wrong role", User{ID: "U0ADMIN00"}, false},
+		{"name isn't enough", User{ID: "U012AB3CD", Name: "ops@example.com"}, false},
+	}
+	for _, tc := range tests {
+		allowed, roles := p.Allowed("ServerStatusMessageHandler", state, tc.user)
+		if allowed != tc.allowed {
+			t.Errorf("%s : expected %v, got %v", tc.name, tc.allowed, allowed)
+		}
+		if strings.Join(roles, ",") != "ops" {
+			t.Errorf("%s : expected the required roles, got %v", tc.name, roles)
+		}
+	}
+
+	if allowed, roles := p.Allowed("AzureShutdownMessageHandler", plain, User{ID: "U012AB3CD"}); !allowed || roles != nil {
+		t.Errorf("expected anyone to be allowed, got %v %v", allowed, roles)
+	}
+}
+
+func TestDefaultPolicy(t *testing.T) {
+	p := NewPolicy(config.DefaultPolicyConfig())
+	mutating := NewCommand("restart <env>", "", noop)
+	mutating.Mutating = true
+
+	// nobody is an admin, so nobody can run mutating commands.
+	if allowed, _ := p.Allowed("AnyHandler", mutating, User{ID: "U0ADMIN00"}); allowed {
+		t.Error("expected mutating commands to be denied by default")
+	}
+}
@@ -0,0 +1,53 @@
+package messagehandlers
+
+import (
+	"sync"
+
+	"github.com/kpfaulkner/wheatley/logging"
+	"github.com/slack-go/slack"
+)
+
+// ProgressFunc lets a long running command report partial results. Each call replaces the
+// text of the acknowledgement message that was posted when the command started.
+type ProgressFunc func(text string)
+
+// noProgress is used when there's nowhere to report progress to.
+func noProgress(text string) {}
+
+// NewProgressCommand is for commands that take a while. ack is posted in the thread of the
+// triggering message as soon as the command starts, and is updated in place each time the
+// command calls req.Progress. The final response also goes in the thread.
+func NewProgressCommand(spec string, help string, ack string, handle HandlerFunc) *Command {
+	c := NewContextCommand(spec, help, handle)
+	c.Threaded = true
+	c.Ack = ack
+	return c
+}
+
+// startProgress posts the text via the Web API and returns a ProgressFunc that updates it using chat.update.
+func startProgress(api *slack.Client, channel string, threadTS string, text string) (ProgressFunc, error) {
+	channelID, ts, err := api.PostMessage(channel, slack.MsgOptionText(text, false), msgOptionThread(threadTS))
+	if err != nil {
+		return nil, err
+	}
+
+	// commands may report progress from multiple goroutines, make sure the updates don't overlap.
+	var lock sync.Mutex
+	return func(text string) {
+		lock.Lock()
+		defer lock.Unlock()
+
+		_, _, _, err := api.UpdateMessage(channelID, ts, slack.MsgOptionText(text, false))
+		if err != nil {
+			logging.Errorf("unable to update progress message in %s : %s", channelID, err.Error())
+		}
+	}, nil
+}
+
+// msgOptionThread replies in the thread if there is one, otherwise does nothing.
+func msgOptionThread(threadTS string) slack.MsgOption {
+	if threadTS == "" {
+		return slack.MsgOptionCompose()
+	}
+	return slack.MsgOptionTS(threadTS)
+}
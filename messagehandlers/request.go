@@ -0,0 +1,70 @@
+package messagehandlers
+
+import (
+	"context"
+	"time"
+)
+
+// transports a message can come in on.
+const (
+	TransportRTM          = "rtm"
+	TransportSocketMode   = "socketmode"
+	TransportEventsAPI    = "eventsapi"
+	TransportSlashCommand = "slashcommand"
+)
+
+// DefaultCommandTimeout is how long a command gets if it doesn't set its own Timeout.
+const DefaultCommandTimeout = 1 * time.Minute
+
+// Request is everything a command gets told about the message that triggered it.
+type Request struct {
+	Args CommandArgs
+
+	// User sent the message. Use User.Matches (ID, email or group) for permission checks.
+	User User
+
+	Channel   string
+	ThreadTS  string // thread the response will go in. Empty if it's going in the channel.
+	Transport string // one of the Transport constants.
+
+	// Approver is set if the command needed approval, and is who approved it.
+	Approver *User
+
+	// Interaction is set if a button or modal triggered the request rather than a message.
+	Interaction *Interaction
+
+	// Progress updates the acknowledgement for commands created with NewProgressCommand.
+	// Never nil, for other commands it does nothing.
+	Progress ProgressFunc
+
+	// Reply posts another message wherever the response goes, and still works after the command
+	// has returned, eg once something it started in the background finishes. Never nil.
+	Reply ReplyFunc
+}
+
+// ReplyFunc sends msg to the same channel (and thread) as the command's response.
+type ReplyFunc func(msg MessageResponse) error
+
+// replyTo is a ReplyFunc for the channel and thread.
+func replyTo(responder Responder, channel string, threadTS string) ReplyFunc {
+	return func(msg MessageResponse) error {
+		return responder.Respond(msg, channel, threadTS)
+	}
+}
+
+// noReply is used when there's nowhere to reply to.
+func noReply(msg MessageResponse) error {
+	return nil
+}
+
+// HandlerFunc is the context aware version of CommandFunc. ctx is cancelled once the command's
+// Timeout is up, so should be passed to anything that goes over the network.
+type HandlerFunc func(ctx context.Context, req *Request) (MessageResponse, error)
+
+// AdaptCommandFunc lets old style CommandFuncs be used as HandlerFuncs. The context is ignored,
+// so the command can't be cancelled, and the command only gets the user name.
+func AdaptCommandFunc(run CommandFunc) HandlerFunc {
+	return func(ctx context.Context, req *Request) (MessageResponse, error) {
+		return run(req.Args, req.User.Name)
+	}
+}
@@ -0,0 +1,139 @@
+package messagehandlers
+
+import (
+	"fmt"
+	"github.com/kpfaulkner/wheatley/logging"
+	"github.com/slack-go/slack"
+)
+
+// Responder sends a MessageResponse back to a Slack channel.
+// Lets the handlers stay the same regardless of whether we're running over RTM or
+// as an Azure Function using the Web API.
+type Responder interface {
+
+	// Respond sends the response to the channel. If threadTS is set the response goes in that thread.
+	Respond(msg MessageResponse, channel string, threadTS string) error
+
+	// StartProgress posts text straight away and returns a ProgressFunc that replaces it in place.
+	StartProgress(channel string, threadTS string, text string) (ProgressFunc, error)
+}
+
+// RTMResponder sends text over the RTM websocket. Files always go via the Web API.
+type RTMResponder struct {
+	api    *slack.Client
+	rtm    *slack.RTM
+	sender *OutboundSender
+}
+
+func NewRTMResponder(api *slack.Client, rtm *slack.RTM) *RTMResponder {
+	r := RTMResponder{}
+	r.api = api
+	r.rtm = rtm
+	r.sender = NewOutboundSender(func(channel string, threadTS string, text string) error {
+		rtm.SendMessage(rtm.NewOutgoingMessage(text, channel, slack.RTMsgOptionTS(threadTS)))
+		return nil
+	}, defaultMessagesPerSecond, defaultBurst)
+	return &r
+}
+
+func (r *RTMResponder) Respond(msg MessageResponse, channel string, threadTS string) error {
+	return respond(msg, channel, threadTS, r.api, r.sender)
+}
+
+// StartProgress uses the Web API, RTM can't tell us the timestamp of what we sent so can't update it.
+func (r *RTMResponder) StartProgress(channel string, threadTS string, text string) (ProgressFunc, error) {
+	return startProgress(r.api, channel, threadTS, text)
+}
+
+// WebAPIResponder sends everything via the Web API (chat.postMessage and files.upload)
+type WebAPIResponder struct {
+	api    *slack.Client
+	sender *OutboundSender
+}
+
+func NewWebAPIResponder(api *slack.Client) *WebAPIResponder {
+	r := WebAPIResponder{}
+	r.api = api
+	r.sender = NewOutboundSender(func(channel string, threadTS string, text string) error {
+		_, _, err := api.PostMessage(channel, slack.MsgOptionText(text, false), msgOptionThread(threadTS))
+		return err
+	}, defaultMessagesPerSecond, defaultBurst)
+	return &r
+}
+
+func (r *WebAPIResponder) Respond(msg MessageResponse, channel string, threadTS string) error {
+	return respond(msg, channel, threadTS, r.api, r.sender)
+}
+
+func (r *WebAPIResponder) StartProgress(channel string, threadTS string, text string) (ProgressFunc, error) {
+	return startProgress(r.api, channel, threadTS, text)
+}
+
+// respond does the work for all responders, the only difference being how text gets sent.
+func respond(msg MessageResponse, channel string, threadTS string, api *slack.Client, sender *OutboundSender) error {
+
+	// could just use type assertions, but will stick with this for now.
+	switch msg.GetMessageResponseType() {
+	case TextMessageType:
+		textMessage := msg.(TextMessageResponse)
+
+		// sender packs the lines into as few messages as it can, and keeps us under Slack's rate limits.
+		err := sender.Send(channel, threadTS, textMessage.Message)
+		if err != nil {
+			logging.Errorf("unable to send to %s : %s", channel, err.Error())
+			return err
+		}
+
+	case FileMessageType:
+		fileMessage := msg.(FileMessageResponse)
+
+		// loop through all files.
+		for _, details := range fileMessage.Details {
+
+			params := slack.FileUploadParameters{
+				Title:    details.Title,
+				Filetype: details.FileType,
+				//File: fileMessage.FileName,
+				Filename: details.FileName,
+				Content:  string(details.Contents), // should fileMesage.Contents just be string to begin with?
+			}
+
+			file, err := api.UploadFile(params)
+			if err != nil {
+				logging.Errorf("unable to upload %s : %s", details.FileName, err.Error())
+				return err
+			}
+			logging.Debugf("uploaded %s to %s", file.Name, file.URLPrivateDownload)
+			if err := sender.Send(channel, threadTS, fmt.Sprintf("file %s is at %s", file.Name, file.Permalink)); err != nil {
+				return err
+			}
+		}
+
+	case BlocksMessageType:
+		blocksMessage := msg.(BlocksMessageResponse)
+
+		// RTM can't do blocks, so always use the web API. Still goes through the sender so it
+		// stays in order with any text for the channel.
+		err := sender.Do(channel, func() error {
+			_, _, err := api.PostMessage(channel, slack.MsgOptionBlocks(blocksMessage.Blocks...), slack.MsgOptionText(blocksMessage.Fallback, false), msgOptionThread(threadTS))
+			return err
+		})
+		if err != nil {
+			logging.Errorf("unable to send blocks to %s : %s", channel, err.Error())
+			return err
+		}
+
+	case ModalMessageType:
+		modalMessage := msg.(ModalMessageResponse)
+
+		// so the submission can be answered in the same place.
+		view := modalMessage.View
+		view.PrivateMetadata = modalMetadata(channel, threadTS)
+		_, err := api.OpenView(modalMessage.TriggerID, view)
+		if err != nil {
+			logging.Errorf("unable to open modal %s : %s", view.CallbackID, err.Error())
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,366 @@
+package messagehandlers
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/kpfaulkner/wheatley/logging"
+	"sort"
+	"strings"
+)
+
+// priorities used when more than one command matches a message.
+// Highest priority wins, anything matching at the same priority from different
+// handlers is reported back as ambiguous.
+const (
+	LowPriority     int = -10 // chatter that matches anywhere in a message.
+	DefaultPriority int = 0
+	HighPriority    int = 10
+)
+
+// decisions the router makes about a message, recorded in the audit log.
+const (
+	DecisionAllowed   = "allowed"
+	DecisionApproval  = "needs approval"
+	DecisionDenied    = "denied"
+	DecisionUsage     = "usage"
+	DecisionAmbiguous = "ambiguous"
+)
+
+// ErrNoMatch is returned when no registered command matches the message.
+var ErrNoMatch = errors.New("no matching command")
+
+// AmbiguousCommandError is returned when commands from multiple handlers match a message
+// at the same priority.
+type AmbiguousCommandError struct {
+	Handlers []string
+}
+
+func (e AmbiguousCommandError) Error() string {
+	return fmt.Sprintf("message matches commands from multiple handlers: %s", strings.Join(e.Handlers, ", "))
+}
+
+// route is a command along with the handler that registered it.
+type route struct {
+	handler MessageHandler
+	command *Command
+}
+
+type routeMatch struct {
+	route
+	match *commandMatch
+}
+
+// beats is true if this match should be picked over the other.
+func (rm routeMatch) beats(other routeMatch) bool {
+	if rm.command.Priority != other.command.Priority {
+		return rm.command.Priority > other.command.Priority
+	}
+	return rm.match.literals > other.match.literals
+}
+
+// Router decides which single handler gets a message.
+// Handlers register their commands, the router picks the highest priority match
+// and checks the policy allows the user to run it.
+type Router struct {
+	handlers  []MessageHandler
+	routes    []route
+	actions   map[string]actionRoute
+	policy    *Policy
+	approvals *ApprovalManager
+}
+
+func NewRouter(handlers ...MessageHandler) *Router {
+	r := Router{}
+	r.actions = make(map[string]actionRoute)
+
+	// router answers help and sound off itself.
+	r.Register(&r)
+	for _, h := range handlers {
+		r.Register(h)
+	}
+	return &r
+}
+
+// actionRoute is an action along with the handler that registered it.
+type actionRoute struct {
+	handler MessageHandler
+	action  *Action
+}
+
+// Register adds all the commands for a handler, and its actions if it has any.
+// Panics if an action ID is already taken, the click would have nowhere sensible to go.
+func (r *Router) Register(h MessageHandler) {
+	r.handlers = append(r.handlers, h)
+	for _, c := range h.Commands() {
+		c.handler = h.Name()
+		r.routes = append(r.routes, route{handler: h, command: c})
+	}
+
+	ih, ok := h.(InteractiveHandler)
+	if !ok {
+		return
+	}
+	for _, a := range ih.Actions() {
+		if existing, ok := r.actions[a.ID]; ok {
+			panic(fmt.Sprintf("messagehandlers: action %q registered by both %s and %s", a.ID, existing.handler.Name(), h.Name()))
+		}
+		a.handler = h.Name()
+		r.actions[a.ID] = actionRoute{handler: h, action: a}
+	}
+}
+
+// SetPolicy sets the policy checked before any command runs. Without one anyone can run anything.
+func (r *Router) SetPolicy(policy *Policy) {
+	r.policy = policy
+}
+
+// CheckPolicy makes sure every command in the policy is one that's registered, a typo
+// would otherwise quietly fall back to the default roles. Call it once everything is registered.
+func (r *Router) CheckPolicy() error {
+	if r.policy == nil {
+		return nil
+	}
+
+	known := map[string]bool{}
+	for _, h := range r.handlers {
+		known[h.Name()] = true
+	}
+	for _, rt := range r.routes {
+		known[rt.command.handler+":"+rt.command.Usage()] = true
+	}
+
+	unknown := []string{}
+	for key := range r.policy.config.Commands {
+		if !known[key] {
+			unknown = append(unknown, fmt.Sprintf("%q", key))
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("Policy.Commands has unknown handlers or commands : %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// SetApprovals sets the manager used for commands that need approval, and registers its
+// approve and deny commands. Without one, commands that need approval can't be run at all.
+func (r *Router) SetApprovals(approvals *ApprovalManager) {
+	r.approvals = approvals
+	r.Register(approvals)
+}
+
+// Match finds the command that should handle the message.
+// Returns ErrNoMatch if nothing matches, AmbiguousCommandError if there isn't a single winner
+// or UsageError if the message looks like a command but the arguments are wrong.
+func (r *Router) Match(msg string) (*Command, CommandArgs, error) {
+	winner, err := r.match(msg)
+	if err != nil {
+		return nil, nil, err
+	}
+	return winner.command, winner.match.args, nil
+}
+
+func (r *Router) match(msg string) (*routeMatch, error) {
+	msg = strings.TrimSpace(msg)
+
+	var full []routeMatch
+	var partial []routeMatch
+	for _, rt := range r.routes {
+		m := rt.command.match(msg)
+		if m == nil {
+			continue
+		}
+		if m.err == nil {
+			full = append(full, routeMatch{route: rt, match: m})
+		} else {
+			partial = append(partial, routeMatch{route: rt, match: m})
+		}
+	}
+
+	if len(full) == 0 {
+		if len(partial) == 0 {
+			return nil, ErrNoMatch
+		}
+
+		// closest commands get their usage shown.
+		sort.SliceStable(partial, func(i, j int) bool {
+			return partial[i].match.literals > partial[j].match.literals
+		})
+		usageErr := UsageError{Reason: partial[0].match.err.Error()}
+		for _, p := range partial {
+			if p.match.literals == partial[0].match.literals {
+				usageErr.Usages = append(usageErr.Usages, p.command.Usage())
+			}
+		}
+		return nil, usageErr
+	}
+
+	// stable, so within a handler the first registered command wins a tie.
+	sort.SliceStable(full, func(i, j int) bool {
+		return full[i].beats(full[j])
+	})
+
+	winner := full[0]
+	handlers := []string{winner.handler.Name()}
+	for _, m := range full[1:] {
+		if winner.beats(m) {
+			break
+		}
+		if m.handler != winner.handler && !contains(m.handler.Name(), handlers) {
+			handlers = append(handlers, m.handler.Name())
+		}
+	}
+
+	if len(handlers) > 1 {
+		return nil, AmbiguousCommandError{Handlers: handlers}
+	}
+
+	return &winner, nil
+}
+
+// Resolution is what the router decided to do with a message.
+type Resolution struct {
+	// Command that matched, nil if the message was ambiguous or had bad arguments.
+	Command *Command
+	Args    CommandArgs
+
+	// Response is what the user should be told when the command isn't going to run.
+	Response MessageResponse
+
+	// Decision is one of the Decision constants.
+	Decision string
+}
+
+// Runs is true if the command should be run (or sent for approval).
+func (res Resolution) Runs() bool {
+	return res.Decision == DecisionAllowed || res.Decision == DecisionApproval
+}
+
+// Resolve finds the command for the message without running it, and checks the user is allowed to run it.
+// If there's no command the user can run but they should still get told something (ambiguous,
+// bad arguments or not allowed) Runs is false and the response is set.
+// Returns ErrNoMatch if nothing wants the message, in which case nothing should be sent back.
+func (r *Router) Resolve(msg string, user User) (Resolution, error) {
+	winner, err := r.match(msg)
+	if err != nil {
+		var ambiguous AmbiguousCommandError
+		var usage UsageError
+		switch {
+		case errors.As(err, &ambiguous):
+			return Resolution{Response: NewTextMessageResponse(fmt.Sprintf("Not sure what you're after, that could be for %s. Try being more specific.", strings.Join(ambiguous.Handlers, " or "))), Decision: DecisionAmbiguous}, nil
+		case errors.As(err, &usage):
+			return Resolution{Response: NewTextMessageResponse(usage.Error()), Decision: DecisionUsage}, nil
+		}
+		return Resolution{}, err
+	}
+
+	if err := r.authorize(winner.route, user); err != nil {
+		return Resolution{Command: winner.command, Args: winner.match.args, Response: NewTextMessageResponse(fmt.Sprintf("Sorry, you're not allowed to do that. %s", err.Error())), Decision: DecisionDenied}, nil
+	}
+
+	res := Resolution{Command: winner.command, Args: winner.match.args, Decision: DecisionAllowed}
+	if winner.command.RequiresApproval {
+		res.Decision = DecisionApproval
+	}
+	return res, nil
+}
+
+// authorize checks the policy, logging any denials.
+func (r *Router) authorize(rt route, user User) error {
+	if r.policy == nil {
+		return nil
+	}
+
+	ok, roles := r.policy.Allowed(rt.handler.Name(), rt.command, user)
+	if ok {
+		return nil
+	}
+
+	logging.Warnf("denied %s (%s) running %s:%s, needs one of %s", user.ID, user.Name, rt.handler.Name(), rt.command.Usage(), strings.Join(roles, ", "))
+	return PermissionDeniedError{Command: rt.command.Usage(), Roles: roles}
+}
+
+// ResolveAction finds the action for a button click or modal submission and checks the user is
+// allowed to use it. If they aren't the action is nil and the response says why.
+// Returns ErrNoMatch if no handler owns the action ID.
+func (r *Router) ResolveAction(actionID string, user User) (*Action, MessageResponse, error) {
+	ar, ok := r.actions[actionID]
+	if !ok {
+		return nil, nil, ErrNoMatch
+	}
+
+	if ar.action.Command != nil {
+		if err := r.authorize(route{handler: ar.handler, command: ar.action.Command}, user); err != nil {
+			return nil, NewTextMessageResponse(fmt.Sprintf("Sorry, you're not allowed to do that. %s", err.Error())), nil
+		}
+	}
+	return ar.action, nil, nil
+}
+
+// Dispatch runs the message through the single matching command. req.Args gets filled in.
+// Returns ErrNoMatch if nothing wants the message, in which case nothing should be sent back.
+func (r *Router) Dispatch(ctx context.Context, msg string, req *Request) (MessageResponse, error) {
+	res, err := r.Resolve(msg, req.User)
+	if err != nil || !res.Runs() {
+		return res.Response, err
+	}
+	req.Args = res.Args
+	return r.Execute(ctx, res.Command, req)
+}
+
+// Execute runs a command returned by Resolve. Commands that need approval aren't run, a
+// pending approval is created instead.
+func (r *Router) Execute(ctx context.Context, command *Command, req *Request) (MessageResponse, error) {
+	if command.RequiresApproval {
+		if r.approvals == nil {
+			return NewTextMessageResponse("That needs someone to approve it, and approvals aren't set up."), nil
+		}
+		return r.approvals.Request(command, *req), nil
+	}
+	return command.Execute(ctx, req)
+}
+
+// Help returns the help lines for every registered command.
+func (r *Router) Help() string {
+	help := []string{}
+	for _, rt := range r.routes {
+		if rt.command.Help != "" {
+			help = append(help, fmt.Sprintf("%s : %s", rt.command.Usage(), rt.command.Help))
+		}
+	}
+	return strings.Join(help, "\n")
+}
+
+func (r *Router) Name() string {
+	return "Router"
+}
+
+func (r *Router) Commands() []*Command {
+	help := NewCommand("help", "", func(args CommandArgs, user string) (MessageResponse, error) {
+		return NewTextMessageResponse(r.Help()), nil
+	})
+	help.Ephemeral = true
+
+	return []*Command{
+		help,
+		NewCommand("sound off", "", func(args CommandArgs, user string) (MessageResponse, error) {
+			reports := []string{}
+			for _, h := range r.handlers {
+				if h != MessageHandler(r) {
+					reports = append(reports, fmt.Sprintf("%s reporting for duty", h.Name()))
+				}
+			}
+			return NewTextMessageResponse(strings.Join(reports, "\n")), nil
+		}),
+	}
+}
+
+func contains(key string, l []string) bool {
+	for _, s := range l {
+		if s == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,176 @@
+package messagehandlers
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+)
+
+type testHandler struct {
+	name     string
+	commands []*Command
+}
+
+func (h *testHandler) Name() string {
+	return h.name
+}
+
+func (h *testHandler) Commands() []*Command {
+	return h.commands
+}
+
+// replyWith is a command that answers with text, so tests can see which one ran.
+func replyWith(spec string, text string) *Command {
+	return NewCommand(spec, "", func(args CommandArgs, user string) (MessageResponse, error) {
+		return NewTextMessageResponse(text), nil
+	})
+}
+
+func TestRouterFullBeatsPartial(t *testing.T) {
+	r := NewRouter(
+		&testHandler{name: "Costs", commands: []*Command{replyWith("report azurecosts from <start:date> to <end:date>", "costs")}},
+		&testHandler{name: "Reports", commands: []*Command{replyWith("report <what:word>", "report")}},
+	)
+
+	// both start with report, only one fully matches.
+	c, args, err := r.Match("report sendgrid")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.handler != "Reports" || args.String("what") != "sendgrid" {
+		t.Errorf("expected Reports, got %s %v", c.handler, args)
+	}
+
+	// more literal words wins when both fully match.
+	c, _, err = r.Match("report azurecosts from 2026-09-01 to 2026-09-30")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.handler != "Costs" {
+		t.Errorf("expected Costs, got %s", c.handler)
+	}
+}
+
+func TestRouterPriority(t *testing.T) {
+	chatter := NewPatternCommand(`\bcheck\b`, LowPriority, noop)
+	r := NewRouter(
+		&testHandler{name: "Chatter", commands: []*Command{chatter}},
+		&testHandler{name: "Status", commands: []*Command{replyWith("check <env:word>", "status")}},
+	)
+
+	c, _, err := r.Match("check prod")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.handler != "Status" {
+		t.Errorf("expected Status to beat the low priority chatter, got %s", c.handler)
+	}
+
+	c, _, err = r.Match("can someone check this")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.handler != "Chatter" {
+		t.Errorf("expected Chatter, got %s", c.handler)
+	}
+}
+
+func TestRouterAmbiguous(t *testing.T) {
+	r := NewRouter(
+		&testHandler{name: "Azure", commands: []*Command{replyWith("check <env:word>", "azure")}},
+		&testHandler{name: "Monitor", commands: []*Command{replyWith("check <env:word>", "monitor")}},
+	)
+
+	_, _, err := r.Match("check prod")
+	var ambiguous AmbiguousCommandError
+	if !errors.As(err, &ambiguous) || strings.Join(ambiguous.Handlers, ",") != "Azure,Monitor" {
+		t.Fatalf("expected ambiguous between Azure and Monitor, got %v", err)
+	}
+
+	res, err := r.Resolve("check prod", User{ID: "U1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Runs() || res.Decision != DecisionAmbiguous || !strings.Contains(text(t, res.Response), "Azure or Monitor") {
+		t.Errorf("unexpected resolution %+v", res)
+	}
+}
+
+func TestRouterSameHandlerNotAmbiguous(t *testing.T) {
+	r := NewRouter(&testHandler{name: "Azure", commands: []*Command{
+		replyWith("check <env:word>", "first"),
+		replyWith("check <name:word>", "second"),
+	}})
+
+	resp, err := r.Dispatch(context.Background(), "check prod", &Request{User: User{ID: "U1"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text(t, resp) != "first" {
+		t.Errorf("expected the first registered command to win, got %q", text(t, resp))
+	}
+}
+
+func TestRouterUsageErrors(t *testing.T) {
+	r := NewRouter(
+		&testHandler{name: "Azure", commands: []*Command{
+			replyWith("check <env:enum(prod|test)>", "azure"),
+			replyWith("check <env:enum(prod|test)> last <mins:int(5..60)> mins", "azure"),
+		}},
+		&testHandler{name: "Monitor", commands: []*Command{replyWith("check monitor <name:word>", "monitor")}},
+	)
+
+	_, _, err := r.Match("check staging")
+	var usage UsageError
+	if !errors.As(err, &usage) {
+		t.Fatalf("expected a usage error, got %v", err)
+	}
+	if usage.Reason != "env should be one of: prod, test" {
+		t.Errorf("unexpected reason %q", usage.Reason)
+	}
+
+	// the reason comes from the first, every command that got as far is listed.
+	expected := []string{"check <prod|test>", "check <prod|test> last <mins> mins", "check monitor <name>"}
+	if strings.Join(usage.Usages, "|") != strings.Join(expected, "|") {
+		t.Errorf("expected usages %q, got %q", expected, usage.Usages)
+	}
+
+	_, _, err = r.Match("check monitor")
+	if !errors.As(err, &usage) || strings.Join(usage.Usages, "|") != "check monitor <name>" {
+		t.Errorf("expected only the closest command's usage, got %v", err)
+	}
+
+	res, err := r.Resolve("check staging", User{ID: "U1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Decision != DecisionUsage || !strings.Contains(text(t, res.Response), "usage: check <prod|test>\nusage: check <prod|test> last <mins> mins") {
+		t.Errorf("unexpected resolution %+v", res)
+	}
+}
+
+func TestRouterNoMatch(t *testing.T) {
+	r := NewRouter(&testHandler{name: "Azure", commands: []*Command{replyWith("check <env:word>", "azure")}})
+
+	if _, _, err := r.Match("hello there"); err != ErrNoMatch {
+		t.Errorf("expected ErrNoMatch, got %v", err)
+	}
+	resp, err := r.Dispatch(context.Background(), "hello there", &Request{})
+	if err != ErrNoMatch || resp != nil {
+		t.Errorf("expected nothing to be sent back, got %v %v", resp, err)
+	}
+}
+
+func TestRouterCheckPolicy(t *testing.T) {
+	r := NewRouter(&testHandler{name: "ServerStatusMessageHandler", commands: []*Command{NewCommand("env <env:env> is <state:text>", "", noop)}})
+	r.SetPolicy(testPolicy())
+	if err := r.CheckPolicy(); err == nil || !strings.Contains(err.Error(), `"MiscMessageHandler"`) || strings.Contains(err.Error(), "ServerStatus") {
+		t.Errorf("expected only MiscMessageHandler to be unknown, got %v", err)
+	}
+
+	r.Register(&testHandler{name: "MiscMessageHandler"})
+	if err := r.CheckPolicy(); err != nil {
+		t.Errorf("expected the policy to be fine, got %v", err)
+	}
+}
@@ -0,0 +1,241 @@
+package messagehandlers
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/kpfaulkner/wheatley/logging"
+	"github.com/slack-go/slack"
+)
+
+const (
+	// Slack truncates messages over 40000 characters, but recommends keeping them under 4000.
+	maxMessageLength = 4000
+
+	// Slack allows roughly one message per second per channel, with short bursts.
+	defaultMessagesPerSecond = 1.0
+	defaultBurst             = 3
+
+	// how many times to retry a message that got a 429.
+	defaultMaxRetries = 3
+
+	// how long a channel's worker waits for more messages before stopping. Lots of senders only
+	// send a handful of messages (eg one per slash command), so workers can't live forever.
+	defaultIdleTimeout = 1 * time.Minute
+)
+
+// PostFunc does the actual sending of a single message to a channel.
+// threadTS is empty unless the message is a reply in a thread.
+type PostFunc func(channel string, threadTS string, text string) error
+
+// outboundMessage is a single message waiting to be sent.
+type outboundMessage struct {
+	channel string
+	post    func() error
+	done    chan error
+}
+
+// channelQueue is the messages waiting for a single channel. The lock keeps messages queued
+// together from being split up, without holding up any other channel.
+type channelQueue struct {
+	lock     sync.Mutex
+	messages chan *outboundMessage
+	pending  int // queued or about to be, guarded by the sender's lock.
+}
+
+// tokenBucket is a basic token bucket rate limiter.
+type tokenBucket struct {
+	rate   float64 // tokens per second.
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func newTokenBucket(rate float64, burst int) *tokenBucket {
+	tb := tokenBucket{}
+	tb.rate = rate
+	tb.burst = float64(burst)
+	tb.tokens = tb.burst
+	tb.last = time.Now()
+	return &tb
+}
+
+// wait blocks until there's a token available, then takes it.
+func (tb *tokenBucket) wait() {
+	now := time.Now()
+	tb.tokens += now.Sub(tb.last).Seconds() * tb.rate
+	if tb.tokens > tb.burst {
+		tb.tokens = tb.burst
+	}
+	tb.last = now
+
+	if tb.tokens < 1 {
+		delay := time.Duration((1 - tb.tokens) / tb.rate * float64(time.Second))
+		<-time.After(delay)
+		tb.tokens = 1
+		tb.last = time.Now()
+	}
+	tb.tokens--
+}
+
+// OutboundSender queues messages per channel, packs lines together up to Slack's size limit
+// and rate limits each channel. Messages for a channel are always sent in the order they were queued.
+type OutboundSender struct {
+	post       PostFunc
+	rate       float64
+	burst      int
+	maxRetries int
+	idle       time.Duration
+
+	lock   sync.Mutex
+	queues map[string]*channelQueue
+}
+
+func NewOutboundSender(post PostFunc, messagesPerSecond float64, burst int) *OutboundSender {
+	s := OutboundSender{}
+	s.post = post
+	s.rate = messagesPerSecond
+	s.burst = burst
+	s.maxRetries = defaultMaxRetries
+	s.idle = defaultIdleTimeout
+	s.queues = make(map[string]*channelQueue)
+	return &s
+}
+
+// Send queues the text for the channel (or thread in the channel, if threadTS is set) and waits
+// until it has all been sent. Returns the first error from Slack, if any.
+func (s *OutboundSender) Send(channel string, threadTS string, text string) error {
+	posts := []func() error{}
+	for _, chunk := range packLines(text, maxMessageLength) {
+		chunk := chunk
+		posts = append(posts, func() error {
+			return s.post(channel, threadTS, chunk)
+		})
+	}
+	return s.Do(channel, posts...)
+}
+
+// Do queues posts for the channel, for anything that isn't plain text (eg blocks), so it's
+// still rate limited and sent in order with everything else. Waits until they have all been
+// sent and returns the first error, if any.
+func (s *OutboundSender) Do(channel string, posts ...func() error) error {
+	messages := []*outboundMessage{}
+	for _, post := range posts {
+		messages = append(messages, &outboundMessage{channel: channel, post: post, done: make(chan error, 1)})
+	}
+
+	s.lock.Lock()
+	queue := s.queueForChannel(channel)
+	queue.pending += len(messages)
+	s.lock.Unlock()
+
+	// queue all chunks together so another response can't get in between. A full queue only
+	// holds up this channel.
+	queue.lock.Lock()
+	for _, m := range messages {
+		queue.messages <- m
+	}
+	queue.lock.Unlock()
+
+	var firstErr error
+	for _, m := range messages {
+		if err := <-m.done; err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// queueForChannel returns the queue for the channel, starting its worker if there isn't one.
+// Must be called with the lock held.
+func (s *OutboundSender) queueForChannel(channel string) *channelQueue {
+	queue, ok := s.queues[channel]
+	if !ok {
+		queue = &channelQueue{messages: make(chan *outboundMessage, 100)}
+		s.queues[channel] = queue
+		go s.worker(channel, queue)
+	}
+	return queue
+}
+
+// worker sends messages for a single channel, one at a time. Once nothing has been queued for a
+// while it stops, the next message for the channel starts a new one.
+func (s *OutboundSender) worker(channel string, queue *channelQueue) {
+	bucket := newTokenBucket(s.rate, s.burst)
+	for {
+		select {
+		case m := <-queue.messages:
+			bucket.wait()
+			m.done <- s.postWithRetry(m)
+
+			s.lock.Lock()
+			queue.pending--
+			s.lock.Unlock()
+
+		case <-time.After(s.idle):
+			s.lock.Lock()
+			if queue.pending == 0 {
+				delete(s.queues, channel)
+				s.lock.Unlock()
+				return
+			}
+			s.lock.Unlock()
+		}
+	}
+}
+
+// postWithRetry retries when Slack says we're being rate limited, waiting as long as Retry-After says.
+func (s *OutboundSender) postWithRetry(m *outboundMessage) error {
+	var err error
+	for attempt := 0; attempt <= s.maxRetries; attempt++ {
+		err = m.post()
+
+		var rateLimited *slack.RateLimitedError
+		if !errors.As(err, &rateLimited) {
+			return err
+		}
+
+		logging.Warnf("rate limited sending to %s, retrying after %s", m.channel, rateLimited.RetryAfter)
+		<-time.After(rateLimited.RetryAfter)
+	}
+	return err
+}
+
+// packLines combines lines into as few messages as possible, each no longer than maxLength.
+// Lines longer than maxLength are split, without splitting any multi-byte characters.
+func packLines(text string, maxLength int) []string {
+	chunks := []string{}
+	current := ""
+	for _, line := range strings.Split(text, "\n") {
+		for len(line) > maxLength {
+			if current != "" {
+				chunks = append(chunks, current)
+				current = ""
+			}
+			cut := maxLength
+			for cut > 0 && !utf8.RuneStart(line[cut]) {
+				cut--
+			}
+			chunks = append(chunks, line[:cut])
+			line = line[cut:]
+		}
+
+		switch {
+		case current == "":
+			current = line
+		case len(current)+1+len(line) > maxLength:
+			chunks = append(chunks, current)
+			current = line
+		default:
+			current = current + "\n" + line
+		}
+	}
+
+	if strings.TrimSpace(current) != "" {
+		chunks = append(chunks, current)
+	}
+	return chunks
+}
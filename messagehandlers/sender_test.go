@@ -0,0 +1,127 @@
+package messagehandlers
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestPackLines(t *testing.T) {
+	tests := []struct {
+		name     string
+		text     string
+		max      int
+		expected []string
+	}{
+		{"short", "hello", 10, []string{"hello"}},
+		{"packed", "ab\ncd\nef", 5, []string{"ab\ncd", "ef"}},
+		{"exactly full", "abcd\nefghi", 10, []string{"abcd\nefghi"}},
+		{"long line split", "abcdefghij\nk", 4, []string{"abcd", "efgh", "ij\nk"}},
+		{"long line after short", "ab\ncdefgh", 4, []string{"ab", "cdef", "gh"}},
+		{"blank", "\n\n", 10, []string{}},
+	}
+
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			got := packLines(tc.text, tc.max)
+			if strings.Join(got, "|") != strings.Join(tc.expected, "|") || len(got) != len(tc.expected) {
+				t.Errorf("expected %q, got %q", tc.expected, got)
+			}
+		})
+	}
+}
+
+// recorder is a PostFunc that remembers what was sent (and to which thread), and fails with errs in turn.
+type recorder struct {
+	lock  sync.Mutex
+	sent  []string
+	calls int
+	errs  []error
+}
+
+func (r *recorder) post(channel string, threadTS string, text string) error {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.calls++
+	if len(r.errs) > 0 {
+		err := r.errs[0]
+		r.errs = r.errs[1:]
+		if err != nil {
+			return err
+		}
+	}
+	r.sent = append(r.sent, channel+":"+threadTS+":"+text)
+	return nil
+}
+
+func TestOutboundSenderChunks(t *testing.T) {
+	r := &recorder{}
+	s := NewOutboundSender(r.post, 1000, 10)
+
+	lines := []string{}
+	for i := 0; i < 500; i++ {
+		lines = append(lines, strings.Repeat("x", 20))
+	}
+	text := strings.Join(lines, "\n")
+
+	if err := s.Send("C012AB3CD", "1600000000.000100", text); err != nil {
+		t.Fatal(err)
+	}
+	if len(r.sent) < 2 {
+		t.Fatalf("expected the text to be split, got %d message(s)", len(r.sent))
+	}
+
+	chunks := []string{}
+	for _, sent := range r.sent {
+		chunk := strings.TrimPrefix(sent, "C012AB3CD:1600000000.000100:")
+		if len(chunk) > maxMessageLength {
+			t.Errorf("message is %d long, over the %d limit", len(chunk), maxMessageLength)
+		}
+		chunks = append(chunks, chunk)
+	}
+	if strings.Join(chunks, "\n") != text {
+		t.Error("chunks don't add back up to the text, in order")
+	}
+}
+
+func TestOutboundSenderRetryAfter(t *testing.T) {
+	r := &recorder{errs: []error{&slack.RateLimitedError{RetryAfter: 50 * time.Millisecond}}}
+	s := NewOutboundSender(r.post, 1000, 10)
+
+	start := time.Now()
+	if err := s.Send("C012AB3CD", "", "hello"); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected to wait for Retry-After, only took %s", elapsed)
+	}
+	if r.calls != 2 || len(r.sent) != 1 {
+		t.Errorf("expected one retry, got %d call(s) and %v", r.calls, r.sent)
+	}
+}
+
+func TestOutboundSenderGivesUp(t *testing.T) {
+	rateLimited := &slack.RateLimitedError{RetryAfter: time.Millisecond}
+	r := &recorder{errs: []error{rateLimited, rateLimited, rateLimited}}
+	s := NewOutboundSender(r.post, 1000, 10)
+	s.maxRetries = 1
+
+	var err *slack.RateLimitedError
+	if !errors.As(s.Send("C012AB3CD", "", "hello"), &err) {
+		t.Errorf("expected the rate limit error once retries run out")
+	}
+	if r.calls != 2 {
+		t.Errorf("expected 2 calls, got %d", r.calls)
+	}
+
+	// anything else isn't retried.
+	r = &recorder{errs: []error{errors.New("channel_not_found")}}
+	s = NewOutboundSender(r.post, 1000, 10)
+	if s.Send("C012AB3CD", "", "hello") == nil || r.calls != 1 {
+		t.Errorf("expected the error without a retry, got %d call(s)", r.calls)
+	}
+}
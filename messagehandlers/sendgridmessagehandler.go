@@ -1,10 +1,10 @@
 package messagehandlers
 
 import (
-	"errors"
+	"context"
 	"fmt"
+	"github.com/kpfaulkner/wheatley/config"
 	"github.com/kpfaulkner/wheatley/helper"
-	"regexp"
 )
 
 // SendgridMessageHandler checks for SG messages.
@@ -12,31 +12,35 @@ type SendgridMessageHandler struct {
 	Handler *helper.SendgridHandler
 }
 
-func NewSendgridMessageHandler() *SendgridMessageHandler {
+func NewSendgridMessageHandler(config config.SendGridConfig) *SendgridMessageHandler {
 	sgHandler := SendgridMessageHandler{}
-	sgHandler.Handler = helper.NewSendgridHandler()
+	host := config.Host
+	if host == "" {
+		host = helper.DefaultSendGridHost
+	}
+	sgHandler.Handler = helper.NewSendgridHandler(config.APIKey, host)
 	return &sgHandler
 }
 
 // checkEmail checks the email for various problems stored in Sendgrid
-func (sg *SendgridMessageHandler) checkEmail(emailAddr string) (string, error) {
+func (sg *SendgridMessageHandler) checkEmail(ctx context.Context, emailAddr string) (string, error) {
 	msg := ""
-	result, _ := sg.Handler.CheckBlock(emailAddr)
+	result, _ := sg.Handler.CheckBlock(ctx, emailAddr)
 	if len(result) > 0 {
 		msg = fmt.Sprintf("blocked due to %s\n", result[0].Reason)
 	}
 
-	result, _ = sg.Handler.CheckBounce(emailAddr)
+	result, _ = sg.Handler.CheckBounce(ctx, emailAddr)
 	if len(result) > 0 {
 		msg = fmt.Sprintf("%sbounced due to %s\n", msg, result[0].Reason)
 	}
 
-	result, _ = sg.Handler.CheckInvalid(emailAddr)
+	result, _ = sg.Handler.CheckInvalid(ctx, emailAddr)
 	if len(result) > 0 {
 		msg = fmt.Sprintf("%sinvalid due to %s\n", msg, result[0].Reason)
 	}
 
-	r, _ := sg.Handler.CheckSpam(emailAddr)
+	r, _ := sg.Handler.CheckSpam(ctx, emailAddr)
 	if len(r) > 0 {
 		msg = fmt.Sprintf("marked as spam")
 	}
@@ -47,73 +51,62 @@ func (sg *SendgridMessageHandler) checkEmail(emailAddr string) (string, error) {
 	return msg, nil
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (sg *SendgridMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	checkEmailRegex := regexp.MustCompile(`check <mailto:(.*)\|(.*)> email`)
-	checkDebounceRegex := regexp.MustCompile(`debounce <mailto:(.*)\|(.*)> email`)
-	checkDeblockRegex := regexp.MustCompile(`unblock <mailto:(.*)\|(.*)> email`)
-	checkRemoveSpamRegex := regexp.MustCompile(`despam <mailto:(.*)\|(.*)> email`)
-	checkRemoveInvalidRegex := regexp.MustCompile(`remove invalid <mailto:(.*)\|(.*)> email`)
-	soundOffRegex := regexp.MustCompile(`sound off`)
-
-	switch {
-	case checkEmailRegex.MatchString(msg):
-		res := checkEmailRegex.FindStringSubmatch(msg)
-		if res != nil {
-			msg, _ := sg.checkEmail(res[2])
-			return NewTextMessageResponse(msg), nil
-		}
-
-	case checkDebounceRegex.MatchString(msg):
-		res := checkDebounceRegex.FindStringSubmatch(msg)
-		if res != nil {
-			err := sg.Handler.DeleteBounce(res[2])
-			if err != nil {
-				return NewTextMessageResponse("unable to debounce"), nil
-			}
-
-			return NewTextMessageResponse("debounced"), nil
-		}
-
-	case checkDeblockRegex.MatchString(msg):
-		res := checkDeblockRegex.FindStringSubmatch(msg)
-		if res != nil {
-			err := sg.Handler.DeleteBlock(res[2])
-			if err != nil {
-				return NewTextMessageResponse("unable to deblock"), nil
-			}
-
-			return NewTextMessageResponse("deblocked"), nil
-		}
-
-	case checkRemoveSpamRegex.MatchString(msg):
-		res := checkRemoveSpamRegex.FindStringSubmatch(msg)
-		if res != nil {
-			err := sg.Handler.DeleteSpam(res[2])
-			if err != nil {
-				return NewTextMessageResponse("unable to de spam..."), nil
-			}
-
-			return NewTextMessageResponse("despammed"), nil
-		}
-
-	case checkRemoveInvalidRegex.MatchString(msg):
-		res := checkRemoveInvalidRegex.FindStringSubmatch(msg)
-		if res != nil {
-			err := sg.Handler.DeleteSpam(res[2])
-			if err != nil {
-				return NewTextMessageResponse("unable to remove invalid.."), nil
-			}
-
-			return NewTextMessageResponse("de-invalidateded ;)"), nil
-		}
-
-	case soundOffRegex.MatchString(msg):
-		return NewTextMessageResponse("SendgridMessageHandler reporting for duty"), nil
+func (sg *SendgridMessageHandler) Name() string {
+	return "SendgridMessageHandler"
+}
+
+func (sg *SendgridMessageHandler) Commands() []*Command {
+	commands := []*Command{
+		NewContextCommand("debounce <email:email> email", "removes bounce", sg.debounce),
+		NewContextCommand("unblock <email:email> email", "removes block", sg.unblock),
+		NewContextCommand("despam <email:email> email", "removes spam report", sg.despam),
+		NewContextCommand("remove invalid <email:email> email", "removes invalid email flag", sg.removeInvalid),
+	}
+	for _, c := range commands {
+		c.Mutating = true
+	}
+
+	check := NewContextCommand("check <email:email> email", "checks sendgrid for blocks, bounces, invalid and spam reports", sg.check)
+	return append([]*Command{check}, commands...)
+}
+
+func (sg *SendgridMessageHandler) check(ctx context.Context, req *Request) (MessageResponse, error) {
+	msg, _ := sg.checkEmail(ctx, req.Args.String("email"))
+	return NewTextMessageResponse(msg), nil
+}
+
+func (sg *SendgridMessageHandler) debounce(ctx context.Context, req *Request) (MessageResponse, error) {
+	err := sg.Handler.DeleteBounce(ctx, req.Args.String("email"))
+	if err != nil {
+		return NewTextMessageResponse("unable to debounce"), nil
+	}
+
+	return NewTextMessageResponse("debounced"), nil
+}
+
+func (sg *SendgridMessageHandler) unblock(ctx context.Context, req *Request) (MessageResponse, error) {
+	err := sg.Handler.DeleteBlock(ctx, req.Args.String("email"))
+	if err != nil {
+		return NewTextMessageResponse("unable to deblock"), nil
+	}
+
+	return NewTextMessageResponse("deblocked"), nil
+}
+
+func (sg *SendgridMessageHandler) despam(ctx context.Context, req *Request) (MessageResponse, error) {
+	err := sg.Handler.DeleteSpam(ctx, req.Args.String("email"))
+	if err != nil {
+		return NewTextMessageResponse("unable to de spam..."), nil
+	}
+
+	return NewTextMessageResponse("despammed"), nil
+}
 
+func (sg *SendgridMessageHandler) removeInvalid(ctx context.Context, req *Request) (MessageResponse, error) {
+	err := sg.Handler.DeleteInvalid(ctx, req.Args.String("email"))
+	if err != nil {
+		return NewTextMessageResponse("unable to remove invalid.."), nil
 	}
-	return NewTextMessageResponse(""), errors.New("No match")
 
+	return NewTextMessageResponse("de-invalidateded ;)"), nil
 }
@@ -1,10 +1,8 @@
 package messagehandlers
 
 import (
-	"errors"
 	"fmt"
 	"github.com/kpfaulkner/wheatley/models"
-	"regexp"
 	"strings"
 )
 
@@ -13,11 +11,11 @@ type ServerStatusMessageHandler struct {
 	state models.State
 }
 
-func NewServerStatusMessageHandler() *ServerStatusMessageHandler {
+func NewServerStatusMessageHandler(stateFileName string) *ServerStatusMessageHandler {
 	ssHandler := ServerStatusMessageHandler{}
 
 	// load current state.
-	ssHandler.state = models.LoadState()
+	ssHandler.state = models.LoadState(stateFileName)
 	return &ssHandler
 }
 
@@ -26,118 +24,90 @@ func generateEnvStateMessageString(timeStamp string, reporter string, name strin
 	return response
 }
 
-func (ss *ServerStatusMessageHandler) doesEnvExist(env string) bool {
-	envs, err := ss.state.ListEnvs()
-	if err != nil {
-		return false
-	}
+func (ss *ServerStatusMessageHandler) Name() string {
+	return "ServerStatusMessageHandler"
+}
 
-	lowerEnv := strings.ToLower(env)
-	for _, e := range envs {
-		if e == lowerEnv {
-			return true
-		}
+func (ss *ServerStatusMessageHandler) Commands() []*Command {
+	setStatus := NewCommand("env <env:env> is <state:text>", "set env status", ss.setEnvStatus)
+	status := NewCommand("env <env:env>", "get env status", ss.envStatus)
+	checkStatus := NewCommand("check env <env:env>", "", ss.checkStatus)
+
+	setStatus.Envs = ss.envs
+	setStatus.Mutating = true
+	status.Envs = ss.envs
+	checkStatus.Envs = ss.envs
+	return []*Command{
+		NewCommand("list env", "list environments", ss.listEnvs),
+		setStatus,
+		status,
+		NewCommand("env summary", "all env summary", ss.envSummary),
+		checkStatus,
 	}
-	return false
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (ss *ServerStatusMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	addStatusRegex := regexp.MustCompile(`^env (.*) is (.*)`)
-	envStatusRegex := regexp.MustCompile(`^env (.*)`)
-	checkStatusRegex := regexp.MustCompile(`^check env (.*)`)
-	listEnvsRegex := regexp.MustCompile(`^list env`)
-	summaryEnvsRegex := regexp.MustCompile(`^env summary`)
-	helpRegex := regexp.MustCompile(`^help$`)
-	soundOffRegex := regexp.MustCompile(`sound off`)
-
-	switch {
-	case addStatusRegex.MatchString(msg):
-		res := addStatusRegex.FindStringSubmatch(msg)
-		if res != nil {
-			lowerEnv := strings.ToLower(res[1])
-			if ss.doesEnvExist(lowerEnv) {
-				ss.state.UpdateState(lowerEnv, user, res[2])
-				return NewTextMessageResponse("if you say so"), nil
-			} else {
-				return NewTextMessageResponse("don't know about the env.... " + lowerEnv), nil
-			}
-		}
+func (ss *ServerStatusMessageHandler) envs() []string {
+	envs, _ := ss.state.ListEnvs()
+	return envs
+}
 
-	case summaryEnvsRegex.MatchString(msg):
-		res := summaryEnvsRegex.FindStringSubmatch(msg)
-		if res != nil {
-			envs, err := ss.state.ListEnvs()
-			if err != nil {
-				return NewTextMessageResponse("something went boom...... sorry"), nil
-			}
-
-			combinedStatus := []string{}
-			for _, envName := range envs {
-				env, err := ss.state.FindEnv(envName)
-				if err != nil {
-					// unable to find state.....  just tell the user cant do it.
-					combinedStatus = append(combinedStatus, "Unable to find state for "+envName)
-				}
-				stateForEnv := generateEnvStateMessageString(env.Timestamp, env.Reporter, env.Name, env.State)
-				combinedStatus = append(combinedStatus, stateForEnv)
-			}
-
-			return NewTextMessageResponse(strings.Join(combinedStatus, "\n")), nil
-		}
+func (ss *ServerStatusMessageHandler) setEnvStatus(args CommandArgs, user string) (MessageResponse, error) {
+	ss.state.UpdateState(args.String("env"), user, args.String("state"))
+	return NewTextMessageResponse("if you say so"), nil
+}
 
-	case listEnvsRegex.MatchString(msg):
-		res := listEnvsRegex.FindStringSubmatch(msg)
-		if res != nil {
-			envs, err := ss.state.ListEnvs()
-			if err != nil {
-				return NewTextMessageResponse("unable to list envs... something went bang..."), nil
-			}
-			return NewTextMessageResponse(strings.Join(envs, ",")), nil
-		}
+func (ss *ServerStatusMessageHandler) envSummary(args CommandArgs, user string) (MessageResponse, error) {
+	envs, err := ss.state.ListEnvs()
+	if err != nil {
+		return NewTextMessageResponse("something went boom...... sorry"), nil
+	}
 
-	case helpRegex.MatchString(msg):
-		help := []string{"list environments:  list env",
-			"set env status:       env <env name> is <whatever you want>",
-			"get env status:       env <env name>",
-			"all env summary:    env summary"}
-
-		return NewTextMessageResponse(strings.Join(help, "\n")), nil
-
-	case envStatusRegex.MatchString(msg):
-		res := envStatusRegex.FindStringSubmatch(msg)
-		if res != nil {
-			lowerEnv := strings.ToLower(res[1])
-			env, err := ss.state.FindEnv(lowerEnv)
-			if err != nil {
-				// unable to find state.....  just tell the user cant do it.
-				return NewTextMessageResponse("unable to find env....  "), nil
-			}
-
-			response := generateEnvStateMessageString(env.Timestamp, env.Reporter, env.Name, env.State)
-			return NewTextMessageResponse(response), nil
+	rows := [][]string{}
+	for _, envName := range envs {
+		env, err := ss.state.FindEnv(envName)
+		if err != nil {
+			// unable to find state.....  just tell the user cant do it.
+			rows = append(rows, []string{envName, "Unable to find state", "", ""})
+			continue
 		}
+		rows = append(rows, []string{env.Name, env.State, env.Reporter, env.Timestamp})
+	}
 
-	case checkStatusRegex.MatchString(msg):
-		res := checkStatusRegex.FindStringSubmatch(msg)
-		if res != nil {
-			// actually goes off and performs a check itself.
-			/*
-				err := sg.Handler.DeleteBlock(res[2])
-				if err != nil {
-					return "unable to deblock", nil
-				}
-			*/
-
-			return NewTextMessageResponse("deblocked"), nil
-		}
+	resp := NewBlocksMessageResponse(fmt.Sprintf("Status of %d envs", len(envs)))
+	resp.AddHeader("Env summary")
+	resp.AddTable([]string{"Env", "State", "Reporter", "When"}, rows)
+	resp.AddContext("set with: env <env> is <state>")
+	return resp, nil
+}
 
-	case soundOffRegex.MatchString(msg):
-		return NewTextMessageResponse("ServerStatusMessageHandler reporting for duty"), nil
+func (ss *ServerStatusMessageHandler) listEnvs(args CommandArgs, user string) (MessageResponse, error) {
+	envs, err := ss.state.ListEnvs()
+	if err != nil {
+		return NewTextMessageResponse("unable to list envs... something went bang..."), nil
+	}
+	return NewTextMessageResponse(strings.Join(envs, ",")), nil
+}
 
+func (ss *ServerStatusMessageHandler) envStatus(args CommandArgs, user string) (MessageResponse, error) {
+	env, err := ss.state.FindEnv(args.String("env"))
+	if err != nil {
+		// unable to find state.....  just tell the user cant do it.
+		return NewTextMessageResponse("unable to find env....  "), nil
 	}
-	return NewTextMessageResponse(""), errors.New("No match")
 
+	response := generateEnvStateMessageString(env.Timestamp, env.Reporter, env.Name, env.State)
+	return NewTextMessageResponse(response), nil
+}
+
+// checkStatus actually goes off and performs a check itself.
+// Not implemented yet.
+func (ss *ServerStatusMessageHandler) checkStatus(args CommandArgs, user string) (MessageResponse, error) {
+	/*
+		err := sg.Handler.DeleteBlock(res[2])
+		if err != nil {
+			return "unable to deblock", nil
+		}
+	*/
+
+	return NewTextMessageResponse("deblocked"), nil
 }
@@ -2,12 +2,12 @@ package messagehandlers
 
 import (
 	"bytes"
-	"errors"
+	"context"
 	"fmt"
+	"github.com/kpfaulkner/wheatley/config"
 	"github.com/kpfaulkner/wheatley/helper"
-	"regexp"
+	"github.com/kpfaulkner/wheatley/logging"
 	"sort"
-	"strings"
 )
 
 // ServiceBusMessageHandler snooping
@@ -15,9 +15,9 @@ type ServiceBusMessageHandler struct {
 	sbHelper *helper.ServiceBusHelper
 }
 
-func NewServiceBusMessageHandler() *ServiceBusMessageHandler {
+func NewServiceBusMessageHandler(config config.ServiceBusConfig) *ServiceBusMessageHandler {
 	handler := ServiceBusMessageHandler{}
-	handler.sbHelper = helper.NewServiceBusHelper()
+	handler.sbHelper = helper.NewServiceBusHelper(config.ConnectionString)
 	return &handler
 }
 
@@ -25,7 +25,6 @@ func parseServiceBusResults(res *helper.ServiceBusResponse, onlyActive bool) str
 
 	var buffer bytes.Buffer
 	if res != nil {
-		fmt.Println(buffer.String())
 
 		allKeys := []string{}
 		for k := range res.AllCountDetails {
@@ -48,68 +47,30 @@ func parseServiceBusResults(res *helper.ServiceBusResponse, onlyActive bool) str
 	return buffer.String()
 }
 
-// ParseMessage takes a message, determines what to do
-// return the text that should go to the user.
-func (sb *ServiceBusMessageHandler) ParseMessage(msg string, user string) (MessageResponse, error) {
-
-	soundOffRegex := regexp.MustCompile(`sound off`)
-	checkQueueMessageCountRegex := regexp.MustCompile(`sb check queue(.*)`)
-	checkTopicMessageCountRegex := regexp.MustCompile(`sb check topic(.*)`)
-
-	checkActiveQueueMessageCountRegex := regexp.MustCompile(`sb check active queue(.*)`)
-	checkActiveTopicMessageCountRegex := regexp.MustCompile(`sb check active topic(.*)`)
-
-	switch {
-	case soundOffRegex.MatchString(msg):
-		return NewTextMessageResponse("ServiceBusMessageHandler reporting for duty"), nil
-
-	case checkQueueMessageCountRegex.MatchString(msg):
-		fmt.Printf("\n\nQUEUE CHECK\n\n")
-		returnString := "no results"
-		res := checkQueueMessageCountRegex.FindStringSubmatch(msg)
-		if res != nil && len(res) > 0 {
-			results, _ := sb.sbHelper.CheckQueue(strings.TrimSpace(res[1]))
-			returnString = parseServiceBusResults(&results, false)
-		}
-
-		return NewTextMessageResponse(returnString), nil
-
-	case checkTopicMessageCountRegex.MatchString(msg):
-		fmt.Printf("\n\nTOPIC CHECK\n\n")
-
-		returnString := "no results"
-		res := checkTopicMessageCountRegex.FindStringSubmatch(msg)
-		if res != nil && len(res) > 0 {
-			results, _ := sb.sbHelper.CheckTopic(strings.TrimSpace(res[1]))
-			returnString = parseServiceBusResults(&results, false)
-		}
+func (sb *ServiceBusMessageHandler) Name() string {
+	return "ServiceBusMessageHandler"
+}
 
-		return NewTextMessageResponse(returnString), nil
+func (sb *ServiceBusMessageHandler) Commands() []*Command {
+	return []*Command{
+		NewContextCommand("sb check <kind:enum(queue|topic)> [<name:word>]", "message counts for all queues/topics, or just the named one", sb.check(false)),
+		NewContextCommand("sb check active <kind:enum(queue|topic)> [<name:word>]", "as above, but only those with active messages", sb.check(true)),
+	}
+}
 
-	case checkActiveQueueMessageCountRegex.MatchString(msg):
-		fmt.Printf("\n\nACTIVE QUEUE CHECK\n\n")
-		returnString := "no results"
-		res := checkActiveQueueMessageCountRegex.FindStringSubmatch(msg)
-		if res != nil && len(res) > 0 {
-			results, _ := sb.sbHelper.CheckQueue(strings.TrimSpace(res[1]))
-			returnString = parseServiceBusResults(&results, true)
+func (sb *ServiceBusMessageHandler) check(onlyActive bool) HandlerFunc {
+	return func(ctx context.Context, req *Request) (MessageResponse, error) {
+		var results helper.ServiceBusResponse
+		var err error
+		if req.Args.String("kind") == "queue" {
+			results, err = sb.sbHelper.CheckQueue(ctx, req.Args.String("name"))
+		} else {
+			results, err = sb.sbHelper.CheckTopic(ctx, req.Args.String("name"))
 		}
-
-		return NewTextMessageResponse(returnString), nil
-
-	case checkActiveTopicMessageCountRegex.MatchString(msg):
-		fmt.Printf("\n\nnACTIVE TOPIC CHECK\n\n")
-
-		returnString := "no results"
-		res := checkActiveTopicMessageCountRegex.FindStringSubmatch(msg)
-		if res != nil && len(res) > 0 {
-			results, _ := sb.sbHelper.CheckTopic(strings.TrimSpace(res[1]))
-			returnString = parseServiceBusResults(&results, true)
+		if err != nil {
+			logging.FromContext(ctx).Errorf("unable to check service bus : %s", err.Error())
+			return NewTextMessageResponse(fmt.Sprintf("unable to check %ss", req.Args.String("kind"))), nil
 		}
-
-		return NewTextMessageResponse(returnString), nil
-
+		return NewTextMessageResponse(parseServiceBusResults(&results, onlyActive)), nil
 	}
-
-	return NewTextMessageResponse(""), errors.New("No match")
 }
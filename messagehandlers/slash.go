@@ -0,0 +1,166 @@
+package messagehandlers
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/logging"
+	"github.com/slack-go/slack"
+)
+
+// response types for replies via a response_url.
+const (
+	EphemeralResponse = "ephemeral"  // only whoever ran the slash command sees it.
+	InChannelResponse = "in_channel" // everyone in the channel sees it.
+)
+
+// SlashCommand is a slash command, eg /wheatley check prod, regardless of whether it came in over
+// Socket Mode or the slash command endpoint. Text goes through the same commands as messages.
+type SlashCommand struct {
+	Command     string // eg /wheatley
+	Text        string // everything after the command.
+	User        string // Slack user ID.
+	Channel     string
+	ResponseURL string
+	TriggerID   string
+
+	Transport string // one of the Transport constants, set by whoever received the command.
+}
+
+// NewSlashCommandFromSlack converts the parsed slash command payload.
+func NewSlashCommandFromSlack(s slack.SlashCommand) SlashCommand {
+	return SlashCommand{
+		Command:     s.Command,
+		Text:        s.Text,
+		User:        s.UserID,
+		Channel:     s.ChannelID,
+		ResponseURL: s.ResponseURL,
+		TriggerID:   s.TriggerID,
+		Transport:   TransportSlashCommand,
+	}
+}
+
+// HandleSlashCommand runs the slash command through the same commands as a message, replying via
+// the response_url. Whoever received the command should have already returned a 200 to Slack, so
+// slow commands are fine as long as they're done within the 30 minutes the response_url lasts.
+// Replies from the command go to the channel unless the command is Ephemeral, anything else (usage,
+// ambiguous, not allowed) only goes to whoever ran it.
+func (p *Pipeline) HandleSlashCommand(ctx context.Context, cmd SlashCommand) error {
+	ctx = logging.With(ctx, "event", cmd.TriggerID, "user", cmd.User, "channel", cmd.Channel)
+	log := logging.FromContext(ctx)
+	ephemeral := NewResponseURLResponder(p.api, cmd.ResponseURL, EphemeralResponse)
+
+	u, err := p.users.Lookup(ctx, cmd.User)
+	if err != nil {
+		log.Errorf("unable to get user info for %s : %s", cmd.User, err.Error())
+		return err
+	}
+
+	text := strings.TrimSpace(cmd.Text)
+	if text == "" {
+		text = "help"
+	}
+
+	res, err := p.router.Resolve(text, *u)
+	if err != nil {
+		if err == ErrNoMatch {
+			return ephemeral.Respond(NewTextMessageResponse(fmt.Sprintf("Not sure what %q means, try %s help", text, cmd.Command)), cmd.Channel, "")
+		}
+		return err
+	}
+
+	entry := newAuditEntry(*u, cmd.Channel, cmd.Transport, cmd.Command+" "+text)
+	entry.resolved(res)
+	if !res.Runs() {
+		p.record(ctx, entry)
+		return ephemeral.Respond(res.Response, cmd.Channel, "")
+	}
+	command := res.Command
+	ctx = logging.With(ctx, "command", command.Usage())
+	log = logging.FromContext(ctx)
+
+	responder := NewResponseURLResponder(p.api, cmd.ResponseURL, InChannelResponse)
+	if command.Ephemeral {
+		responder = ephemeral
+	}
+
+	req := Request{
+		Args:      res.Args,
+		User:      *u,
+		Channel:   cmd.Channel,
+		Transport: cmd.Transport,
+
+		// response_url can only be used for 30 minutes, so later replies might not make it.
+		Reply: replyTo(responder, cmd.Channel, ""),
+	}
+
+	// the ack is just for whoever ran it, it can't be updated so progress goes nowhere.
+	if command.Ack != "" {
+		req.Progress, err = ephemeral.StartProgress(cmd.Channel, "", command.Ack)
+		if err != nil {
+			log.Errorf("unable to post acknowledgement : %s", err.Error())
+			req.Progress = nil
+		}
+	}
+
+	start := time.Now()
+	resp, err := p.router.Execute(ctx, command, &req)
+	entry.finished(start, err)
+	p.record(ctx, entry)
+	if err != nil {
+		resp = commandFailed(ctx, command.Usage(), err)
+	}
+	return responder.Respond(resp, cmd.Channel, "")
+}
+
+// ResponseURLResponder replies to a slash command via its response_url, which works even in
+// channels Wheatley isn't in. Files still have to be uploaded via the Web API.
+type ResponseURLResponder struct {
+	api          *slack.Client
+	responseURL  string
+	responseType string
+	sender       *OutboundSender
+}
+
+// responseType is EphemeralResponse or InChannelResponse. There's one per slash command, its
+// sender's worker stops once nothing has been sent for a while.
+func NewResponseURLResponder(api *slack.Client, responseURL string, responseType string) *ResponseURLResponder {
+	r := ResponseURLResponder{}
+	r.api = api
+	r.responseURL = responseURL
+	r.responseType = responseType
+	r.sender = NewOutboundSender(func(channel string, threadTS string, text string) error {
+		return slack.PostWebhook(responseURL, &slack.WebhookMessage{Text: text, ResponseType: responseType})
+	}, defaultMessagesPerSecond, defaultBurst)
+	return &r
+}
+
+func (r *ResponseURLResponder) Respond(msg MessageResponse, channel string, threadTS string) error {
+	switch msg.GetMessageResponseType() {
+	case BlocksMessageType:
+		blocksMessage := msg.(BlocksMessageResponse)
+		err := slack.PostWebhook(r.responseURL, &slack.WebhookMessage{
+			Text:         blocksMessage.Fallback,
+			Blocks:       &slack.Blocks{BlockSet: blocksMessage.Blocks},
+			ResponseType: r.responseType,
+		})
+		if err != nil {
+			logging.Errorf("unable to send blocks via response_url : %s", err.Error())
+		}
+		return err
+	}
+
+	// text, files and modals are the same as any other responder.
+	return respond(msg, channel, threadTS, r.api, r.sender)
+}
+
+// StartProgress posts the text, but a response_url can't update what it posted so the
+// ProgressFunc does nothing.
+func (r *ResponseURLResponder) StartProgress(channel string, threadTS string, text string) (ProgressFunc, error) {
+	if err := r.sender.Send(channel, threadTS, text); err != nil {
+		return nil, err
+	}
+	return noProgress, nil
+}
@@ -0,0 +1,180 @@
+package messagehandlers
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/logging"
+	"github.com/slack-go/slack"
+	"golang.org/x/sync/singleflight"
+)
+
+// how long users and user group memberships are cached for.
+const defaultUserCacheTTL = 15 * time.Minute
+
+// User is whoever sent the message. ID and email can't be changed by the user, so use
+// those (or group IDs) for anything to do with permissions, never the names.
+type User struct {
+	ID          string // Slack user ID, eg U012AB3CD
+	TeamID      string
+	Email       string // empty unless the app has the users:read.email scope.
+	Name        string // legacy username.
+	DisplayName string
+	RealName    string
+	IsBot       bool
+
+	// Groups are the IDs of the user groups (eg S0614TZR7) the user is in.
+	Groups []string
+}
+
+// Matches is true if entry is the user's ID, email address or one of their groups.
+// Entries are compared case insensitively.
+func (u User) Matches(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return false
+	}
+	if strings.EqualFold(entry, u.ID) || (u.Email != "" && strings.EqualFold(entry, u.Email)) {
+		return true
+	}
+	for _, g := range u.Groups {
+		if strings.EqualFold(entry, g) {
+			return true
+		}
+	}
+	return false
+}
+
+// IsIdentityEntry is true if entry looks like a user ID, group ID or email address, as opposed to
+// a user name which isn't safe to use for permissions.
+func IsIdentityEntry(entry string) bool {
+	entry = strings.TrimSpace(entry)
+	if strings.Contains(entry, "@") {
+		return true
+	}
+	if len(entry) < 2 || strings.ToUpper(entry) != entry {
+		return false
+	}
+	switch entry[0] {
+	case 'U', 'W', 'S':
+		return true
+	}
+	return false
+}
+
+// userAllowed is true if the user matches any of the allow list entries.
+func userAllowed(user User, allowedUsers []string) bool {
+	for _, au := range allowedUsers {
+		if user.Matches(au) {
+			return true
+		}
+	}
+	return false
+}
+
+type cachedUser struct {
+	user    *User
+	expires time.Time
+}
+
+// UserDirectory looks up users, caching the results so every message doesn't cost a users.info call.
+type UserDirectory struct {
+	api *slack.Client
+	ttl time.Duration
+
+	lock          sync.Mutex
+	users         map[string]cachedUser
+	groups        map[string][]string // user ID to group IDs.
+	groupsExpires time.Time
+
+	// so only one usergroups.list call is made at a time.
+	refresh singleflight.Group
+}
+
+func NewUserDirectory(api *slack.Client, ttl time.Duration) *UserDirectory {
+	d := UserDirectory{}
+	d.api = api
+	d.ttl = ttl
+	d.users = make(map[string]cachedUser)
+	return &d
+}
+
+// Lookup returns the user for the Slack user ID.
+func (d *UserDirectory) Lookup(ctx context.Context, userID string) (*User, error) {
+	d.lock.Lock()
+	cached, ok := d.users[userID]
+	d.lock.Unlock()
+	if ok && time.Now().Before(cached.expires) {
+		return cached.user, nil
+	}
+
+	su, err := d.api.GetUserInfoContext(ctx, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	u := User{
+		ID:          su.ID,
+		TeamID:      su.TeamID,
+		Email:       su.Profile.Email,
+		Name:        su.Name,
+		DisplayName: su.Profile.DisplayName,
+		RealName:    su.RealName,
+		IsBot:       su.IsBot,
+		Groups:      d.groupsFor(ctx, su.ID),
+	}
+
+	d.lock.Lock()
+	d.users[userID] = cachedUser{user: &u, expires: time.Now().Add(d.ttl)}
+	d.lock.Unlock()
+	return &u, nil
+}
+
+// groupsFor returns the user group IDs for the user. Groups are loaded all at once, and if
+// they can't be loaded (usually missing the usergroups:read scope) the user just has no groups.
+// The lock isn't held while they're loaded, so cached lookups aren't held up.
+func (d *UserDirectory) groupsFor(ctx context.Context, userID string) []string {
+	d.lock.Lock()
+	stale := d.groups == nil || time.Now().After(d.groupsExpires)
+	d.lock.Unlock()
+
+	if stale {
+		d.refresh.Do("groups", func() (interface{}, error) {
+			d.refreshGroups(ctx)
+			return nil, nil
+		})
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	return d.groups[userID]
+}
+
+// refreshGroups loads every user group, then swaps them in.
+func (d *UserDirectory) refreshGroups(ctx context.Context) {
+	groups, err := d.api.GetUserGroupsContext(ctx, slack.GetUserGroupsOptionIncludeUsers(true))
+
+	members := make(map[string][]string)
+	if err == nil {
+		for _, g := range groups {
+			for _, member := range g.Users {
+				members[member] = append(members[member], g.ID)
+			}
+		}
+	}
+
+	d.lock.Lock()
+	defer d.lock.Unlock()
+	if err != nil {
+		// keep whatever we had before, and don't try again until the TTL is up.
+		logging.Errorf("unable to get user groups : %s", err.Error())
+		if d.groups == nil {
+			d.groups = make(map[string][]string)
+		}
+	} else {
+		d.groups = members
+	}
+	d.groupsExpires = time.Now().Add(d.ttl)
+}
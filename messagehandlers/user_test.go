@@ -0,0 +1,115 @@
+package messagehandlers
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/slack-go/slack"
+)
+
+func TestUserMatches(t *testing.T) {
+	u := User{ID: "U012AB3CD", Email: "Someone@Example.com", Name: "someone", Groups: []string{"S0614TZR7"}}
+
+	tests := []struct {
+		entry   string
+		matches bool
+	}{
+		{"U012AB3CD", true},
+		{" u012ab3cd ", true},
+		{"someone@example.com", true},
+		{"S0614TZR7", true},
+		{"someone", false}, // names can be changed by anyone.
+		{"U0OTHER00", false},
+		{"", false},
+	}
+	for _, tc := range tests {
+		if got := u.Matches(tc.entry); got != tc.matches {
+			t.Errorf("%q : expected %v, got %v", tc.entry, tc.matches, got)
+		}
+	}
+
+	// no email, so an empty entry can't match it.
+	if (User{ID: "U012AB3CD"}).Matches("  ") {
+		t.Error("blank entries shouldn't match")
+	}
+}
+
+func TestUserAllowed(t *testing.T) {
+	u := User{ID: "U012AB3CD", Email: "someone@example.com"}
+
+	if !userAllowed(u, []string{"U0OTHER00", "someone@example.com"}) {
+		t.Error("expected email to be allowed")
+	}
+	if !userAllowed(u, []string{"U012AB3CD"}) {
+		t.Error("expected ID to be allowed")
+	}
+	if userAllowed(u, []string{"someone", "other@example.com"}) {
+		t.Error("expected name and other email not to be allowed")
+	}
+	if userAllowed(u, nil) {
+		t.Error("expected an empty list not to allow anyone")
+	}
+}
+
+// fakeUserAPI serves users.info and usergroups.list, counting the calls to each.
+type fakeUserAPI struct {
+	lock  sync.Mutex
+	calls map[string]int
+}
+
+func newFakeUserAPI(t *testing.T) (*fakeUserAPI, *httptest.Server) {
+	f := fakeUserAPI{calls: make(map[string]int)}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		f.lock.Lock()
+		f.calls[r.URL.Path]++
+		f.lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		switch r.URL.Path {
+		case "/users.info":
+			w.Write([]byte(`{"ok":true,"user":{"id":"U012AB3CD","team_id":"T012AB3CD","name":"someone","real_name":"Some One",` +
+				`"profile":{"email":"someone@example.com","display_name":"some"}}}`))
+		case "/usergroups.list":
+			w.Write([]byte(`{"ok":true,"usergroups":[{"id":"S0614TZR7","users":["U012AB3CD","U0OTHER00"]},{"id":"S0OTHER00","users":["U0OTHER00"]}]}`))
+		default:
+			t.Errorf("unexpected call to %s", r.URL.Path)
+		}
+	}))
+	return &f, server
+}
+
+func (f *fakeUserAPI) count(path string) int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	return f.calls[path]
+}
+
+func TestUserDirectoryLookup(t *testing.T) {
+	f, server := newFakeUserAPI(t)
+	defer server.Close()
+
+	d := NewUserDirectory(slack.New("xoxb-test", slack.OptionAPIURL(server.URL+"/")), time.Hour)
+	u, err := d.Lookup(context.Background(), "U012AB3CD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.ID != "U012AB3CD" || u.Email != "someone@example.com" || u.Name != "someone" || u.DisplayName != "some" {
+		t.Errorf("unexpected user %+v", u)
+	}
+	if strings.Join(u.Groups, ",") != "S0614TZR7" {
+		t.Errorf("expected the user's group, got %v", u.Groups)
+	}
+
+	// cached, so Slack isn't asked again.
+	if _, err := d.Lookup(context.Background(), "U012AB3CD"); err != nil {
+		t.Fatal(err)
+	}
+	if f.count("/users.info") != 1 || f.count("/usergroups.list") != 1 {
+		t.Errorf("expected one call each, got %v", f.calls)
+	}
+}
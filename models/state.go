@@ -3,14 +3,12 @@ package models
 import (
 	"encoding/json"
 	"errors"
-	"fmt"
+	"github.com/kpfaulkner/wheatley/logging"
 	"io/ioutil"
 	"os"
 	"time"
 )
 
-var stateFileName = "state.json"
-
 type Environment struct {
 	Reporter  string `json:"reporter"` // who reported it.
 	State     string `json:"state"`    // state. general free text... keep it clean :P
@@ -20,14 +18,16 @@ type Environment struct {
 
 type State struct {
 	Environments []*Environment `json:"Environments"`
+
+	fileName string // where it's saved back to.
 }
 
-func LoadState() State {
-	var state State
+func LoadState(stateFileName string) State {
+	state := State{fileName: stateFileName}
 	stateFile, err := os.Open(stateFileName)
 	defer stateFile.Close()
 	if err != nil {
-		fmt.Println(err.Error())
+		logging.Errorf("unable to load %s : %s", stateFileName, err.Error())
 	}
 	jsonParser := json.NewDecoder(stateFile)
 	jsonParser.Decode(&state)
@@ -40,7 +40,7 @@ func (s *State) Save() error {
 		return err
 	}
 
-	err = ioutil.WriteFile(stateFileName, b, 0777)
+	err = ioutil.WriteFile(s.fileName, b, 0777)
 	// handle this error
 	if err != nil {
 		return err
@@ -0,0 +1,97 @@
+package secrets
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// KeyVaultAPIVersion is the Key Vault REST API version used.
+const KeyVaultAPIVersion = "7.4"
+
+// KeyVaultResource is what tokens for Key Vault in the public cloud have to be issued for.
+const KeyVaultResource = "https://vault.azure.net"
+
+// TokenFunc returns a bearer token for Key Vault, eg for KeyVaultResource.
+type TokenFunc func(ctx context.Context) (string, error)
+
+// KeyVaultProvider gets the latest version of secrets from Azure Key Vault over REST.
+type KeyVaultProvider struct {
+	vaultURL string // eg https://myvault.vault.azure.net
+	token    TokenFunc
+	client   *http.Client
+}
+
+func NewKeyVaultProvider(vaultURL string, token TokenFunc) *KeyVaultProvider {
+	kv := KeyVaultProvider{}
+	kv.vaultURL = strings.TrimRight(vaultURL, "/")
+	kv.token = token
+	kv.client = &http.Client{Timeout: 30 * time.Second}
+	return &kv
+}
+
+// KeyVaultError is the error Key Vault returns, eg SecretNotFound or Forbidden.
+type KeyVaultError struct {
+	StatusCode int
+	Code       string `json:"code"`
+	Message    string `json:"message"`
+}
+
+func (e *KeyVaultError) Error() string {
+	return fmt.Sprintf("key vault returned %d %s : %s", e.StatusCode, e.Code, e.Message)
+}
+
+type keyVaultSecret struct {
+	Value string `json:"value"`
+	ID    string `json:"id"`
+}
+
+func (kv *KeyVaultProvider) Get(ctx context.Context, name string) (string, error) {
+	token, err := kv.token(ctx)
+	if err != nil {
+		return "", fmt.Errorf("unable to get key vault token : %w", err)
+	}
+
+	u := fmt.Sprintf("%s/secrets/%s?api-version=%s", kv.vaultURL, url.PathEscape(name), KeyVaultAPIVersion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := kv.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1024*1024))
+	if err != nil {
+		return "", err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		envelope := struct {
+			Error KeyVaultError `json:"error"`
+		}{}
+		json.Unmarshal(body, &envelope)
+		kvErr := envelope.Error
+		kvErr.StatusCode = resp.StatusCode
+		if resp.StatusCode == http.StatusNotFound {
+			return "", fmt.Errorf("%s : %w", kvErr.Error(), ErrNotFound)
+		}
+		return "", &kvErr
+	}
+
+	var secret keyVaultSecret
+	if err := json.Unmarshal(body, &secret); err != nil {
+		return "", fmt.Errorf("unable to read key vault secret %s : %w", name, err)
+	}
+	return secret.Value, nil
+}
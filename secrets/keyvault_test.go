@@ -0,0 +1,72 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/kpfaulkner/wheatley/secrets/keyvaulttest"
+)
+
+func staticToken(token string) TokenFunc {
+	return func(ctx context.Context) (string, error) {
+		return token, nil
+	}
+}
+
+func TestKeyVaultProvider(t *testing.T) {
+	vault := keyvaulttest.NewServer()
+	defer vault.Close()
+	vault.Set("db-password", "old")
+	vault.Set("db-password", "hunter2")
+	vault.Set("denied", "nope")
+	vault.Deny("denied")
+
+	kv := NewKeyVaultProvider(vault.URL+"/", staticToken(keyvaulttest.Token))
+
+	t.Run("found", func(t *testing.T) {
+		value, err := kv.Get(context.Background(), "db-password")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if value != "hunter2" {
+			t.Errorf("expected the latest version, got %q", value)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		_, err := kv.Get(context.Background(), "missing")
+		if !errors.Is(err, ErrNotFound) {
+			t.Errorf("expected ErrNotFound, got %v", err)
+		}
+	})
+
+	t.Run("forbidden", func(t *testing.T) {
+		_, err := kv.Get(context.Background(), "denied")
+		if errors.Is(err, ErrNotFound) {
+			t.Fatalf("403 shouldn't be ErrNotFound, otherwise the chain carries on : %v", err)
+		}
+		kvErr := &KeyVaultError{}
+		if !errors.As(err, &kvErr) || kvErr.StatusCode != http.StatusForbidden || kvErr.Code != "Forbidden" {
+			t.Errorf("expected a 403 KeyVaultError, got %v", err)
+		}
+	})
+
+	t.Run("bad token", func(t *testing.T) {
+		_, err := NewKeyVaultProvider(vault.URL, staticToken("wrong")).Get(context.Background(), "db-password")
+		kvErr := &KeyVaultError{}
+		if !errors.As(err, &kvErr) || kvErr.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected a 401 KeyVaultError, got %v", err)
+		}
+	})
+
+	t.Run("no token", func(t *testing.T) {
+		noToken := func(ctx context.Context) (string, error) {
+			return "", errors.New("no credentials")
+		}
+		if _, err := NewKeyVaultProvider(vault.URL, noToken).Get(context.Background(), "db-password"); err == nil {
+			t.Error("expected an error")
+		}
+	})
+}
@@ -0,0 +1,140 @@
+// Package keyvaulttest is a fake Azure Key Vault, for testing anything that reads secrets
+// without a real vault (or credentials).
+package keyvaulttest
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+)
+
+// Token is the bearer token the server accepts unless Server.Token is changed.
+const Token = "fake-key-vault-token"
+
+type version struct {
+	id    string
+	value string
+}
+
+// Server serves GET /secrets/{name} and /secrets/{name}/{version} like Key Vault does,
+// including its error envelope. Set a secret again to rotate it.
+type Server struct {
+	*httptest.Server
+
+	// Token has to be sent as the bearer token, empty accepts anything.
+	Token string
+
+	lock     sync.Mutex
+	secrets  map[string][]version
+	denied   map[string]bool
+	requests int
+}
+
+// NewServer starts the server, call Close when done.
+func NewServer() *Server {
+	s := Server{}
+	s.Token = Token
+	s.secrets = make(map[string][]version)
+	s.denied = make(map[string]bool)
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return &s
+}
+
+// Set adds a new version of the secret, which becomes the latest.
+func (s *Server) Set(name string, value string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	id := fmt.Sprintf("%032x", len(s.secrets[name])+1)
+	s.secrets[name] = append(s.secrets[name], version{id: id, value: value})
+}
+
+// Delete removes every version of the secret.
+func (s *Server) Delete(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	delete(s.secrets, name)
+}
+
+// Deny makes reading the secret fail with 403, like an access policy that doesn't cover it.
+func (s *Server) Deny(name string) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.denied[name] = true
+}
+
+// Requests is how many requests have been made, to check caching.
+func (s *Server) Requests() int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests
+}
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+
+	if s.Token != "" && r.Header.Get("Authorization") != "Bearer "+s.Token {
+		writeError(w, http.StatusUnauthorized, "Unauthorized", "AKV10000: Request is missing a Bearer or PoP token.")
+		return
+	}
+	if r.URL.Query().Get("api-version") == "" {
+		writeError(w, http.StatusBadRequest, "BadParameter", "The 'api-version' query parameter is required.")
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "BadParameter", "Only GET is supported.")
+		return
+	}
+
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] != "secrets" {
+		writeError(w, http.StatusNotFound, "NotFound", "Unknown path.")
+		return
+	}
+
+	name := parts[1]
+	if s.denied[name] {
+		writeError(w, http.StatusForbidden, "Forbidden", fmt.Sprintf("The user, group or application does not have secrets get permission on key vault for secret %s.", name))
+		return
+	}
+	versions := s.secrets[name]
+	if len(versions) == 0 {
+		writeError(w, http.StatusNotFound, "SecretNotFound", fmt.Sprintf("A secret with (name/id) %s was not found in this key vault.", name))
+		return
+	}
+
+	found := versions[len(versions)-1]
+	if len(parts) == 3 && parts[2] != "" {
+		ok := false
+		for _, v := range versions {
+			if v.id == parts[2] {
+				found, ok = v, true
+			}
+		}
+		if !ok {
+			writeError(w, http.StatusNotFound, "SecretNotFound", fmt.Sprintf("A secret with (name/id) %s/%s was not found in this key vault.", name, parts[2]))
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"value": found.value,
+		"id":    fmt.Sprintf("%s/secrets/%s/%s", s.URL, name, found.id),
+		"attributes": map[string]interface{}{
+			"enabled": true,
+		},
+	})
+}
+
+func writeError(w http.ResponseWriter, status int, code string, message string) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error": map[string]string{"code": code, "message": message},
+	})
+}
@@ -0,0 +1,126 @@
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// References maps each secret name to the fields that referenced it, eg Database.ClientSecret.
+type References map[string][]string
+
+// Names is every secret referenced, sorted.
+func (r References) Names() []string {
+	names := []string{}
+	for name := range r {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve replaces every secret:// string in v (a pointer to a struct) with the secret's value,
+// going through structs, pointers, slices and map values. Every reference that can't be resolved
+// is in the error, not just the first.
+func Resolve(ctx context.Context, v interface{}, store *Store) (References, error) {
+	r := resolver{ctx: ctx, store: store, refs: References{}}
+	r.walk(reflect.ValueOf(v), "")
+	if len(r.problems) > 0 {
+		return r.refs, fmt.Errorf("unable to resolve secrets:\n  %s", strings.Join(r.problems, "\n  "))
+	}
+	return r.refs, nil
+}
+
+type resolver struct {
+	ctx      context.Context
+	store    *Store
+	refs     References
+	problems []string
+}
+
+func (r *resolver) walk(v reflect.Value, path string) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if !v.IsNil() {
+			r.walk(v.Elem(), path)
+		}
+
+	case reflect.Struct:
+		t := v.Type()
+		for i := 0; i < t.NumField(); i++ {
+			if t.Field(i).PkgPath != "" {
+				continue
+			}
+
+			// embedded structs are flattened, like they are in JSON.
+			if t.Field(i).Anonymous {
+				r.walk(v.Field(i), path)
+				continue
+			}
+			r.walk(v.Field(i), join(path, fieldName(t.Field(i))))
+		}
+
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			r.walk(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+		}
+
+	case reflect.Map:
+		if v.Type().Elem().Kind() != reflect.String {
+			for _, key := range v.MapKeys() {
+				r.walk(v.MapIndex(key), fmt.Sprintf("%s[%v]", path, key))
+			}
+			return
+		}
+
+		// map values can't be set in place.
+		for _, key := range v.MapKeys() {
+			fieldPath := fmt.Sprintf("%s[%v]", path, key)
+			if value, ok := r.resolve(v.MapIndex(key).String(), fieldPath); ok {
+				v.SetMapIndex(key, reflect.ValueOf(value).Convert(v.Type().Elem()))
+			}
+		}
+
+	case reflect.String:
+		if value, ok := r.resolve(v.String(), path); ok && v.CanSet() {
+			v.SetString(value)
+		}
+	}
+}
+
+// resolve returns the secret's value, and false if s isn't a reference (or couldn't be resolved).
+func (r *resolver) resolve(s string, path string) (string, bool) {
+	name, isRef, err := ParseReference(s)
+	if !isRef {
+		return "", false
+	}
+	if err != nil {
+		r.problems = append(r.problems, path+" : "+err.Error())
+		return "", false
+	}
+
+	r.refs[name] = append(r.refs[name], path)
+	value, err := r.store.Get(r.ctx, name)
+	if err != nil {
+		r.problems = append(r.problems, path+" : "+err.Error())
+		return "", false
+	}
+	return value, true
+}
+
+func join(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// fieldName is the name the field has in JSON.
+func fieldName(f reflect.StructField) string {
+	if tag := strings.Split(f.Tag.Get("json"), ",")[0]; tag != "" && tag != "-" {
+		return tag
+	}
+	return f.Name
+}
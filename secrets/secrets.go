@@ -0,0 +1,99 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ReferencePrefix marks a config value as the name of a secret rather than the value itself,
+// eg "ClientSecret": "secret://db-client-secret".
+const ReferencePrefix = "secret://"
+
+// ErrNotFound is returned by a Provider that doesn't have the secret, so the next one gets a go.
+var ErrNotFound = errors.New("secret not found")
+
+// Provider gets the current value of a secret by name.
+type Provider interface {
+	Get(ctx context.Context, name string) (string, error)
+}
+
+// names work everywhere, Key Vault only allows letters, numbers and dashes.
+var namePattern = regexp.MustCompile(`^[0-9a-zA-Z-]{1,127}$`)
+
+// ParseReference returns the secret name if value is a secret:// reference.
+func ParseReference(value string) (string, bool, error) {
+	if !strings.HasPrefix(value, ReferencePrefix) {
+		return "", false, nil
+	}
+	name := strings.TrimPrefix(value, ReferencePrefix)
+	if !namePattern.MatchString(name) {
+		return "", true, fmt.Errorf("%q isn't a valid secret name, only letters, numbers and dashes", name)
+	}
+	return name, true, nil
+}
+
+// EnvProvider reads secrets from env vars, db-password is <prefix>DB_PASSWORD.
+type EnvProvider struct {
+	prefix string
+	lookup func(string) (string, bool)
+}
+
+func NewEnvProvider(prefix string) *EnvProvider {
+	ep := EnvProvider{}
+	ep.prefix = prefix
+	ep.lookup = os.LookupEnv
+	return &ep
+}
+
+func (ep *EnvProvider) Get(ctx context.Context, name string) (string, error) {
+	envName := ep.prefix + strings.ToUpper(strings.Replace(name, "-", "_", -1))
+	if value, ok := ep.lookup(envName); ok {
+		return value, nil
+	}
+	return "", ErrNotFound
+}
+
+// FileProvider reads each secret from a file named after it, eg the way Docker and Kubernetes
+// mount secrets under /run/secrets. A trailing newline is ignored.
+type FileProvider struct {
+	dir string
+}
+
+func NewFileProvider(dir string) *FileProvider {
+	fp := FileProvider{}
+	fp.dir = dir
+	return &fp
+}
+
+func (fp *FileProvider) Get(ctx context.Context, name string) (string, error) {
+	b, err := ioutil.ReadFile(filepath.Join(fp.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", ErrNotFound
+		}
+		return "", err
+	}
+	return strings.TrimRight(string(b), "\r\n"), nil
+}
+
+// Chain asks each provider in turn, until one has the secret.
+type Chain []Provider
+
+func (c Chain) Get(ctx context.Context, name string) (string, error) {
+	for _, p := range c {
+		value, err := p.Get(ctx, name)
+		if err == nil {
+			return value, nil
+		}
+		if !errors.Is(err, ErrNotFound) {
+			return "", err
+		}
+	}
+	return "", fmt.Errorf("%s : %w", name, ErrNotFound)
+}
@@ -0,0 +1,119 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeProvider has values, or fails with err. calls counts every Get.
+type fakeProvider struct {
+	values map[string]string
+	err    error
+	calls  int
+}
+
+func (fp *fakeProvider) Get(ctx context.Context, name string) (string, error) {
+	fp.calls++
+	if fp.err != nil {
+		return "", fp.err
+	}
+	if value, ok := fp.values[name]; ok {
+		return value, nil
+	}
+	return "", ErrNotFound
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		value string
+		name  string
+		isRef bool
+		err   bool
+	}{
+		{"plain value", "", false, false},
+		{"secret://db-password", "db-password", true, false},
+		{"secret://", "", true, true},
+		{"secret://db_password", "", true, true},
+		{"secret://a/b", "", true, true},
+	}
+
+	for _, tc := range tests {
+		name, isRef, err := ParseReference(tc.value)
+		if name != tc.name || isRef != tc.isRef || (err != nil) != tc.err {
+			t.Errorf("%q : expected %q %v %v, got %q %v %v", tc.value, tc.name, tc.isRef, tc.err, name, isRef, err)
+		}
+	}
+}
+
+func TestEnvProvider(t *testing.T) {
+	ep := NewEnvProvider("WHEATLEY_SECRET_")
+	ep.lookup = func(name string) (string, bool) {
+		if name == "WHEATLEY_SECRET_DB_PASSWORD" {
+			return "hunter2", true
+		}
+		return "", false
+	}
+
+	if value, err := ep.Get(context.Background(), "db-password"); err != nil || value != "hunter2" {
+		t.Errorf("expected hunter2, got %q %v", value, err)
+	}
+	if _, err := ep.Get(context.Background(), "other"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFileProvider(t *testing.T) {
+	dir, err := ioutil.TempDir("", "secrets")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	if err := ioutil.WriteFile(filepath.Join(dir, "db-password"), []byte("hunter2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	fp := NewFileProvider(dir)
+	if value, err := fp.Get(context.Background(), "db-password"); err != nil || value != "hunter2" {
+		t.Errorf("expected hunter2 without the newline, got %q %v", value, err)
+	}
+	if _, err := fp.Get(context.Background(), "other"); err != ErrNotFound {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestChain(t *testing.T) {
+	first := &fakeProvider{values: map[string]string{"shared": "first"}}
+	second := &fakeProvider{values: map[string]string{"shared": "second", "only-second": "second"}}
+	chain := Chain{first, second}
+
+	tests := []struct {
+		name  string
+		value string
+		err   error
+	}{
+		{"shared", "first", nil},       // the first provider with it wins.
+		{"only-second", "second", nil}, // not found falls through.
+		{"missing", "", ErrNotFound},   // nobody has it.
+	}
+	for _, tc := range tests {
+		value, err := chain.Get(context.Background(), tc.name)
+		if value != tc.value || !errors.Is(err, tc.err) || (err == nil) != (tc.err == nil) {
+			t.Errorf("%s : expected %q %v, got %q %v", tc.name, tc.value, tc.err, value, err)
+		}
+	}
+
+	// anything other than not found stops the chain, rather than quietly using another value.
+	failing := &fakeProvider{err: errors.New("vault unreachable")}
+	after := &fakeProvider{values: map[string]string{"shared": "after"}}
+	value, err := Chain{failing, after}.Get(context.Background(), "shared")
+	if err == nil || errors.Is(err, ErrNotFound) || value != "" {
+		t.Errorf("expected the provider's error, got %q %v", value, err)
+	}
+	if after.calls != 0 {
+		t.Errorf("expected the chain to stop, later provider was called %d time(s)", after.calls)
+	}
+}
@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/kpfaulkner/wheatley/logging"
+)
+
+// DefaultTTL is how long a secret is cached before it's fetched again.
+const DefaultTTL = 1 * time.Hour
+
+type cachedSecret struct {
+	value   string
+	fetched time.Time
+}
+
+// Store caches secrets from a Provider. Once a secret has been fetched it's kept, so if the
+// provider can't be reached later on the last value carries on being used.
+type Store struct {
+	provider Provider
+	ttl      time.Duration
+
+	lock     sync.Mutex
+	cache    map[string]cachedSecret
+	onChange []func(name string)
+}
+
+func NewStore(provider Provider, ttl time.Duration) *Store {
+	s := Store{}
+	s.provider = provider
+	s.ttl = ttl
+	s.cache = make(map[string]cachedSecret)
+	return &s
+}
+
+// OnChange is called with the name of any secret that's changed when refreshed, ie rotated.
+func (s *Store) OnChange(f func(name string)) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.onChange = append(s.onChange, f)
+}
+
+// Get returns the cached value if it's newer than the TTL, otherwise fetches it.
+func (s *Store) Get(ctx context.Context, name string) (string, error) {
+	s.lock.Lock()
+	cached, ok := s.cache[name]
+	s.lock.Unlock()
+	if ok && time.Since(cached.fetched) < s.ttl {
+		return cached.value, nil
+	}
+
+	value, err := s.fetch(ctx, name)
+	if err != nil {
+		if ok {
+			logging.FromContext(ctx).Warnf("unable to refresh secret %s, using the cached value : %s", name, err.Error())
+			return cached.value, nil
+		}
+		return "", err
+	}
+	return value, nil
+}
+
+// Refresh fetches every secret that's been asked for, whether or not its TTL is up.
+func (s *Store) Refresh(ctx context.Context) {
+	s.lock.Lock()
+	names := []string{}
+	for name := range s.cache {
+		names = append(names, name)
+	}
+	s.lock.Unlock()
+
+	for _, name := range names {
+		if _, err := s.fetch(ctx, name); err != nil {
+			logging.FromContext(ctx).Warnf("unable to refresh secret %s : %s", name, err.Error())
+		}
+	}
+}
+
+// Run refreshes every TTL until ctx is done.
+func (s *Store) Run(ctx context.Context) {
+	ticker := time.NewTicker(s.ttl)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Refresh(ctx)
+		}
+	}
+}
+
+func (s *Store) fetch(ctx context.Context, name string) (string, error) {
+	value, err := s.provider.Get(ctx, name)
+	if err != nil {
+		return "", err
+	}
+
+	s.lock.Lock()
+	previous, seen := s.cache[name]
+	s.cache[name] = cachedSecret{value: value, fetched: time.Now()}
+	onChange := append([]func(string){}, s.onChange...)
+	s.lock.Unlock()
+
+	if seen && previous.value != value {
+		logging.FromContext(ctx).Infof("secret %s has changed", name)
+		for _, f := range onChange {
+			f(name)
+		}
+	}
+	return value, nil
+}
@@ -0,0 +1,90 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestStoreCaches(t *testing.T) {
+	provider := &fakeProvider{values: map[string]string{"db-password": "hunter2"}}
+	store := NewStore(provider, time.Hour)
+
+	for i := 0; i < 3; i++ {
+		if value, err := store.Get(context.Background(), "db-password"); err != nil || value != "hunter2" {
+			t.Fatalf("expected hunter2, got %q %v", value, err)
+		}
+	}
+	if provider.calls != 1 {
+		t.Errorf("expected one fetch within the TTL, got %d", provider.calls)
+	}
+
+	if _, err := store.Get(context.Background(), "missing"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestStoreTTL(t *testing.T) {
+	provider := &fakeProvider{values: map[string]string{"db-password": "hunter2"}}
+	store := NewStore(provider, 20*time.Millisecond)
+
+	store.Get(context.Background(), "db-password")
+	provider.values["db-password"] = "rotated"
+	time.Sleep(40 * time.Millisecond)
+
+	if value, _ := store.Get(context.Background(), "db-password"); value != "rotated" {
+		t.Errorf("expected the secret to be fetched again after the TTL, got %q", value)
+	}
+	if provider.calls != 2 {
+		t.Errorf("expected 2 fetches, got %d", provider.calls)
+	}
+
+	// the provider going away doesn't lose the secret.
+	provider.err = errors.New("vault unreachable")
+	time.Sleep(40 * time.Millisecond)
+	if value, err := store.Get(context.Background(), "db-password"); err != nil || value != "rotated" {
+		t.Errorf("expected the cached value, got %q %v", value, err)
+	}
+}
+
+func TestStoreRefresh(t *testing.T) {
+	provider := &fakeProvider{values: map[string]string{"db-password": "hunter2", "api-key": "abc"}}
+	store := NewStore(provider, time.Hour)
+
+	changed := []string{}
+	store.OnChange(func(name string) {
+		changed = append(changed, name)
+	})
+
+	store.Get(context.Background(), "db-password")
+	store.Get(context.Background(), "api-key")
+
+	// nothing has changed, so nothing is reported.
+	store.Refresh(context.Background())
+	if len(changed) != 0 {
+		t.Errorf("expected no changes, got %v", changed)
+	}
+
+	provider.values["db-password"] = "rotated"
+	store.Refresh(context.Background())
+	if len(changed) != 1 || changed[0] != "db-password" {
+		t.Errorf("expected db-password to have changed, got %v", changed)
+	}
+
+	// Refresh ignores the TTL, so the new value is already cached.
+	calls := provider.calls
+	if value, _ := store.Get(context.Background(), "db-password"); value != "rotated" {
+		t.Errorf("expected the rotated value, got %q", value)
+	}
+	if provider.calls != calls {
+		t.Errorf("expected the rotated value to come from the cache")
+	}
+
+	// a failed refresh keeps the old value, and isn't a change.
+	provider.err = errors.New("vault unreachable")
+	store.Refresh(context.Background())
+	if value, _ := store.Get(context.Background(), "db-password"); value != "rotated" || len(changed) != 1 {
+		t.Errorf("expected rotated and no more changes, got %q %v", value, changed)
+	}
+}